@@ -0,0 +1,106 @@
+package cgpt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/openai"
+)
+
+// AzureConfig configures the azure backend.
+type AzureConfig struct {
+	// Endpoint is the resource endpoint, e.g. https://my-resource.openai.azure.com.
+	Endpoint string `yaml:"endpoint"`
+	// Deployment is the deployment name. It defaults to the model name.
+	Deployment string `yaml:"deployment"`
+	// APIVersion is the REST API version.
+	APIVersion string `yaml:"apiVersion"`
+	// TokenCommand, if set, is run to obtain a Microsoft Entra ID (Azure AD)
+	// bearer token, which is used instead of an API key. For example:
+	// az account get-access-token --resource https://cognitiveservices.azure.com --query accessToken -o tsv
+	TokenCommand string `yaml:"tokenCommand"`
+}
+
+const defaultAzureAPIVersion = "2024-10-21"
+
+func init() {
+	RegisterBackend(Backend{
+		Name:         "azure",
+		DefaultModel: "gpt-4o",
+		New:          newAzureModel,
+		Capabilities: BackendCapabilities{Streaming: true, SystemPrompt: true, Embeddings: true},
+	})
+}
+
+func newAzureModel(cfg *Config, mo *InferenceProviderOptions) (llms.Model, error) {
+	az := cfg.Azure
+	if az.Endpoint == "" {
+		return nil, errors.New("azure: endpoint is not set (set azure.endpoint or AZURE_OPENAI_ENDPOINT)")
+	}
+	deployment := az.Deployment
+	if deployment == "" {
+		deployment = cfg.Model
+	}
+	apiVersion := az.APIVersion
+	if apiVersion == "" {
+		apiVersion = defaultAzureAPIVersion
+	}
+
+	// The deployment takes the place of the model in Azure request URLs.
+	options := []openai.Option{
+		openai.WithModel(deployment),
+		openai.WithEmbeddingModel(deployment),
+		openai.WithBaseURL(az.Endpoint),
+		openai.WithAPIVersion(apiVersion),
+	}
+	switch {
+	case az.TokenCommand != "":
+		token, err := runTokenCommand(az.TokenCommand)
+		if err != nil {
+			return nil, fmt.Errorf("azure: %w", err)
+		}
+		options = append(options, openai.WithAPIType(openai.APITypeAzureAD), openai.WithToken(token))
+	case cfg.AzureAPIKey != "":
+		options = append(options, openai.WithAPIType(openai.APITypeAzure), openai.WithToken(cfg.AzureAPIKey))
+	default:
+		return nil, errors.New("azure: no credentials (set AZURE_OPENAI_API_KEY or azure.tokenCommand)")
+	}
+	if client := withRequestFields(mo.HTTPClient, cfg.openAIRequestFields()); client != nil {
+		options = append(options, openai.WithHTTPClient(client))
+	}
+	if mo.OpenAICompatUseLegacyMaxTokens {
+		options = append(options, openai.WithUseLegacyMaxTokens(true))
+	}
+	return openai.New(options...)
+}
+
+// runTokenCommand runs command with the system shell and returns its trimmed output.
+func runTokenCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("token command failed: %w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("token command failed: %w", err)
+	}
+	token := strings.TrimSpace(string(out))
+	if token == "" {
+		return "", errors.New("token command returned no output")
+	}
+	return token, nil
+}
@@ -0,0 +1,116 @@
+package cgpt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// newAzureStandIn returns a server implementing the Azure OpenAI chat
+// completions URL scheme, which checks requests with the check function.
+func newAzureStandIn(t *testing.T, check func(r *http.Request)) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/openai/deployments/my-deployment/chat/completions" {
+			http.Error(w, `{"error":{"code":"DeploymentNotFound"}}`, http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("api-version") == "" {
+			http.Error(w, `{"error":{"code":"MissingApiVersionParameter"}}`, http.StatusBadRequest)
+			return
+		}
+		check(r)
+		writeOpenAIResponse(w, r, "hello ", "from ", "azure")
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestAzureBackend(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("token command uses a POSIX shell")
+	}
+	tests := []struct {
+		name       string
+		cfg        Config
+		wantHeader string
+		wantValue  string
+		wantAPIVer string
+	}{
+		{
+			name:       "api key",
+			cfg:        Config{AzureAPIKey: "azure-key", Azure: AzureConfig{Deployment: "my-deployment", APIVersion: "2024-06-01"}},
+			wantHeader: "api-key",
+			wantValue:  "azure-key",
+			wantAPIVer: "2024-06-01",
+		},
+		{
+			name:       "token command",
+			cfg:        Config{Model: "my-deployment", Azure: AzureConfig{TokenCommand: "echo entra-token"}},
+			wantHeader: "Authorization",
+			wantValue:  "Bearer entra-token",
+			wantAPIVer: defaultAzureAPIVersion,
+		},
+	}
+	for _, tt := range tests {
+		for _, stream := range []bool{false, true} {
+			name := tt.name
+			if stream {
+				name += " streaming"
+			}
+			t.Run(name, func(t *testing.T) {
+				srv := newAzureStandIn(t, func(r *http.Request) {
+					if got := r.Header.Get(tt.wantHeader); got != tt.wantValue {
+						t.Errorf("header %s = %q, want %q", tt.wantHeader, got, tt.wantValue)
+					}
+					if got := r.URL.Query().Get("api-version"); got != tt.wantAPIVer {
+						t.Errorf("api-version = %q, want %q", got, tt.wantAPIVer)
+					}
+				})
+				cfg := tt.cfg
+				cfg.Backend = "azure"
+				cfg.Azure.Endpoint = srv.URL
+				cfg.Retry.MaxAttempts = 1
+				model, err := InitializeModel(&cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var streamed strings.Builder
+				var options []llms.CallOption
+				if stream {
+					options = append(options, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+						streamed.Write(chunk)
+						return nil
+					}))
+				}
+				resp, err := model.GenerateContent(context.Background(), []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "hi")}, options...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := resp.Choices[0].Content; got != "hello from azure" {
+					t.Errorf("content = %q", got)
+				}
+				if stream && streamed.String() != "hello from azure" {
+					t.Errorf("streamed = %q", streamed.String())
+				}
+			})
+		}
+	}
+}
+
+func TestAzureBackendRequiresCredentials(t *testing.T) {
+	cfg := &Config{Backend: "azure", Model: "gpt-4o", Azure: AzureConfig{Endpoint: "https://example.openai.azure.com"}}
+	if _, err := InitializeModel(cfg); err == nil || !strings.Contains(err.Error(), "credentials") {
+		t.Errorf("InitializeModel() error = %v, want missing credentials", err)
+	}
+	cfg = &Config{Backend: "azure", Model: "gpt-4o", AzureAPIKey: "key"}
+	if _, err := InitializeModel(cfg); err == nil || !strings.Contains(err.Error(), "endpoint") {
+		t.Errorf("InitializeModel() error = %v, want missing endpoint", err)
+	}
+}
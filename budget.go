@@ -0,0 +1,117 @@
+package cgpt
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Budgets limits spending, in US dollars, per day, per month and per
+// session. Daily and monthly spending is read from the usage ledger, so it
+// includes other runs. Costs are only known once a completion finishes, so
+// the completion that crosses a limit is not stopped; the ones after it are.
+type Budgets struct {
+	Daily   BudgetLimit `yaml:"daily"`
+	Monthly BudgetLimit `yaml:"monthly"`
+	Session BudgetLimit `yaml:"session"`
+}
+
+// BudgetLimit is a spending limit in US dollars. Zero is unlimited.
+type BudgetLimit struct {
+	// Soft prints a warning once spending reaches it.
+	Soft float64 `yaml:"soft"`
+	// Hard refuses completions once spending reaches it.
+	Hard float64 `yaml:"hard"`
+}
+
+func (l BudgetLimit) set() bool {
+	return l.Soft > 0 || l.Hard > 0
+}
+
+// budgeted reports whether any spending limit is set.
+func (cfg *Config) budgeted() bool {
+	b := cfg.Budgets
+	return cfg.Budget > 0 || b.Session.set() || b.Daily.set() || b.Monthly.set()
+}
+
+// warnUnpriced warns, once per model, that spending on a model without a
+// known price does not count towards the budgets, if any are set.
+func (s *CompletionService) warnUnpriced(backend, model string) {
+	if s.cfg.budgeted() {
+		s.noticef("no price is known for %s/%s, so its spending does not count towards budgets; set one under 'prices'", backend, model)
+	}
+}
+
+// ErrBudgetExceeded is returned when a completion is refused because
+// spending has reached a hard budget limit.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// checkBudget refuses a completion if spending has reached a hard limit,
+// and warns once spending reaches a soft limit, or when the model's
+// spending cannot be counted. The --budget flag caps the session.
+func (s *CompletionService) checkBudget() error {
+	if _, ok := s.cfg.modelPrice(s.cfg.Backend, s.cfg.Model); !ok {
+		s.warnUnpriced(s.cfg.Backend, s.cfg.Model)
+	}
+	b := s.cfg.Budgets
+	session := b.Session
+	if s.cfg.Budget > 0 && (session.Hard == 0 || s.cfg.Budget < session.Hard) {
+		session.Hard = s.cfg.Budget
+	}
+	type check struct {
+		period string
+		limit  BudgetLimit
+		spent  float64
+	}
+	s.usageMu.Lock()
+	spent := s.sessionUsage.Cost
+	s.usageMu.Unlock()
+	checks := []check{{"session", session, spent}}
+	if b.Daily.set() || b.Monthly.set() {
+		if s.usageLedger == "" {
+			s.noticef("daily and monthly budgets need the usage ledger, which is off")
+		} else {
+			day, month, err := ledgerSpending(s.usageLedger, time.Now())
+			if err != nil {
+				return fmt.Errorf("failed to read usage ledger: %w", err)
+			}
+			checks = append(checks, check{"daily", b.Daily, day}, check{"monthly", b.Monthly, month})
+		}
+	}
+	for _, c := range checks {
+		switch {
+		case c.limit.Hard > 0 && c.spent >= c.limit.Hard:
+			return fmt.Errorf("%w: spent $%.4f of the %s budget of %s", ErrBudgetExceeded, c.spent, c.period, dollars(c.limit.Hard))
+		case c.limit.Soft > 0 && c.spent >= c.limit.Soft:
+			s.noticef("%s spending is over the soft budget of %s", c.period, dollars(c.limit.Soft))
+		}
+	}
+	return nil
+}
+
+// dollars formats an amount of US dollars as written in the configuration.
+func dollars(v float64) string {
+	return "$" + strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// ledgerSpending returns the cost of the completions in the ledger at path
+// on the day and in the month of now.
+func ledgerSpending(path string, now time.Time) (day, month float64, err error) {
+	entries, err := ReadLedger(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	now = now.Local()
+	for _, e := range entries {
+		t := e.Time.Local()
+		if t.Year() != now.Year() || t.Month() != now.Month() {
+			continue
+		}
+		month += e.Cost
+		if t.Day() == now.Day() {
+			day += e.Cost
+		}
+	}
+	return day, month, nil
+}
@@ -0,0 +1,101 @@
+package cgpt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckBudget(t *testing.T) {
+	tests := []struct {
+		name string
+		// budget and budgets configure the limits; each completion costs $1.
+		budget  float64
+		budgets Budgets
+		// ledger is the cost already recorded today and earlier this month.
+		today, earlier float64
+		noLedger       bool
+		// unpriced uses a model without a known price.
+		unpriced bool
+		// wantCalls is the number of completions made before one is refused,
+		// or -1 if none is.
+		wantCalls  int
+		wantStderr string
+	}{
+		{name: "unlimited", wantCalls: -1},
+		{name: "invocation budget", budget: 2, wantCalls: 2},
+		{name: "session hard limit", budgets: Budgets{Session: BudgetLimit{Hard: 3}}, wantCalls: 3},
+		{name: "invocation budget below session limit", budget: 1, budgets: Budgets{Session: BudgetLimit{Hard: 3}}, wantCalls: 1},
+		{name: "session soft limit", budgets: Budgets{Session: BudgetLimit{Soft: 1.5}}, wantCalls: -1, wantStderr: "session spending is over the soft budget of $1.5"},
+		{name: "daily hard limit", budgets: Budgets{Daily: BudgetLimit{Hard: 5}}, today: 4, wantCalls: 1},
+		{name: "daily limit ignores earlier days", budgets: Budgets{Daily: BudgetLimit{Hard: 5}}, earlier: 10, wantCalls: -1},
+		{name: "monthly hard limit", budgets: Budgets{Monthly: BudgetLimit{Hard: 12}}, today: 1, earlier: 10, wantCalls: 1},
+		{name: "daily soft limit", budgets: Budgets{Daily: BudgetLimit{Soft: 2}}, today: 2, wantCalls: -1, wantStderr: "daily spending is over the soft budget of $2"},
+		{name: "ledger off", budgets: Budgets{Daily: BudgetLimit{Hard: 1}}, noLedger: true, wantCalls: -1, wantStderr: "daily and monthly budgets need the usage ledger"},
+		{name: "unpriced model", budget: 2, unpriced: true, wantCalls: -1, wantStderr: "no price is known for custom/local"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ledger := filepath.Join(t.TempDir(), "usage.jsonl")
+			now := time.Now()
+			if tt.today > 0 {
+				if err := AppendLedger(ledger, LedgerEntry{Time: now, Usage: Usage{Cost: tt.today}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.earlier > 0 {
+				if now.Day() == 1 {
+					t.Skip("no earlier day this month")
+				}
+				if err := AppendLedger(ledger, LedgerEntry{Time: now.AddDate(0, 0, -1), Usage: Usage{Cost: tt.earlier}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg := &Config{
+				Backend: "dummy",
+				Model:   "dummy",
+				Budget:  tt.budget,
+				Budgets: tt.budgets,
+				Prices:  map[string]Price{"dummy:*": {Input: 1}},
+			}
+			if tt.unpriced {
+				cfg.Backend, cfg.Model, cfg.Prices = "custom", "local", nil
+			}
+			model := &stubModel{chunks: []string{"ok"}, info: map[string]any{"InputTokens": 1000000}}
+			var stderr bytes.Buffer
+			s, err := NewCompletionService(cfg, model, WithStderr(&stderr), WithStdout(&bytes.Buffer{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.noLedger {
+				s.usageLedger = ledger
+			}
+
+			calls := -1
+			for i := range 5 {
+				s.payload.addUserMessage("hi")
+				_, err := s.PerformCompletion(context.Background(), s.payload, PerformCompletionConfig{})
+				if errors.Is(err, ErrBudgetExceeded) {
+					calls = i
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("refused after %d completions, want %d", calls, tt.wantCalls)
+			}
+			if calls >= 0 && model.calls != calls {
+				t.Errorf("model called %d times, want %d", model.calls, calls)
+			}
+			if tt.wantStderr != "" && strings.Count(stderr.String(), tt.wantStderr) != 1 {
+				t.Errorf("stderr should contain %q once:\n%s", tt.wantStderr, stderr.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,371 @@
+package cgpt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// CacheMode controls whether responses are read from and written to the
+// response cache.
+type CacheMode string
+
+const (
+	CacheOff       CacheMode = "off"
+	CacheRead      CacheMode = "read"
+	CacheWrite     CacheMode = "write"
+	CacheReadWrite CacheMode = "readwrite"
+)
+
+// ParseCacheMode parses a cache mode. The empty string is CacheOff.
+func ParseCacheMode(s string) (CacheMode, error) {
+	switch m := CacheMode(strings.ToLower(s)); m {
+	case "":
+		return CacheOff, nil
+	case CacheOff, CacheRead, CacheWrite, CacheReadWrite:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid cache mode %q: want off, read, write or readwrite", s)
+}
+
+func (m CacheMode) reads() bool  { return m == CacheRead || m == CacheReadWrite }
+func (m CacheMode) writes() bool { return m == CacheWrite || m == CacheReadWrite }
+
+const (
+	// defaultCacheTTL is how long cached responses are used for.
+	defaultCacheTTL = 7 * 24 * time.Hour
+	// defaultCacheMaxBytes bounds the size of the cache directory.
+	defaultCacheMaxBytes = 100 << 20
+)
+
+// DefaultCacheDir returns the default response cache directory,
+// ~/.cgpt/cache.
+func DefaultCacheDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	return filepath.Join(home, ".cgpt", "cache"), nil
+}
+
+// ResponseCache stores responses in a directory, one file per key. Entries
+// expire after TTL, and the oldest entries are evicted when the directory
+// grows beyond MaxBytes.
+type ResponseCache struct {
+	Dir      string
+	TTL      time.Duration
+	MaxBytes int64
+}
+
+// cachedResponse is a cache entry.
+type cachedResponse struct {
+	Created time.Time `json:"created"`
+	Backend string    `json:"backend"`
+	Model   string    `json:"model"`
+	// Chunks are the streamed chunks of the first choice, replayed on a hit.
+	Chunks  []string              `json:"chunks"`
+	Choices []*llms.ContentChoice `json:"choices"`
+}
+
+func (c *ResponseCache) path(key string) string {
+	return filepath.Join(c.Dir, key+".json")
+}
+
+func (c *ResponseCache) ttl() time.Duration {
+	if c.TTL > 0 {
+		return c.TTL
+	}
+	return defaultCacheTTL
+}
+
+// get returns the entry for key, if present and not expired.
+func (c *ResponseCache) get(key string) (*cachedResponse, bool) {
+	b, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var r cachedResponse
+	if err := json.Unmarshal(b, &r); err != nil || time.Since(r.Created) > c.ttl() {
+		return nil, false
+	}
+	return &r, true
+}
+
+// put stores the entry for key, then evicts expired and excess entries.
+func (c *ResponseCache) put(key string, r *cachedResponse) error {
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first, so readers never see a partial entry.
+	f, err := os.CreateTemp(c.Dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return c.evict()
+}
+
+// evict removes expired entries, then the oldest entries until the cache
+// fits in MaxBytes.
+func (c *ResponseCache) evict() error {
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return err
+	}
+	type file struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var (
+		files []file
+		total int64
+	)
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(c.Dir, e.Name())
+		if time.Since(info.ModTime()) > c.ttl() {
+			os.Remove(path)
+			continue
+		}
+		files = append(files, file{path, info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+	maxBytes := c.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultCacheMaxBytes
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		if total <= maxBytes {
+			break
+		}
+		if err := os.Remove(f.path); err == nil {
+			total -= f.size
+		}
+	}
+	return nil
+}
+
+// cacheKey returns the key for a call: a hash of the backend, model,
+// request fields, messages, call options and the index of the completion
+// among independent completions of the same request.
+func cacheKey(backend, model string, fields map[string]any, messages []llms.MessageContent, opts llms.CallOptions, completion int) (string, error) {
+	b, err := json.Marshal(struct {
+		Backend    string                `json:"backend"`
+		Model      string                `json:"model"`
+		Fields     map[string]any        `json:"fields,omitempty"`
+		Messages   []llms.MessageContent `json:"messages"`
+		Options    llms.CallOptions      `json:"options"`
+		Completion int                   `json:"completion,omitempty"`
+	}{backend, model, fields, messages, opts, completion})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+type completionIndexKey struct{}
+
+// withCompletionIndex marks ctx as the call for the i-th of several
+// independent completions of the same request, which are otherwise
+// identical, so that each is cached separately.
+func withCompletionIndex(ctx context.Context, i int) context.Context {
+	return context.WithValue(ctx, completionIndexKey{}, i)
+}
+
+func completionIndex(ctx context.Context) int {
+	i, _ := ctx.Value(completionIndexKey{}).(int)
+	return i
+}
+
+// CacheModel is an llms.Model that serves responses from a ResponseCache,
+// and stores the responses of the wrapped model in it, as Mode allows.
+// Streamed responses are replayed as the chunks they arrived in. Responses
+// served from the cache report no token usage.
+type CacheModel struct {
+	Model llms.Model
+	// Backend and ModelID identify the model in cache keys.
+	Backend string
+	ModelID string
+	// Fields are request settings that are not call options, such as the
+	// fields added by openAIRequestFields. They are part of cache keys.
+	Fields map[string]any
+	Mode   CacheMode
+	Cache  *ResponseCache
+
+	// OnHit, if set, is called when a response is served from the cache.
+	OnHit func()
+	// OnError, if set, is called when a response cannot be cached.
+	OnError func(err error)
+
+	mu  sync.Mutex
+	hit bool
+}
+
+func (m *CacheModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+func (m *CacheModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	opts := llms.CallOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	m.setHit(false)
+	key, err := cacheKey(m.Backend, m.ModelID, m.Fields, messages, opts, completionIndex(ctx))
+	if err != nil {
+		m.cacheError(err)
+		return m.Model.GenerateContent(ctx, messages, options...)
+	}
+
+	if m.Mode.reads() {
+		if r, ok := m.Cache.get(key); ok {
+			m.setHit(true)
+			if m.OnHit != nil {
+				m.OnHit()
+			}
+			return r.replay(ctx, opts.StreamingFunc)
+		}
+	}
+	if !m.Mode.writes() {
+		return m.Model.GenerateContent(ctx, messages, options...)
+	}
+
+	var chunks []string
+	if opts.StreamingFunc != nil {
+		options = append(slices.Clone(options), llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+			chunks = append(chunks, string(chunk))
+			return opts.StreamingFunc(ctx, chunk)
+		}))
+	}
+	resp, err := m.Model.GenerateContent(ctx, messages, options...)
+	if err != nil || len(resp.Choices) == 0 {
+		return resp, err
+	}
+	if len(chunks) == 0 {
+		chunks = []string{resp.Choices[0].Content}
+	}
+	r := &cachedResponse{Created: time.Now(), Backend: m.Backend, Model: m.ModelID, Chunks: chunks}
+	for _, c := range resp.Choices {
+		c := *c
+		c.GenerationInfo = nil
+		r.Choices = append(r.Choices, &c)
+	}
+	if err := m.Cache.put(key, r); err != nil {
+		m.cacheError(err)
+	}
+	return resp, nil
+}
+
+// replay returns the cached response, streaming its chunks if asked to.
+func (r *cachedResponse) replay(ctx context.Context, stream func(context.Context, []byte) error) (*llms.ContentResponse, error) {
+	if stream != nil {
+		for _, chunk := range r.Chunks {
+			if err := stream(ctx, []byte(chunk)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, c := range r.Choices {
+		c.GenerationInfo = map[string]any{"CacheHit": true}
+	}
+	return &llms.ContentResponse{Choices: r.Choices}, nil
+}
+
+func (m *CacheModel) cacheError(err error) {
+	if m.OnError != nil {
+		m.OnError(err)
+	}
+}
+
+func (m *CacheModel) setHit(hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hit = hit
+}
+
+// ActiveModel reports the cached backend and model for a cache hit, and
+// otherwise the model that served the call, if the wrapped model reports it.
+func (m *CacheModel) ActiveModel() (backend, model string) {
+	m.mu.Lock()
+	hit := m.hit
+	m.mu.Unlock()
+	if hit {
+		return m.Backend, m.ModelID
+	}
+	if r, ok := m.Model.(activeModelReporter); ok {
+		return r.ActiveModel()
+	}
+	return "", ""
+}
+
+// cacheFields returns the settings that shape responses but are not sent
+// as call options, for cache keys: the OpenAI request fields, and the
+// thinking budget and reasoning effort for backends that do not take them
+// as request fields.
+func (cfg *Config) cacheFields() map[string]any {
+	fields := cfg.openAIRequestFields()
+	if b := cfg.thinkingBudget(); b > 0 {
+		fields["thinking_budget"] = b
+	}
+	if effort := cfg.reasoningEffort(); effort != "" {
+		fields["reasoning_effort"] = effort
+	}
+	return fields
+}
+
+// withResponseCache wraps m with the response cache, if enabled.
+func (cfg *Config) withResponseCache(m llms.Model) (llms.Model, error) {
+	mode, err := ParseCacheMode(cfg.Cache)
+	if err != nil {
+		return nil, err
+	}
+	if mode == CacheOff {
+		return m, nil
+	}
+	dir, err := DefaultCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return &CacheModel{
+		Model:   m,
+		Backend: cfg.Backend,
+		ModelID: cfg.Model,
+		Fields:  cfg.cacheFields(),
+		Mode:    mode,
+		Cache:   &ResponseCache{Dir: dir, TTL: cfg.CacheTTL, MaxBytes: cfg.CacheMaxBytes},
+	}, nil
+}
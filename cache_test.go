@@ -0,0 +1,156 @@
+package cgpt
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestCacheModel(t *testing.T) {
+	tests := []struct {
+		name string
+		mode CacheMode
+		// wantCalls is the number of times the wrapped model is called for
+		// two identical streaming calls.
+		wantCalls int
+		wantFiles int
+	}{
+		{name: "readwrite", mode: CacheReadWrite, wantCalls: 1, wantFiles: 1},
+		{name: "write", mode: CacheWrite, wantCalls: 2, wantFiles: 1},
+		{name: "read", mode: CacheRead, wantCalls: 2, wantFiles: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			stub := &stubModel{chunks: []string{"one", " two"}, info: map[string]any{"InputTokens": 10}}
+			hits := 0
+			m := &CacheModel{
+				Model:   stub,
+				Backend: "dummy",
+				ModelID: "dummy",
+				Mode:    tt.mode,
+				Cache:   &ResponseCache{Dir: dir},
+				OnHit:   func() { hits++ },
+				OnError: func(err error) { t.Error(err) },
+			}
+			messages := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "count")}
+			for i := range 2 {
+				var chunks []string
+				resp, err := m.GenerateContent(context.Background(), messages, llms.WithTemperature(0.5), llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+					chunks = append(chunks, string(chunk))
+					return nil
+				}))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if diff := cmp.Diff([]string{"one", " two"}, chunks); diff != "" {
+					t.Errorf("call %d: chunks mismatch (-want +got):\n%s", i+1, diff)
+				}
+				if resp.Choices[0].Content != "one two" {
+					t.Errorf("call %d: content = %q, want %q", i+1, resp.Choices[0].Content, "one two")
+				}
+				_, reported := UsageFromResponse(resp)
+				if hit := hits > 0 && i == 1; hit == reported {
+					t.Errorf("call %d: usage reported = %v for a cache hit = %v", i+1, reported, hit)
+				}
+			}
+			if stub.calls != tt.wantCalls {
+				t.Errorf("wrapped model called %d times, want %d", stub.calls, tt.wantCalls)
+			}
+			if files, _ := filepath.Glob(filepath.Join(dir, "*.json")); len(files) != tt.wantFiles {
+				t.Errorf("cache has %d entries, want %d", len(files), tt.wantFiles)
+			}
+
+			// Different call options miss the cache.
+			if _, err := m.GenerateContent(context.Background(), messages, llms.WithTemperature(0.7)); err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.wantCalls + 1; stub.calls != want {
+				t.Errorf("wrapped model called %d times after changing the temperature, want %d", stub.calls, want)
+			}
+		})
+	}
+}
+
+func TestResponseCacheExpiry(t *testing.T) {
+	dir := t.TempDir()
+	c := &ResponseCache{Dir: dir, TTL: time.Hour, MaxBytes: 2000}
+	entry := func(created time.Time, content string) *cachedResponse {
+		return &cachedResponse{Created: created, Chunks: []string{content}, Choices: []*llms.ContentChoice{{Content: content}}}
+	}
+	if err := c.put("stale", entry(time.Now().Add(-2*time.Hour), "stale")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.get("stale"); ok {
+		t.Error("got an expired entry")
+	}
+
+	// Entries beyond MaxBytes are evicted, oldest first. Each entry takes
+	// about 750 bytes, so two fit.
+	old := time.Now().Add(-30 * time.Minute)
+	for i, key := range []string{"a", "b", "c", "d"} {
+		if err := c.put(key, entry(time.Now(), strings.Repeat("x", 300))); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(c.path(key), old.Add(time.Duration(i)*time.Minute), old.Add(time.Duration(i)*time.Minute))
+	}
+	if err := c.evict(); err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for _, key := range []string{"stale", "a", "b", "c", "d"} {
+		if _, ok := c.get(key); ok {
+			kept = append(kept, key)
+		}
+	}
+	if diff := cmp.Diff([]string{"c", "d"}, kept); diff != "" {
+		t.Errorf("kept entries mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseCacheMode(t *testing.T) {
+	for in, want := range map[string]CacheMode{"": CacheOff, "off": CacheOff, "READ": CacheRead, "write": CacheWrite, "readwrite": CacheReadWrite} {
+		if got, err := ParseCacheMode(in); err != nil || got != want {
+			t.Errorf("ParseCacheMode(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseCacheMode("always"); err == nil {
+		t.Error("ParseCacheMode(\"always\") succeeded, want an error")
+	}
+}
+
+func TestCacheKeyFields(t *testing.T) {
+	messages := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "hi")}
+	tests := []struct {
+		name string
+		cfg  *Config
+	}{
+		{"top-p", &Config{Backend: "openai", Model: "gpt-4o", TopP: 0.5}},
+		{"logit bias", &Config{Backend: "openai", Model: "gpt-4o", LogitBias: map[string]float64{"50256": -100}}},
+		{"reasoning effort", &Config{Backend: "openai", Model: "o3-mini", ReasoningEffort: "high"}},
+		{"thinking budget", &Config{Backend: "anthropic", Model: "claude-3-7-sonnet-latest", ThinkingBudget: 2048}},
+		{"reasoning effort as thinking budget", &Config{Backend: "anthropic", Model: "claude-3-7-sonnet-latest", ReasoningEffort: "low"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unset := &Config{Backend: tt.cfg.Backend, Model: tt.cfg.Model}
+			want, err := cacheKey(unset.Backend, unset.Model, unset.cacheFields(), messages, llms.CallOptions{}, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := cacheKey(tt.cfg.Backend, tt.cfg.Model, tt.cfg.cacheFields(), messages, llms.CallOptions{}, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got == want {
+				t.Errorf("cache key ignores %s", tt.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,381 @@
+package cgpt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// ModelCapabilities describes the limits and features of a model.
+type ModelCapabilities struct {
+	// ContextWindow is the maximum number of tokens in a request, including output.
+	ContextWindow int `json:"contextWindow"`
+	// MaxOutputTokens is the maximum number of tokens the model generates.
+	MaxOutputTokens int `json:"maxOutputTokens"`
+
+	Streaming    bool `json:"streaming"`
+	SystemPrompt bool `json:"systemPrompt"`
+	Prefill      bool `json:"prefill"`
+	Temperature  bool `json:"temperature"`
+	Images       bool `json:"images"`
+	Tools        bool `json:"tools"`
+
+	// Sampling parameters the backend passes on for the model.
+	Stop      bool `json:"stop"`
+	TopP      bool `json:"topP"`
+	TopK      bool `json:"topK"`
+	Seed      bool `json:"seed"`
+	Penalties bool `json:"penalties"`
+	JSONMode  bool `json:"jsonMode"`
+	LogitBias bool `json:"logitBias"`
+
+	// Thinking is support for an extended thinking budget, and
+	// ReasoningEffort for a reasoning effort.
+	Thinking        bool `json:"thinking"`
+	ReasoningEffort bool `json:"reasoningEffort"`
+
+	// Choices is support for generating several completions in one request.
+	Choices bool `json:"choices"`
+}
+
+// ModelCapabilitiesOverride overrides capabilities in the configuration.
+// Unset fields keep the built-in value.
+type ModelCapabilitiesOverride struct {
+	ContextWindow   int   `yaml:"contextWindow"`
+	MaxOutputTokens int   `yaml:"maxOutputTokens"`
+	Streaming       *bool `yaml:"streaming"`
+	SystemPrompt    *bool `yaml:"systemPrompt"`
+	Prefill         *bool `yaml:"prefill"`
+	Temperature     *bool `yaml:"temperature"`
+	Images          *bool `yaml:"images"`
+	Tools           *bool `yaml:"tools"`
+	Stop            *bool `yaml:"stop"`
+	TopP            *bool `yaml:"topP"`
+	TopK            *bool `yaml:"topK"`
+	Seed            *bool `yaml:"seed"`
+	Penalties       *bool `yaml:"penalties"`
+	JSONMode        *bool `yaml:"jsonMode"`
+	LogitBias       *bool `yaml:"logitBias"`
+	Thinking        *bool `yaml:"thinking"`
+	ReasoningEffort *bool `yaml:"reasoningEffort"`
+	Choices         *bool `yaml:"choices"`
+}
+
+func (o ModelCapabilitiesOverride) apply(c ModelCapabilities) ModelCapabilities {
+	if o.ContextWindow != 0 {
+		c.ContextWindow = o.ContextWindow
+	}
+	if o.MaxOutputTokens != 0 {
+		c.MaxOutputTokens = o.MaxOutputTokens
+	}
+	for _, f := range []struct {
+		override *bool
+		field    *bool
+	}{
+		{o.Streaming, &c.Streaming},
+		{o.SystemPrompt, &c.SystemPrompt},
+		{o.Prefill, &c.Prefill},
+		{o.Temperature, &c.Temperature},
+		{o.Images, &c.Images},
+		{o.Tools, &c.Tools},
+		{o.Stop, &c.Stop},
+		{o.TopP, &c.TopP},
+		{o.TopK, &c.TopK},
+		{o.Seed, &c.Seed},
+		{o.Penalties, &c.Penalties},
+		{o.JSONMode, &c.JSONMode},
+		{o.LogitBias, &c.LogitBias},
+		{o.Thinking, &c.Thinking},
+		{o.ReasoningEffort, &c.ReasoningEffort},
+		{o.Choices, &c.Choices},
+	} {
+		if f.override != nil {
+			*f.field = *f.override
+		}
+	}
+	return c
+}
+
+// capabilityEntry associates a "backend:model" glob pattern with capabilities.
+type capabilityEntry struct {
+	pattern string
+	caps    ModelCapabilities
+}
+
+// fullCapabilities describes a model supporting every feature except prefill.
+func fullCapabilities(contextWindow, maxOutput int) ModelCapabilities {
+	return ModelCapabilities{
+		ContextWindow:   contextWindow,
+		MaxOutputTokens: maxOutput,
+		Streaming:       true,
+		SystemPrompt:    true,
+		Temperature:     true,
+		Images:          true,
+		Tools:           true,
+	}
+}
+
+// withPrefill returns c with prefill support.
+func withPrefill(c ModelCapabilities) ModelCapabilities {
+	c.Prefill = true
+	return c
+}
+
+// sampling lists the sampling parameters a backend passes on to its API.
+type sampling struct {
+	stop, topP, topK, seed, penalties, jsonMode, logitBias bool
+}
+
+var (
+	// openAISampling has no top-k, which the API lacks. Top-p and logit
+	// bias are added to requests by openAIRequestFields.
+	openAISampling    = sampling{stop: true, topP: true, seed: true, penalties: true, jsonMode: true, logitBias: true}
+	anthropicSampling = sampling{stop: true, topP: true}
+	googleAISampling  = sampling{stop: true, topP: true, topK: true, jsonMode: true}
+	ollamaSampling    = sampling{stop: true, topP: true, topK: true, seed: true, penalties: true, jsonMode: true}
+	// allSampling is assumed for backends without capability data.
+	allSampling = sampling{true, true, true, true, true, true, true}
+)
+
+// with returns c with the sampling parameters of s.
+func (s sampling) with(c ModelCapabilities) ModelCapabilities {
+	c.Stop, c.TopP, c.TopK, c.Seed = s.stop, s.topP, s.topK, s.seed
+	c.Penalties, c.JSONMode, c.LogitBias = s.penalties, s.jsonMode, s.logitBias
+	return c
+}
+
+// withThinking returns c with support for an extended thinking budget.
+func withThinking(c ModelCapabilities) ModelCapabilities {
+	c.Thinking = true
+	return c
+}
+
+// withReasoningEffort returns c with support for a reasoning effort.
+func withReasoningEffort(c ModelCapabilities) ModelCapabilities {
+	c.ReasoningEffort = true
+	return c
+}
+
+// withChoices returns c with support for several completions per request.
+func withChoices(c ModelCapabilities) ModelCapabilities {
+	c.Choices = true
+	return c
+}
+
+// reasoningCapabilities returns capabilities for OpenAI reasoning models, which reject
+// the temperature parameter and most sampling parameters.
+func reasoningCapabilities(contextWindow, maxOutput int, streaming, system bool) ModelCapabilities {
+	return ModelCapabilities{
+		ContextWindow:   contextWindow,
+		MaxOutputTokens: maxOutput,
+		Streaming:       streaming,
+		SystemPrompt:    system,
+		Images:          system,
+		Tools:           system,
+		Seed:            true,
+		JSONMode:        system,
+	}
+}
+
+// builtinModelCapabilities is the built-in capability table. The first matching
+// pattern wins, so more specific patterns come first.
+var builtinModelCapabilities = []capabilityEntry{
+	{"anthropic:claude-3-7-sonnet*", withThinking(anthropicSampling.with(withPrefill(fullCapabilities(200000, 64000))))},
+	{"anthropic:claude-3-5-*", anthropicSampling.with(withPrefill(fullCapabilities(200000, 8192)))},
+	{"anthropic:*", anthropicSampling.with(withPrefill(fullCapabilities(200000, 4096)))},
+
+	{"openai:o1-mini*", reasoningCapabilities(128000, 65536, false, false)},
+	{"openai:o1-preview*", reasoningCapabilities(128000, 32768, false, false)},
+	{"openai:o1*", withReasoningEffort(reasoningCapabilities(200000, 100000, false, true))},
+	{"openai:o3-mini*", withReasoningEffort(reasoningCapabilities(200000, 100000, true, true))},
+	{"openai:gpt-4o*", withChoices(openAISampling.with(fullCapabilities(128000, 16384)))},
+	{"openai:gpt-4-turbo*", withChoices(openAISampling.with(fullCapabilities(128000, 4096)))},
+	{"openai:gpt-4*", withChoices(openAISampling.with(fullCapabilities(8192, 4096)))},
+	{"openai:gpt-3.5-turbo*", withChoices(openAISampling.with(fullCapabilities(16385, 4096)))},
+	{"openai:*", withChoices(openAISampling.with(fullCapabilities(128000, 4096)))},
+	{"azure:*", withChoices(openAISampling.with(fullCapabilities(128000, 4096)))},
+
+	{"googleai:gemini-1.5-pro*", withChoices(googleAISampling.with(fullCapabilities(2097152, 8192)))},
+	{"googleai:gemini-1.5-flash*", withChoices(googleAISampling.with(fullCapabilities(1048576, 8192)))},
+	{"googleai:gemini-2.0*", withChoices(googleAISampling.with(fullCapabilities(1048576, 8192)))},
+	{"googleai:*", withChoices(googleAISampling.with(fullCapabilities(32760, 8192)))},
+
+	{"ollama:*", ollamaSampling.with(withPrefill(fullCapabilities(8192, 4096)))},
+	{"dummy:*", allSampling.with(withPrefill(fullCapabilities(1000000, 4096)))},
+}
+
+// defaultCapabilities applies to models with no matching entry, adjusted by
+// the backend's registered capabilities.
+var defaultCapabilities = allSampling.with(fullCapabilities(8192, 4096))
+
+// LookupModelCapabilities returns the built-in capabilities of a model.
+func LookupModelCapabilities(backend, model string) ModelCapabilities {
+	key := strings.ToLower(backend + ":" + model)
+	for _, e := range builtinModelCapabilities {
+		if matchModelPattern(e.pattern, key) {
+			return e.caps
+		}
+	}
+	c := defaultCapabilities
+	if b, ok := LookupBackend(backend); ok {
+		c.Streaming = b.Capabilities.Streaming
+		c.SystemPrompt = b.Capabilities.SystemPrompt
+		c.Prefill = b.Capabilities.Prefill
+	}
+	return c
+}
+
+// modelCapabilities returns the capabilities of a model, with any overrides
+// from the configuration applied. Overrides are keyed by "backend:model"
+// glob patterns; all matching overrides apply, most specific (longest) last.
+func (cfg *Config) modelCapabilities(backend, model string) ModelCapabilities {
+	c := LookupModelCapabilities(backend, model)
+	if _, ok := cfg.Providers[strings.ToLower(backend)]; ok {
+		// Providers are OpenAI-compatible, and called with the openai package.
+		c = openAISampling.with(c)
+	}
+	key := strings.ToLower(backend + ":" + model)
+	patterns := make([]string, 0, len(cfg.ModelCapabilities))
+	for pattern := range cfg.ModelCapabilities {
+		if matchModelPattern(strings.ToLower(pattern), key) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Slice(patterns, func(i, j int) bool { return len(patterns[i]) < len(patterns[j]) })
+	for _, pattern := range patterns {
+		c = cfg.ModelCapabilities[pattern].apply(c)
+	}
+	return c
+}
+
+// matchModelPattern reports whether s matches pattern, in which '*' matches
+// any sequence of characters (including '/', which appears in model names).
+func matchModelPattern(pattern, s string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == '*' {
+			pattern = pattern[1:]
+			if pattern == "" {
+				return true
+			}
+			for i := range len(s) + 1 {
+				if matchModelPattern(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if s == "" || pattern[0] != s[0] {
+			return false
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return s == ""
+}
+
+// callOptions returns the options for a GenerateContent call, adjusted to the
+// capabilities of the model. Adjustments are explained on stderr.
+func (s *CompletionService) callOptions() []llms.CallOption {
+	caps := s.capabilities
+	maxTokens := s.maxTokens()
+	if s.cfg.MaxTokens > maxTokens {
+		s.noticef("%s supports at most %d output tokens, reducing max tokens from %d", s.cfg.Model, caps.MaxOutputTokens, s.cfg.MaxTokens)
+	}
+	options := []llms.CallOption{llms.WithMaxTokens(maxTokens)}
+	s.reasoningOptions()
+	switch {
+	case caps.Thinking && s.cfg.thinkingBudget() > 0:
+		// Extended thinking does not allow a temperature.
+	case caps.Temperature:
+		options = append(options, llms.WithTemperature(s.cfg.Temperature))
+	case s.cfg.temperatureSet:
+		// The default temperature is dropped silently.
+		s.noticef("%s does not support temperature, ignoring it", s.cfg.Model)
+	}
+	return append(options, s.samplingOptions()...)
+}
+
+// maxTokens returns the maximum number of tokens to generate: the configured
+// maximum, within what the model supports.
+func (s *CompletionService) maxTokens() int {
+	caps := s.capabilities
+	switch {
+	case s.cfg.MaxTokens == 0:
+		return caps.MaxOutputTokens
+	case caps.MaxOutputTokens > 0 && s.cfg.MaxTokens > caps.MaxOutputTokens:
+		return caps.MaxOutputTokens
+	}
+	return s.cfg.MaxTokens
+}
+
+// prepareMessages returns messages adjusted to the capabilities of the model,
+// without modifying the messages passed in. Adjustments are explained on stderr.
+func (s *CompletionService) prepareMessages(messages []llms.MessageContent) []llms.MessageContent {
+	caps := s.capabilities
+	out := make([]llms.MessageContent, 0, len(messages))
+	var system []string
+	for _, m := range messages {
+		if m.Role == llms.ChatMessageTypeSystem && !caps.SystemPrompt {
+			for _, p := range m.Parts {
+				if t, ok := p.(llms.TextContent); ok {
+					system = append(system, t.Text)
+				}
+			}
+			continue
+		}
+		if !caps.Images {
+			parts := make([]llms.ContentPart, 0, len(m.Parts))
+			for _, p := range m.Parts {
+				switch p.(type) {
+				case llms.ImageURLContent, llms.BinaryContent:
+					s.noticef("%s does not support images, dropping image content", s.cfg.Model)
+				default:
+					parts = append(parts, p)
+				}
+			}
+			m.Parts = parts
+		}
+		out = append(out, m)
+	}
+	if len(system) > 0 {
+		s.noticef("%s does not support system prompts, prepending it to the first user message", s.cfg.Model)
+		prefix := strings.Join(system, "\n\n") + "\n\n"
+		i := 0
+		for i < len(out) && out[i].Role != llms.ChatMessageTypeHuman {
+			i++
+		}
+		if i == len(out) {
+			out = append(out, llms.TextParts(llms.ChatMessageTypeHuman, strings.TrimSpace(prefix)))
+		} else {
+			parts := append([]llms.ContentPart{llms.TextContent{Text: prefix}}, out[i].Parts...)
+			out[i] = llms.MessageContent{Role: out[i].Role, Parts: parts}
+		}
+	}
+	if n := len(out); n > 0 && out[n-1].Role == llms.ChatMessageTypeAI && !caps.Prefill {
+		s.noticef("%s does not support prefill, the response may not continue it", s.cfg.Model)
+	}
+	return out
+}
+
+// noticef prints a dim notice on stderr, at most once per distinct message.
+func (s *CompletionService) noticef(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	s.stderrMu.Lock()
+	defer s.stderrMu.Unlock()
+	if s.notices[msg] {
+		return
+	}
+	if s.notices == nil {
+		s.notices = make(map[string]bool)
+	}
+	s.notices[msg] = true
+	fmt.Fprintf(s.Stderr, "\033[38;5;240mcgpt: %s\033[0m\n", msg)
+}
+
+// statusf prints a dim status line on stderr, such as a retry or fallback.
+func (s *CompletionService) statusf(format string, args ...any) {
+	s.stderrMu.Lock()
+	defer s.stderrMu.Unlock()
+	fmt.Fprintf(s.Stderr, "\033[38;5;240mcgpt: %s\033[0m\n", fmt.Sprintf(format, args...))
+}
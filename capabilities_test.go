@@ -0,0 +1,224 @@
+package cgpt
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestLookupModelCapabilities(t *testing.T) {
+	tests := []struct {
+		backend, model  string
+		wantMaxOutput   int
+		wantStreaming   bool
+		wantTemperature bool
+		wantPrefill     bool
+	}{
+		{"anthropic", "claude-3-7-sonnet-20250219", 64000, true, true, true},
+		{"anthropic", "claude-3-5-haiku-latest", 8192, true, true, true},
+		{"openai", "gpt-4o-mini", 16384, true, true, false},
+		{"openai", "o1-mini", 65536, false, false, false},
+		{"openai", "o3-mini-2025-01-31", 100000, true, false, false},
+		{"ollama", "library/llama3.2:latest", 4096, true, true, true},
+		{"OpenAI", "GPT-4o", 16384, true, true, false},
+		{"nonexistent", "model", 4096, true, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.backend+":"+tt.model, func(t *testing.T) {
+			c := LookupModelCapabilities(tt.backend, tt.model)
+			if c.MaxOutputTokens != tt.wantMaxOutput {
+				t.Errorf("MaxOutputTokens = %d, want %d", c.MaxOutputTokens, tt.wantMaxOutput)
+			}
+			if c.Streaming != tt.wantStreaming {
+				t.Errorf("Streaming = %v, want %v", c.Streaming, tt.wantStreaming)
+			}
+			if c.Temperature != tt.wantTemperature {
+				t.Errorf("Temperature = %v, want %v", c.Temperature, tt.wantTemperature)
+			}
+			if c.Prefill != tt.wantPrefill {
+				t.Errorf("Prefill = %v, want %v", c.Prefill, tt.wantPrefill)
+			}
+		})
+	}
+}
+
+func TestModelCapabilitiesConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configPath, []byte(`
+modelCapabilities:
+  "ollama:*":
+    maxOutputTokens: 2048
+  "ollama:deepseek-r1*":
+    maxOutputTokens: 32768
+    prefill: false
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.String("config", "", "")
+	if err := fs.Parse([]string{"--config", configPath}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(configPath, &bytes.Buffer{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := cfg.modelCapabilities("ollama", "llama3.2")
+	if c.MaxOutputTokens != 2048 || !c.Prefill {
+		t.Errorf("llama3.2: MaxOutputTokens = %d, Prefill = %v, want 2048, true", c.MaxOutputTokens, c.Prefill)
+	}
+	c = cfg.modelCapabilities("ollama", "deepseek-r1:14b")
+	if c.MaxOutputTokens != 32768 || c.Prefill {
+		t.Errorf("deepseek-r1: MaxOutputTokens = %d, Prefill = %v, want 32768, false", c.MaxOutputTokens, c.Prefill)
+	}
+	if c.ContextWindow != 8192 {
+		t.Errorf("deepseek-r1: ContextWindow = %d, want built-in 8192", c.ContextWindow)
+	}
+}
+
+func TestCapabilityAdjustments(t *testing.T) {
+	f := false
+	tests := []struct {
+		name          string
+		cfg           Config
+		stream        bool
+		wantMaxTokens int
+		wantTemp      float64
+		wantStreaming bool
+		wantFirst     string
+		wantNotices   []string
+		// wantQuiet are notices that must not be shown.
+		wantQuiet []string
+	}{
+		{
+			name:          "supported",
+			cfg:           Config{Backend: "openai", Model: "gpt-4o", MaxTokens: 1000, Temperature: 0.5},
+			stream:        true,
+			wantMaxTokens: 1000,
+			wantTemp:      0.5,
+			wantStreaming: true,
+			wantFirst:     "system",
+		},
+		{
+			name:          "max tokens clamped",
+			cfg:           Config{Backend: "openai", Model: "gpt-4o", MaxTokens: 50000, Temperature: 0.5},
+			wantMaxTokens: 16384,
+			wantTemp:      0.5,
+			wantFirst:     "system",
+			wantNotices:   []string{"at most 16384 output tokens"},
+		},
+		{
+			name:          "max tokens defaulted",
+			cfg:           Config{Backend: "anthropic", Model: "claude-3-5-sonnet-latest", Temperature: 0.5},
+			wantMaxTokens: 8192,
+			wantTemp:      0.5,
+			wantFirst:     "system",
+		},
+		{
+			name:          "reasoning model",
+			cfg:           Config{Backend: "openai", Model: "o1-mini", MaxTokens: 4096, Temperature: 0.5, temperatureSet: true},
+			stream:        true,
+			wantMaxTokens: 4096,
+			wantFirst:     "be brief\n\nhello",
+			wantNotices:   []string{"temperature", "system prompts", "streaming"},
+		},
+		{
+			name:          "reasoning model with default temperature",
+			cfg:           Config{Backend: "openai", Model: "o1-mini", MaxTokens: 4096, Temperature: 0.05},
+			wantMaxTokens: 4096,
+			wantFirst:     "be brief\n\nhello",
+			wantNotices:   []string{"system prompts"},
+			wantQuiet:     []string{"temperature"},
+		},
+		{
+			name: "override",
+			cfg: Config{Backend: "openai", Model: "gpt-4o", MaxTokens: 1000, Temperature: 0.5,
+				ModelCapabilities: map[string]ModelCapabilitiesOverride{"openai:gpt-4o": {Streaming: &f}}},
+			stream:        true,
+			wantMaxTokens: 1000,
+			wantTemp:      0.5,
+			wantFirst:     "system",
+			wantNotices:   []string{"streaming"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := &stubModel{chunks: []string{"hi ", "there"}}
+			var stderr bytes.Buffer
+			s, err := NewCompletionService(&tt.cfg, model, WithStderr(&stderr), WithStdout(&bytes.Buffer{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.payload.addSystemMessage("be brief")
+			s.payload.addUserMessage("hello")
+
+			var got string
+			if tt.stream {
+				ch, err := s.PerformCompletionStreaming(context.Background(), s.payload, PerformCompletionConfig{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				for chunk := range ch {
+					got += chunk
+				}
+			} else {
+				got, err = s.PerformCompletion(context.Background(), s.payload, PerformCompletionConfig{})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got != "hi there" {
+				t.Errorf("response = %q, want %q", got, "hi there")
+			}
+
+			if model.options.MaxTokens != tt.wantMaxTokens {
+				t.Errorf("MaxTokens = %d, want %d", model.options.MaxTokens, tt.wantMaxTokens)
+			}
+			if model.options.Temperature != tt.wantTemp {
+				t.Errorf("Temperature = %v, want %v", model.options.Temperature, tt.wantTemp)
+			}
+			if streaming := model.options.StreamingFunc != nil; streaming != tt.wantStreaming {
+				t.Errorf("streaming = %v, want %v", streaming, tt.wantStreaming)
+			}
+			var first strings.Builder
+			if tt.wantFirst == "system" {
+				if model.messages[0].Role != llms.ChatMessageTypeSystem {
+					t.Errorf("first message role = %s, want system", model.messages[0].Role)
+				}
+			} else {
+				for _, p := range model.messages[0].Parts {
+					first.WriteString(p.(llms.TextContent).Text)
+				}
+				if first.String() != tt.wantFirst {
+					t.Errorf("first message = %q, want %q", first.String(), tt.wantFirst)
+				}
+			}
+			if s.payload.Messages[0].Role != llms.ChatMessageTypeSystem {
+				t.Errorf("payload was modified: first role = %s", s.payload.Messages[0].Role)
+			}
+
+			notices := stderr.String()
+			if len(tt.wantNotices) == 0 && notices != "" {
+				t.Errorf("unexpected notices: %q", notices)
+			}
+			for _, want := range tt.wantNotices {
+				if !strings.Contains(notices, want) {
+					t.Errorf("notices %q do not mention %q", notices, want)
+				}
+			}
+			for _, quiet := range tt.wantQuiet {
+				if strings.Contains(notices, quiet) {
+					t.Errorf("notices %q mention %q", notices, quiet)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/pflag"
+	"github.com/tmc/cgpt"
+	"golang.org/x/term"
+)
+
+// runEmbed implements 'cgpt embed', which writes the embeddings of its inputs.
+func runEmbed(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	opts := cgpt.RunOptions{Config: &cgpt.Config{}, Stdin: os.Stdin}
+	var eo cgpt.EmbedOptions
+	fs := pflag.NewFlagSet(args[0], pflag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringArrayVarP(&opts.InputStrings, "input", "i", nil, "Direct string input (can be used multiple times)")
+	fs.StringArrayVarP(&opts.InputFiles, "file", "f", []string{"-"}, "Input file path. Use '-' for stdin (can be used multiple times)")
+	fs.StringVarP(&opts.Config.EmbeddingBackend, "embedding-backend", "b", "", "The backend to use (default the chat backend)")
+	fs.StringVarP(&opts.Config.EmbeddingModel, "embedding-model", "m", "", "The embedding model to use (default the backend's default embedding model)")
+	fs.StringVar(&opts.Config.Profile, "profile", "", "Named profile from the configuration file")
+	fs.StringVar(&opts.ConfigPath, "config", "config.yaml", "Path to the configuration file")
+	fs.BoolVarP(&opts.Verbose, "verbose", "v", false, "Verbose output")
+	fs.StringVar(&eo.Split, "split", "lines", "Split inputs into lines, files or chunks")
+	fs.IntVar(&eo.ChunkSize, "chunk-size", 2000, "Maximum chunk length in characters, with --split chunks")
+	fs.IntVar(&eo.ChunkOverlap, "chunk-overlap", 0, "Characters shared by consecutive chunks, with --split chunks")
+	fs.IntVar(&eo.BatchSize, "batch-size", 100, "Number of texts embedded per request")
+	format := fs.String("format", "jsonl", "Output format: jsonl or binary")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: cgpt embed [flags] [input...]\n\n")
+		fmt.Fprintf(stderr, "Writes the embeddings of the inputs, split into lines, files or chunks.\n\n")
+		fmt.Fprintf(stderr, "The jsonl format writes a JSON object per text, with its source and vector.\n")
+		fmt.Fprintf(stderr, "The binary format writes the vectors in input order, each as a little-endian\n")
+		fmt.Fprintf(stderr, "int32 dimension followed by that many float32 values (the fvecs format).\n\n")
+		fs.PrintDefaults()
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		opts.InputFiles = nil
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	opts.PositionalArgs = fs.Args()
+	if *format != "jsonl" && *format != "binary" {
+		return fmt.Errorf("invalid --format %q: want jsonl or binary", *format)
+	}
+
+	cfg, err := cgpt.LoadConfig(opts.ConfigPath, stderr, fs)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	opts.Config = cfg
+	backend, model, err := cfg.ResolveEmbeddingModel()
+	if err != nil {
+		return err
+	}
+	embedder, err := cgpt.NewEmbedder(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize embedding model: %w", err)
+	}
+	texts, err := cgpt.SplitInputs(ctx, opts, eo)
+	if err != nil {
+		return err
+	}
+	if opts.Verbose {
+		fmt.Fprintf(stderr, "cgpt: embedding %d texts with %s/%s\n", len(texts), backend, model)
+	}
+	if err := cgpt.Embed(ctx, embedder, texts, eo); err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(stdout)
+	if *format == "binary" {
+		for _, t := range texts {
+			binary.Write(w, binary.LittleEndian, int32(len(t.Embedding)))
+			binary.Write(w, binary.LittleEndian, t.Embedding)
+		}
+		return w.Flush()
+	}
+	enc := json.NewEncoder(w)
+	for _, t := range texts {
+		if err := enc.Encode(t); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
@@ -3,6 +3,10 @@
 // Usage:
 //
 //	cgpt [flags] [input]
+//	cgpt models [flags] [backend...]
+//	cgpt embed [flags] [input...]
+//	cgpt tokens [flags] [input...]
+//	cgpt usage [flags]
 //
 // Input can be provided via:
 //   - Command line arguments
@@ -14,6 +18,7 @@
 //
 //	-b, --backend string             The backend to use (default "anthropic")
 //	-m, --model string               The model to use (default "claude-3-7-sonnet-20250219")
+//	    --profile string             Named profile from the configuration file
 //	-i, --input string               Direct string input (can be used multiple times)
 //	-f, --file string                Input file path. Use '-' for stdin (can be used multiple times)
 //	-c, --continuous                 Run in continuous mode (interactive)
@@ -24,9 +29,27 @@
 //	    --config string              Path to the configuration file (default "config.yaml")
 //	-v, --verbose                    Verbose output
 //	    --debug                      Debug output
-//	-n, --completions int            Number of completions (when running non-interactively with history)
+//	-n, --completions int            Number of independent completions to generate
+//	    --completions-delimiter string Delimiter written between completions (default "\n---\n")
+//	    --completions-format string  Output format of completions: text or json (default "text")
 //	-t, --max-tokens int             Maximum tokens to generate (default 8000)
-//	    --completion-timeout duration Maximum time to wait for a response (default 2m0s)
+//	    --completion-timeout duration Maximum time a completion may take
+//	    --first-token-timeout duration Maximum time to wait for the first token
+//	    --idle-timeout duration      Maximum time to wait between streamed tokens
+//	-T, --temperature float          Temperature for sampling (default 0.05)
+//	    --top-p float                Nucleus sampling probability mass
+//	    --top-k int                  Sample from the k most likely tokens
+//	    --seed int                   Seed for sampling, where supported
+//	    --frequency-penalty float    Penalize tokens by how often they have appeared
+//	    --presence-penalty float     Penalize tokens that have appeared
+//	    --stop stringArray           Stop sequence (can be used multiple times)
+//	    --logit-bias id=bias         Bias a token ID, from -100 to 100 (can be used multiple times)
+//	    --json-mode                  Ask the model to respond with a JSON object
+//	    --thinking-budget int        Tokens the model may spend thinking (Anthropic)
+//	    --reasoning-effort string    Reasoning effort: low, medium or high (OpenAI)
+//	    --thinking-output string     File to append model thinking to, instead of stderr
+//	    --budget float               Maximum cost of this invocation in US dollars, across all turns
+//	    --cache string               Response cache mode: off, read, write or readwrite (default "off")
 //	-h, --help                       Display help information
 //
 // The -c/--continuous flag enables interactive mode, where the program runs in a loop,
@@ -35,15 +58,19 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"path/filepath"
-	"time"
+	"strings"
 
 	"github.com/spf13/pflag"
 	"github.com/tmc/cgpt"
+	"github.com/tmc/cgpt/httprecord"
 	"github.com/tmc/langchaingo/httputil"
+	"github.com/tmc/langchaingo/llms"
 	"golang.org/x/term"
 )
 
@@ -62,7 +89,12 @@ func defineFlags(fs *pflag.FlagSet, opts *cgpt.RunOptions) {
 	fs.BoolVar(&opts.OpenAIUseLegacyMaxTokens, "openai-use-max-tokens", false, "If true, uses 'max_tokens' vs 'max_output_tokens' for openai backends")
 
 	fs.BoolVar(&opts.EchoPrefill, "prefill-echo", true, "Print the prefill message")
-	fs.DurationVar(&opts.CompletionTimeout, "completion-timeout", 2*time.Minute, "Maximum time to wait for a response")
+	fs.StringVar(&opts.Config.DummyScript, "dummy-script", "", "Script of responses for the dummy backend (YAML or txtar), also set by CGPT_DUMMY_SCRIPT")
+	fs.StringVar(&opts.HTTPRecordFile, "http-record", "", "Record backend HTTP traffic to a cassette file, with credentials redacted")
+	fs.StringVar(&opts.HTTPReplayFile, "http-replay", "", "Replay backend HTTP traffic from a cassette file instead of using the network")
+	fs.DurationVar(&opts.CompletionTimeout, "completion-timeout", 0, "Maximum time a completion may take, including streaming (0, the default, for no limit)")
+	fs.DurationVar(&opts.FirstTokenTimeout, "first-token-timeout", 0, "Maximum time to wait for the first token, per model with fallbacks (0 for no limit)")
+	fs.DurationVar(&opts.IdleTimeout, "idle-timeout", 0, "Maximum time to wait between streamed tokens (0 for no limit)")
 
 	// History flags
 	fs.StringVarP(&opts.HistoryIn, "history-in", "I", "", "File to read completion history from")
@@ -72,20 +104,62 @@ func defineFlags(fs *pflag.FlagSet, opts *cgpt.RunOptions) {
 	fs.BoolVar(&opts.DisableHistory, "no-history", false, "Disable saving chat history")
 
 	fs.StringVar(&opts.ReadlineHistoryFile, "readline-history-file", "~/.cgpt_history", "File to store readline history in")
-	fs.IntVarP(&opts.NCompletions, "completions", "n", 0, "Number of completions (when running non-interactively with history)")
+	fs.IntVarP(&opts.NCompletions, "completions", "n", 0, "Number of independent completions to generate (not streamed, and not in continuous mode)")
+	fs.StringVar(&opts.CompletionsDelimiter, "completions-delimiter", cgpt.DefaultCompletionsDelimiter, "Delimiter written between completions, with -n")
+	fs.StringVar(&opts.CompletionsFormat, "completions-format", "text", "Output format of completions, with -n: text or json")
 
 	// Config flags
-	fs.StringVarP(&opts.Config.Backend, "backend", "b", "anthropic", "The backend to use")
-	fs.StringVarP(&opts.Config.Model, "model", "m", "claude-3-7-sonnet-20250219", "The model to use")
+	fs.StringVarP(&opts.Config.Backend, "backend", "b", "anthropic", backendUsage(cgpt.BackendNames()))
+	fs.StringVarP(&opts.Config.Model, "model", "m", "claude-3-7-sonnet-20250219", "The model to use, or an alias such as 'fast' or 'smart'")
+	fs.StringVar(&opts.Config.Profile, "profile", "", "Named profile from the configuration file")
 	fs.StringVarP(&opts.Config.SystemPrompt, "system-prompt", "s", "", "System prompt to use")
 	fs.IntVarP(&opts.Config.MaxTokens, "max-tokens", "t", 0, "Maximum tokens to generate")
 	fs.Float64VarP(&opts.Config.Temperature, "temperature", "T", 0.05, "Temperature for sampling")
+	fs.Float64Var(&opts.Config.TopP, "top-p", 0, "Nucleus sampling: sample from the tokens making up this probability mass")
+	fs.IntVar(&opts.Config.TopK, "top-k", 0, "Sample from the k most likely tokens")
+	fs.IntVar(&opts.Config.Seed, "seed", 0, "Seed for sampling, for reproducible completions where supported")
+	fs.Float64Var(&opts.Config.FrequencyPenalty, "frequency-penalty", 0, "Penalize tokens by how often they have appeared, from -2 to 2")
+	fs.Float64Var(&opts.Config.PresencePenalty, "presence-penalty", 0, "Penalize tokens that have appeared, from -2 to 2")
+	fs.StringArrayVar(&opts.Config.Stop, "stop", nil, "Stop sequence (can be used multiple times)")
+	// LoadConfig parses --logit-bias into Config.LogitBias.
+	fs.StringToString("logit-bias", nil, "Token ID and bias, as id=bias (can be used multiple times)")
+	fs.BoolVar(&opts.Config.JSONMode, "json-mode", false, "Ask the model to respond with a JSON object")
+	fs.IntVar(&opts.Config.ThinkingBudget, "thinking-budget", 0, "Tokens the model may spend thinking, at least 1024 (Anthropic; mapped to a reasoning effort for OpenAI)")
+	fs.StringVar(&opts.Config.ReasoningEffort, "reasoning-effort", "", "Reasoning effort: low, medium or high (OpenAI; mapped to a thinking budget for Anthropic)")
+	fs.StringVar(&opts.ThinkingOutput, "thinking-output", "", "File to append model thinking to, instead of stderr")
+	fs.Float64Var(&opts.Config.Budget, "budget", 0, "Maximum cost of this invocation in US dollars, across all turns")
+	fs.StringVar(&opts.Config.Cache, "cache", "off", "Response cache mode: off, read, write or readwrite")
 
 	// Config file path
 	fs.StringVar(&opts.ConfigPath, "config", "config.yaml", "Path to the configuration file")
 }
 
+// exitBudgetExceeded is the exit status when a completion is refused
+// because a hard budget limit has been reached.
+const exitBudgetExceeded = 3
+
+// subcommands are run when their name is the first argument.
+var subcommands = map[string]func(ctx context.Context, args []string, stdout, stderr io.Writer) error{
+	"embed":  runEmbed,
+	"models": runModels,
+	"tokens": runTokens,
+	"usage":  runUsage,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			if err := cmd(context.Background(), os.Args[1:], os.Stdout, os.Stderr); err != nil {
+				if err == pflag.ErrHelp {
+					os.Exit(0)
+				}
+				fmt.Fprintf(os.Stderr, "cgpt: error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	opts, flagSet, err := initFlags(os.Args, os.Stdin)
 	if err != nil {
 		if err == pflag.ErrHelp {
@@ -98,6 +172,9 @@ func main() {
 	ctx := context.Background()
 	if err := run(ctx, opts, flagSet); err != nil {
 		fmt.Fprintf(os.Stderr, "cgpt: error: %v\n", err)
+		if errors.Is(err, cgpt.ErrBudgetExceeded) {
+			os.Exit(exitBudgetExceeded)
+		}
 		os.Exit(1)
 	}
 }
@@ -123,17 +200,10 @@ func run(ctx context.Context, opts cgpt.RunOptions, flagSet *pflag.FlagSet) erro
 		}
 	}
 
-	// Initialize the model (the llms.Model interface)
-	modelOpts := []cgpt.InferenceProviderOption{}
-	// if debug mode is on, attach the debug http client:
 	if opts.DebugMode {
 		fmt.Fprintln(opts.Stderr, "Debug mode enabled")
-		modelOpts = append(modelOpts, cgpt.WithHTTPClient(httputil.DebugHTTPClient))
 	}
-	if opts.OpenAIUseLegacyMaxTokens {
-		modelOpts = append(modelOpts, cgpt.WithUseLegacyMaxTokens(true))
-	}
-	model, err := cgpt.InitializeModel(opts.Config, modelOpts...)
+	model, err := initializeModel(opts)
 	if err != nil {
 		return fmt.Errorf("failed to initialize model: %w", err)
 	}
@@ -146,12 +216,22 @@ func run(ctx context.Context, opts cgpt.RunOptions, flagSet *pflag.FlagSet) erro
 	// Only have spinner on if stdout is a tty:
 	opts.ShowSpinner = opts.ShowSpinner && term.IsTerminal(int(os.Stdout.Fd()))
 
-	// Create the completion service
-	s, err := cgpt.NewCompletionService(opts.Config, model,
+	serviceOpts := []cgpt.CompletionServiceOption{
 		cgpt.WithStdout(opts.Stdout),
 		cgpt.WithStderr(opts.Stderr),
 		cgpt.WithDisableHistory(opts.DisableHistory),
-	)
+	}
+	if opts.ThinkingOutput != "" {
+		f, err := os.OpenFile(opts.ThinkingOutput, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open thinking output: %w", err)
+		}
+		defer f.Close()
+		serviceOpts = append(serviceOpts, cgpt.WithThinkingOutput(f))
+	}
+
+	// Create the completion service
+	s, err := cgpt.NewCompletionService(opts.Config, model, serviceOpts...)
 	if err != nil {
 		return fmt.Errorf("failed to create completion service: %w", err)
 	}
@@ -159,6 +239,63 @@ func run(ctx context.Context, opts cgpt.RunOptions, flagSet *pflag.FlagSet) erro
 	return s.Run(ctx, opts)
 }
 
+// initializeModel initializes the model (the llms.Model interface) with the
+// HTTP client the options call for.
+func initializeModel(opts cgpt.RunOptions) (llms.Model, error) {
+	modelOpts := []cgpt.InferenceProviderOption{}
+	var client *http.Client
+	// if debug mode is on, attach the debug http client:
+	if opts.DebugMode {
+		client = httputil.DebugHTTPClient
+	}
+	switch {
+	case opts.HTTPReplayFile != "":
+		replayer, err := httprecord.NewReplayer(opts.HTTPReplayFile)
+		if err != nil {
+			return nil, err
+		}
+		client = &http.Client{Transport: replayer}
+		// Recorded credentials are redacted, and backends refuse to start
+		// without any, so stand in for missing ones.
+		for _, key := range []*string{&opts.Config.OpenAIAPIKey, &opts.Config.AnthropicAPIKey, &opts.Config.GoogleAPIKey, &opts.Config.AzureAPIKey} {
+			if *key == "" {
+				*key = httprecord.Redacted
+			}
+		}
+	case opts.HTTPRecordFile != "":
+		var base http.RoundTripper
+		if client != nil {
+			base = client.Transport
+		}
+		cfg := opts.Config
+		client = &http.Client{Transport: httprecord.NewRecorder(opts.HTTPRecordFile, base,
+			cfg.OpenAIAPIKey, cfg.AnthropicAPIKey, cfg.GoogleAPIKey, cfg.AzureAPIKey)}
+	}
+	if client != nil {
+		modelOpts = append(modelOpts, cgpt.WithHTTPClient(client))
+	}
+	if opts.OpenAIUseLegacyMaxTokens {
+		modelOpts = append(modelOpts, cgpt.WithUseLegacyMaxTokens(true))
+	}
+	return cgpt.InitializeModel(opts.Config, modelOpts...)
+}
+
+// backendUsage returns the usage of the --backend flag, listing names.
+func backendUsage(names []string) string {
+	return fmt.Sprintf("The backend to use (%s)", strings.Join(names, ", "))
+}
+
+// describeBackends adds the providers defined in the configuration file to
+// the usage of the --backend flag. The registered backends are listed
+// alone if the configuration does not load.
+func describeBackends(fs *pflag.FlagSet, configPath string) {
+	cfg, err := cgpt.LoadConfig(configPath, io.Discard, fs)
+	if err != nil {
+		return
+	}
+	fs.Lookup("backend").Usage = backendUsage(cfg.BackendNames())
+}
+
 func initFlags(args []string, stdin io.Reader) (cgpt.RunOptions, *pflag.FlagSet, error) {
 	opts := cgpt.RunOptions{
 		Config: &cgpt.Config{},
@@ -185,6 +322,9 @@ func initFlags(args []string, stdin io.Reader) (cgpt.RunOptions, *pflag.FlagSet,
 	fs.MarkHidden("readline-history-file")
 	fs.MarkHidden("prefill-echo")
 	fs.MarkHidden("show-spinner")
+	fs.MarkHidden("dummy-script")
+	fs.MarkHidden("http-record")
+	fs.MarkHidden("http-replay")
 
 	fs.Usage = func() {
 		fmt.Println("cgpt is a command line tool for interacting with generative AI models")
@@ -194,6 +334,7 @@ func initFlags(args []string, stdin io.Reader) (cgpt.RunOptions, *pflag.FlagSet,
 			return
 		}
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", args[0])
+		describeBackends(fs, opts.ConfigPath)
 		fs.PrintDefaults()
 		printBasicUsage()
 	}
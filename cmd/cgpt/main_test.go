@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"io/fs"
 	"maps"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -17,14 +19,19 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/spf13/pflag"
 	"github.com/tmc/cgpt"
+	"github.com/tmc/cgpt/httprecord"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zaptest"
 	"golang.org/x/tools/txtar"
 )
 
-var update = flag.Bool("update", false, "update golden files")
+var (
+	update = flag.Bool("update", false, "update golden files")
+	record = flag.Bool("record", false, "record HTTP cassettes against real backends (use with -update)")
+)
 
 // App represents the main application
 type App struct {
@@ -60,6 +67,9 @@ func Test(t *testing.T) {
 		backend string
 		model   string
 		args    []string
+		// cassette replays backend HTTP traffic from the golden file's
+		// "cassette" section, which -update -record captures.
+		cassette bool
 	}{
 		{
 			name:    "basic dummy",
@@ -82,10 +92,17 @@ func Test(t *testing.T) {
 			},
 		},
 		{
-			name:    "ollama model",
-			backend: "ollama",
-			model:   "llama3.2:1b",
-			args:    []string{"--prefill=yo"},
+			name:    "dummy script",
+			backend: "dummy",
+			model:   "dummy-model",
+			args:    []string{"-s", "you are terse"},
+		},
+		{
+			name:     "ollama model",
+			backend:  "ollama",
+			model:    "llama3.2:1b",
+			args:     []string{"--prefill=yo"},
+			cassette: true,
 		},
 	}
 
@@ -114,6 +131,28 @@ func Test(t *testing.T) {
 
 			args := []string{"cgpt-test", fmt.Sprintf("--backend=%s", tc.backend), fmt.Sprintf("--model=%s", tc.model)}
 			args = append(args, tc.args...)
+			cassettePath := filepath.Join(t.TempDir(), "cassette.json")
+			if tc.cassette {
+				if *record {
+					args = append(args, "--http-record="+cassettePath)
+				} else {
+					if files["cassette"] == nil {
+						t.Skip("no cassette recorded (run with -update -record)")
+					}
+					if err := os.WriteFile(cassettePath, files["cassette"], 0644); err != nil {
+						t.Fatal(err)
+					}
+					args = append(args, "--http-replay="+cassettePath)
+				}
+			}
+			// A "dummy_script.yaml" section scripts the dummy backend's responses.
+			if script := files["dummy_script.yaml"]; script != nil {
+				scriptPath := filepath.Join(t.TempDir(), "dummy_script.yaml")
+				if err := os.WriteFile(scriptPath, script, 0644); err != nil {
+					t.Fatal(err)
+				}
+				args = append(args, "--dummy-script="+scriptPath)
+			}
 			opts, fs, err := initFlags(args, inBuf)
 			if err != nil {
 				t.Fatalf("initFlags: %v", err)
@@ -123,6 +162,11 @@ func Test(t *testing.T) {
 			inBuf.WriteString(txtarComment)
 
 			runTest(t, context.Background(), opts, fs, newTestLogger(t))
+			if *update && *record && tc.cassette {
+				if files["cassette"], err = os.ReadFile(cassettePath); err != nil {
+					t.Fatal(err)
+				}
+			}
 			if *update {
 				updateGoldenFile(t, testInputFile, txtarComment, files, outBuf.Bytes(), errBuf.Bytes(), files["http_payload"])
 				t.SkipNow()
@@ -132,6 +176,99 @@ func Test(t *testing.T) {
 	}
 }
 
+// TestHTTPRecordReplay records a run against a fake Ollama server with
+// --http-record, and replays the cassette with --http-replay once the
+// server is gone.
+func TestHTTPRecordReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for _, c := range []string{"recorded ", "response"} {
+			fmt.Fprintf(w, "{\"model\":\"llama3.2:1b\",\"message\":{\"role\":\"assistant\",\"content\":%q},\"done\":false}\n", c)
+		}
+		fmt.Fprint(w, "{\"model\":\"llama3.2:1b\",\"message\":{\"role\":\"assistant\",\"content\":\"\"},\"done_reason\":\"stop\",\"done\":true}\n")
+	}))
+	defer srv.Close()
+	t.Setenv("OLLAMA_HOST", srv.URL)
+	cassette := filepath.Join(t.TempDir(), "cassette.json")
+
+	run := func(flag string) string {
+		t.Helper()
+		args := []string{"cgpt-test", "--backend=ollama", "--model=llama3.2:1b", "--no-history", "-i", "hi", flag + "=" + cassette}
+		opts, fs, err := initFlags(args, strings.NewReader(""))
+		if err != nil {
+			t.Fatalf("initFlags: %v", err)
+		}
+		var stdout bytes.Buffer
+		opts.Stdout, opts.Stderr = &stdout, &bytes.Buffer{}
+		runTest(t, context.Background(), opts, fs, newTestLogger(t))
+		return stdout.String()
+	}
+
+	recorded := run("--http-record")
+	if !strings.Contains(recorded, "recorded response") {
+		t.Fatalf("recorded run output = %q, want the server's response", recorded)
+	}
+	srv.Close()
+	c, err := httprecord.Load(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Interactions) == 0 {
+		t.Fatal("cassette has no interactions")
+	}
+	if replayed := run("--http-replay"); replayed != recorded {
+		t.Errorf("replayed output = %q, want the recorded %q", replayed, recorded)
+	}
+}
+
+func TestLogitBiasFlag(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    map[string]float64
+		wantErr bool
+	}{
+		{name: "unset"},
+		{
+			name: "repeated",
+			args: []string{"--logit-bias", "50256=-100", "--logit-bias", "198=2.5"},
+			want: map[string]float64{"50256": -100, "198": 2.5},
+		},
+		{
+			name: "comma separated",
+			args: []string{"--logit-bias=50256=-100,198=1"},
+			want: map[string]float64{"50256": -100, "198": 1},
+		},
+		{
+			name:    "not a number",
+			args:    []string{"--logit-bias", "50256=never"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, fs, err := initFlags(append([]string{"cgpt-test"}, tt.args...), strings.NewReader(""))
+			if err != nil {
+				t.Fatalf("initFlags: %v", err)
+			}
+			cfg, err := cgpt.LoadConfig(opts.ConfigPath, &bytes.Buffer{}, fs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tt.want, cfg.LogitBias, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("LogitBias mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func runTest(t *testing.T, ctx context.Context, opts cgpt.RunOptions, fs *pflag.FlagSet, logger *zap.SugaredLogger) {
 	t.Helper()
 	fileCfg, err := cgpt.LoadConfig(opts.ConfigPath, opts.Stderr, fs)
@@ -139,8 +276,10 @@ func runTest(t *testing.T, ctx context.Context, opts cgpt.RunOptions, fs *pflag.
 		t.Fatalf("failed to load config: %v", err)
 	}
 	opts.Config = fileCfg
+	// Keep test runs out of the real usage ledger.
+	opts.Config.UsageLedger = filepath.Join(t.TempDir(), "usage.jsonl")
 
-	model, err := cgpt.InitializeModel(opts.Config)
+	model, err := initializeModel(opts)
 	if err != nil {
 		t.Fatalf("failed to initialize model: %v", err)
 	}
@@ -281,6 +420,7 @@ func compareBytes(t *testing.T, name string, want, got []byte) {
 }
 
 func TestDuplicateAIRole(t *testing.T) {
+	t.Setenv("CGPT_USAGE_LEDGER", "off")
 	// Create a temporary file for history
 	histFile, err := os.CreateTemp("", "cgpt-test-history-*.txt")
 	if err != nil {
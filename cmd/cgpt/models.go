@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+	"github.com/tmc/cgpt"
+)
+
+// runModels implements 'cgpt models', which lists the models available from
+// the configured backends.
+func runModels(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := pflag.NewFlagSet(args[0], pflag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configPath := fs.String("config", "config.yaml", "Path to the configuration file")
+	fs.BoolP("verbose", "v", false, "Verbose output")
+	jsonOutput := fs.Bool("json", false, "Print models as JSON")
+	quiet := fs.BoolP("quiet", "q", false, "Print model IDs only, one per line")
+	refresh := fs.Bool("refresh", false, "Ignore cached results")
+	offline := fs.Bool("offline", false, "Only use cached results, however old")
+	ttl := fs.Duration("cache-ttl", cgpt.DefaultModelsCacheTTL, "How long to use cached results")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: cgpt models [flags] [backend...]\n\n")
+		fmt.Fprintf(stderr, "Lists the models available from each configured backend, or the given backends.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cfg, err := cgpt.LoadConfig(*configPath, stderr, fs)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	cacheFile, err := cgpt.DefaultModelsCacheFile()
+	if err != nil {
+		return err
+	}
+	models, err := cgpt.ListModels(ctx, cfg, cgpt.ListModelsOptions{
+		Backends:  fs.Args(),
+		CacheFile: cacheFile,
+		TTL:       *ttl,
+		Refresh:   *refresh,
+		Offline:   *offline,
+	})
+	if err != nil {
+		// Report failed backends, but still list the others.
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(stderr, "\033[38;5;240mcgpt: %s\033[0m\n", line)
+		}
+		if len(models) == 0 {
+			return fmt.Errorf("no models found")
+		}
+	}
+
+	switch {
+	case *jsonOutput:
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(models)
+	case *quiet:
+		for _, m := range models {
+			fmt.Fprintln(stdout, m.ID)
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "BACKEND\tMODEL\tCONTEXT\tMAX OUTPUT\tFEATURES\tALIASES")
+	for _, m := range models {
+		aliases := m.Aliases
+		if m.Default {
+			aliases = append([]string{"default"}, aliases...)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\n", m.Backend, m.ID, m.Capabilities.ContextWindow, m.Capabilities.MaxOutputTokens, features(m.Capabilities), strings.Join(aliases, ","))
+	}
+	return w.Flush()
+}
+
+// features summarizes the features a model supports.
+func features(c cgpt.ModelCapabilities) string {
+	var f []string
+	for _, feature := range []struct {
+		name      string
+		supported bool
+	}{
+		{"stream", c.Streaming},
+		{"system", c.SystemPrompt},
+		{"prefill", c.Prefill},
+		{"temperature", c.Temperature},
+		{"images", c.Images},
+		{"tools", c.Tools},
+		{"stop", c.Stop},
+		{"top-p", c.TopP},
+		{"top-k", c.TopK},
+		{"seed", c.Seed},
+		{"penalties", c.Penalties},
+		{"json", c.JSONMode},
+		{"logit-bias", c.LogitBias},
+		{"thinking", c.Thinking},
+		{"reasoning-effort", c.ReasoningEffort},
+		{"choices", c.Choices},
+	} {
+		if feature.supported {
+			f = append(f, feature.name)
+		}
+	}
+	return strings.Join(f, ",")
+}
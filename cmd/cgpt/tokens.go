@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+	"github.com/tmc/cgpt"
+	"golang.org/x/term"
+)
+
+// runTokens implements 'cgpt tokens', which counts the tokens of the prompt
+// a run with the same inputs would send, without calling the model.
+func runTokens(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	opts := cgpt.RunOptions{Config: &cgpt.Config{}, Stdin: os.Stdin}
+	fs := pflag.NewFlagSet(args[0], pflag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringArrayVarP(&opts.InputStrings, "input", "i", nil, "Direct string input (can be used multiple times)")
+	fs.StringArrayVarP(&opts.InputFiles, "file", "f", []string{"-"}, "Input file path. Use '-' for stdin (can be used multiple times)")
+	fs.StringVarP(&opts.HistoryIn, "history-in", "I", "", "File to read completion history from")
+	fs.StringVarP(&opts.Config.SystemPrompt, "system-prompt", "s", "", "System prompt to use")
+	fs.StringVarP(&opts.Prefill, "prefill", "p", "", "Prefill the assistant's response")
+	fs.StringVarP(&opts.Config.Backend, "backend", "b", "anthropic", "The backend to use")
+	fs.StringVarP(&opts.Config.Model, "model", "m", "claude-3-7-sonnet-20250219", "The model to use, or an alias such as 'fast' or 'smart'")
+	fs.StringVar(&opts.Config.Profile, "profile", "", "Named profile from the configuration file")
+	fs.IntVarP(&opts.Config.MaxTokens, "max-tokens", "t", 0, "Maximum tokens to generate")
+	fs.StringVar(&opts.ConfigPath, "config", "config.yaml", "Path to the configuration file")
+	fs.BoolVarP(&opts.Verbose, "verbose", "v", false, "Verbose output")
+	jsonOutput := fs.Bool("json", false, "Print the counts as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: cgpt tokens [flags] [input...]\n\n")
+		fmt.Fprintf(stderr, "Counts the tokens of the prompt that cgpt would send for the same inputs,\nper message and per input source, without calling the model.\n\n")
+		fs.PrintDefaults()
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		opts.InputFiles = nil
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	opts.PositionalArgs = fs.Args()
+
+	cfg, err := cgpt.LoadConfig(opts.ConfigPath, stderr, fs)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	opts.Config = cfg
+	counts, err := cgpt.CountPromptTokens(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(counts)
+	}
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MESSAGE\tROLE\tTOKENS")
+	for i, m := range counts.Messages {
+		fmt.Fprintf(w, "%d\t%s\t%d\n", i+1, m.Role, m.Tokens)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(counts.Inputs) > 0 {
+		fmt.Fprintln(stdout)
+		fmt.Fprintln(w, "INPUT\tSOURCE\tTOKENS")
+		for _, in := range counts.Inputs {
+			fmt.Fprintf(w, "%s\t%s\t%d\n", in.Type, in.Name, in.Tokens)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "\nTotal: %d tokens for %s/%s", counts.Total, counts.Backend, counts.Model)
+	if counts.ContextWindow > 0 {
+		fmt.Fprintf(stdout, ", %d available (%d context window minus %d max tokens)", counts.Available(), counts.ContextWindow, counts.MaxTokens)
+		if counts.Exceeded() {
+			fmt.Fprintf(stdout, ", over by %d", counts.Total-counts.Available())
+		}
+	}
+	fmt.Fprintln(stdout)
+	return nil
+}
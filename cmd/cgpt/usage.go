@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/tmc/cgpt"
+)
+
+// runUsage implements 'cgpt usage', which summarizes the usage ledger.
+func runUsage(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := pflag.NewFlagSet(args[0], pflag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configPath := fs.String("config", "config.yaml", "Path to the configuration file")
+	fs.BoolP("verbose", "v", false, "Verbose output")
+	jsonOutput := fs.Bool("json", false, "Print the summary as JSON")
+	by := fs.String("by", "day", "Summarize by day, model, dir or profile")
+	since := fs.String("since", "", "Only include usage on or after this date (YYYY-MM-DD)")
+	until := fs.String("until", "", "Only include usage on or before this date (YYYY-MM-DD)")
+	ledger := fs.String("ledger", "", "Path to the usage ledger (default ~/.cgpt/usage.jsonl)")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: cgpt usage [flags]\n\n")
+		fmt.Fprintf(stderr, "Summarizes the token usage and cost recorded in the usage ledger.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	path := *ledger
+	if path == "" {
+		cfg, err := cgpt.LoadConfig(*configPath, stderr, fs)
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		if path, err = cfg.UsageLedgerPath(); err != nil {
+			return err
+		}
+		if path == "" {
+			return fmt.Errorf("the usage ledger is off")
+		}
+	}
+	from, err := parseDate(*since, 0)
+	if err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
+	to, err := parseDate(*until, 1)
+	if err != nil {
+		return fmt.Errorf("invalid --until: %w", err)
+	}
+
+	entries, err := cgpt.ReadLedger(path)
+	if err != nil {
+		return fmt.Errorf("failed to read usage ledger: %w", err)
+	}
+	filtered := entries[:0]
+	for _, e := range entries {
+		if (!from.IsZero() && e.Time.Before(from)) || (!to.IsZero() && !e.Time.Before(to)) {
+			continue
+		}
+		filtered = append(filtered, e)
+	}
+	summaries, err := cgpt.SummarizeUsage(filtered, *by)
+	if err != nil {
+		return err
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(summaries)
+	}
+	var total cgpt.UsageSummary
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\tREQUESTS\tINPUT\tOUTPUT\tCACHE READ\tCACHE WRITE\tCOST\n", usageColumn(*by))
+	for _, s := range summaries {
+		printUsageRow(w, s)
+		total.Requests += s.Requests
+		total.Add(s.Usage)
+	}
+	total.Key = "total"
+	printUsageRow(w, total)
+	return w.Flush()
+}
+
+func printUsageRow(w io.Writer, s cgpt.UsageSummary) {
+	key := s.Key
+	if key == "" {
+		key = "-"
+	}
+	fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t$%.4f\n", key, s.Requests, s.InputTokens, s.OutputTokens, s.CacheReadTokens, s.CacheWriteTokens, s.Cost)
+}
+
+// usageColumn returns the heading of the column usage is summarized by.
+func usageColumn(by string) string {
+	if by == "dir" {
+		return "DIRECTORY"
+	}
+	return strings.ToUpper(by)
+}
+
+// parseDate parses a YYYY-MM-DD date in local time, adding days to it. An
+// empty date is the zero time.
+func parseDate(s string, days int) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.AddDate(0, 0, days), nil
+}
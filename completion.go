@@ -9,6 +9,7 @@ import (
 	"os/signal"
 	"slices"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -47,6 +48,39 @@ type CompletionService struct {
 
 	// sessionTimestamp is used to create a consistent history file name for the entire session
 	sessionTimestamp string
+
+	// capabilities are the capabilities of the configured model, used to
+	// adjust requests to what the model supports.
+	capabilities ModelCapabilities
+	// stderrMu serializes the status lines, notices and thinking written to
+	// Stderr, which concurrent completions may write at once, and guards
+	// notices.
+	stderrMu sync.Mutex
+	// notices records the notices already shown, so each is shown once.
+	notices map[string]bool
+	// verbose shows extra detail, such as prompt token counts, on stderr.
+	verbose bool
+
+	// usageMu guards the session's usage, and serializes appends to the
+	// usage ledger.
+	usageMu sync.Mutex
+	// sessionUsage is the usage of the completions made by the service.
+	sessionUsage    Usage
+	sessionRequests int
+	// sessionUnpriced is set when the price of a completion is unknown.
+	sessionUnpriced bool
+	// usageLedger is the path of the usage ledger that runs append
+	// completions to, or "" if disabled.
+	usageLedger string
+
+	// thinkingOutput receives model thinking, instead of stderr.
+	thinkingOutput io.Writer
+}
+
+// activeModelReporter is implemented by models that may serve a call with a
+// different backend or model than the configured one.
+type activeModelReporter interface {
+	ActiveModel() (backend, model string)
 }
 
 type CompletionServiceOption func(*CompletionService)
@@ -85,6 +119,12 @@ func NewCompletionService(cfg *Config, model llms.Model, opts ...CompletionServi
 	if model == nil {
 		return nil, errors.New("model cannot be nil")
 	}
+	if err := cfg.validateSampling(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validateReasoning(); err != nil {
+		return nil, err
+	}
 
 	s := &CompletionService{
 		cfg:               cfg,
@@ -94,10 +134,17 @@ func NewCompletionService(cfg *Config, model llms.Model, opts ...CompletionServi
 		Stdout:            os.Stdout,
 		Stderr:            os.Stderr,
 		sessionTimestamp:  time.Now().Format("20060102150405"),
+		capabilities:      cfg.modelCapabilities(cfg.Backend, cfg.Model),
 	}
 	for _, opt := range opts {
 		opt(s)
 	}
+	if s.Stdout == nil {
+		s.Stdout = os.Stdout
+	}
+	if s.Stderr == nil {
+		s.Stderr = os.Stderr
+	}
 	s.loggerCfg = zap.NewDevelopmentConfig()
 	if s.logger == nil {
 		// Create custom WriteSyncer for Stderr only
@@ -114,9 +161,59 @@ func NewCompletionService(cfg *Config, model llms.Model, opts ...CompletionServi
 		logger := zap.New(core)
 		s.logger = logger.Sugar()
 	}
+	s.attachModelNotifications(model)
 	return s, nil
 }
 
+// attachModelNotifications reports retries and fallbacks performed by model
+// wrappers on stderr, unless the caller has set up its own callbacks.
+func (s *CompletionService) attachModelNotifications(model llms.Model) {
+	switch m := model.(type) {
+	case *FallbackModel:
+		if m.OnFallback == nil {
+			m.OnFallback = func(from, to FallbackEntry, err error) {
+				s.statusf("%s/%s failed (%v), falling back to %s/%s", from.Backend, from.ModelID, err, to.Backend, to.ModelID)
+			}
+		}
+		for _, e := range m.Entries {
+			s.attachModelNotifications(e.Model)
+		}
+	case *RetryModel:
+		if m.OnRetry == nil {
+			m.OnRetry = func(attempt int, delay time.Duration, err error) {
+				s.statusf("attempt %d failed (%v), retrying in %v", attempt, err, delay.Round(time.Millisecond))
+			}
+		}
+		s.attachModelNotifications(m.Model)
+	case *RateLimitedModel:
+		if m.OnWait == nil {
+			m.OnWait = func(key string, wait time.Duration) {
+				s.statusf("waiting for %s rate limit (about %v)", key, wait.Round(time.Millisecond))
+			}
+		}
+		s.attachModelNotifications(m.Model)
+	case *CacheModel:
+		if m.OnHit == nil {
+			m.OnHit = func() {
+				s.statusf("using cached response")
+			}
+		}
+		if m.OnError == nil {
+			m.OnError = func(err error) {
+				s.noticef("failed to cache response: %v", err)
+			}
+		}
+		s.attachModelNotifications(m.Model)
+	case *FaultModel:
+		if m.OnFault == nil {
+			m.OnFault = func(fault string) {
+				s.statusf("injecting fault: %s", fault)
+			}
+		}
+		s.attachModelNotifications(m.Model)
+	}
+}
+
 // PerformCompletionConfig is the configuration for the PerformCompletion method, it controls the behavior of the completion with regard to user interaction.
 type PerformCompletionConfig struct {
 	Stdout      io.Writer
@@ -138,14 +235,35 @@ func (s *CompletionService) Run(ctx context.Context, runCfg RunOptions) error {
 }
 
 func (s *CompletionService) configure(runCfg RunOptions) error {
+	if runCfg.NCompletions > 1 && runCfg.Continuous {
+		return fmt.Errorf("several completions cannot be generated in continuous mode")
+	}
+	switch runCfg.CompletionsFormat {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("invalid completions format %q: want text or json", runCfg.CompletionsFormat)
+	}
 	s.readlineHistoryFile = runCfg.ReadlineHistoryFile
+	s.verbose = runCfg.Verbose
+	if s.verbose {
+		// Verbose runs show token counts, so count them exactly.
+		loadTiktoken()
+	}
 	s.configureLogLevel(runCfg)
 
+	ledger, err := s.cfg.UsageLedgerPath()
+	if err != nil {
+		return err
+	}
+	s.usageLedger = ledger
+
 	if err := s.handleHistory(runCfg.HistoryIn, runCfg.HistoryOut); err != nil {
 		fmt.Fprintln(s.Stderr, err)
 	}
 	if runCfg.Prefill != "" {
 		s.SetNextCompletionPrefill(runCfg.Prefill)
+	} else if s.cfg.Prefill != "" {
+		s.SetNextCompletionPrefill(s.cfg.Prefill)
 	}
 	if runCfg.Stdout == nil {
 		runCfg.Stdout = os.Stdout
@@ -210,6 +328,9 @@ func (s *CompletionService) executeCompletion(ctx context.Context, runCfg RunOpt
 		return s.runContinuousCompletion(ctx, runCfg)
 	}
 
+	if runCfg.NCompletions > 1 {
+		return s.runOneShotCompletions(ctx, runCfg)
+	}
 	if runCfg.StreamOutput {
 		return s.runOneShotCompletionStreaming(ctx, runCfg)
 	}
@@ -256,22 +377,14 @@ func (s *CompletionService) getLastUserMessage() string {
 func (s *CompletionService) runOneShotCompletionStreaming(ctx context.Context, runCfg RunOptions) error {
 	s.logger.Debug("running one-shot completion with streaming")
 
-	s.payload.Stream = true
-	streamPayloads, err := s.PerformCompletionStreaming(ctx, s.payload, PerformCompletionConfig{
-		ShowSpinner: runCfg.ShowSpinner,
-		EchoPrefill: runCfg.EchoPrefill,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to perform completion streaming: %w", err)
-	}
-	content := strings.Builder{}
-	for r := range streamPayloads {
-		content.WriteString(r)
-		runCfg.Stdout.Write([]byte(r))
-	}
+	streamErr := s.streamCompletion(ctx, runCfg)
+	// Save any partial response of a failed stream.
 	if err := s.saveHistory(); err != nil {
 		return fmt.Errorf("failed to save history: %w", err)
 	}
+	if streamErr != nil {
+		return streamErr
+	}
 
 	if err := s.renameChatHistory(ctx); err != nil {
 		return fmt.Errorf("failed to rename history: %w", err)
@@ -359,6 +472,7 @@ func (s *CompletionService) runContinuousCompletionStreaming(ctx context.Context
 	}
 
 	err = session.Run()
+	s.printSessionUsage()
 
 	// Before returning, try to rename the history file with a descriptive title
 	if ctxWithCancel.Err() == nil { // Only if we haven't already done it in signal handler
@@ -408,7 +522,9 @@ func (s *CompletionService) runContinuousCompletion(ctx context.Context, runCfg
 		return err
 	}
 
-	return session.Run()
+	err = session.Run()
+	s.printSessionUsage()
+	return err
 }
 
 func expandTilde(path string) string {
@@ -421,19 +537,15 @@ func expandTilde(path string) string {
 func (s *CompletionService) generateResponse(ctx context.Context, runCfg RunOptions) error {
 	s.payload.Stream = runCfg.StreamOutput
 	if runCfg.StreamOutput {
-		streamPayloads, err := s.PerformCompletionStreaming(ctx, s.payload, PerformCompletionConfig{
-			ShowSpinner: runCfg.ShowSpinner,
-			EchoPrefill: runCfg.EchoPrefill,
-		})
-		if err != nil {
-			return fmt.Errorf("failed to perform completion streaming: %w", err)
-		}
-		content := strings.Builder{}
-		for r := range streamPayloads {
-			content.WriteString(r)
-			runCfg.Stdout.Write([]byte(r))
-		}
+		streamErr := s.streamCompletion(ctx, runCfg)
 		runCfg.Stdout.Write([]byte("\n"))
+		if streamErr != nil {
+			// Save any partial response before giving up.
+			if err := s.saveHistory(); err != nil {
+				return fmt.Errorf("failed to save history: %w", err)
+			}
+			return streamErr
+		}
 	} else {
 		response, err := s.PerformCompletion(ctx, s.payload, PerformCompletionConfig{
 			ShowSpinner: runCfg.ShowSpinner,
@@ -450,6 +562,19 @@ func (s *CompletionService) generateResponse(ctx context.Context, runCfg RunOpti
 	return nil
 }
 
+// recordActiveModel records in payload the backend and model that served
+// the last completion, if they differ from the configured ones (e.g. after
+// a fallback). They are saved as the history's backend and model. The
+// payload's model is left alone, so later turns still go to the configured
+// model first.
+func (s *CompletionService) recordActiveModel(payload *ChatCompletionPayload) {
+	backend, model := s.activeModel()
+	if backend == s.cfg.Backend && model == payload.Model {
+		backend, model = "", ""
+	}
+	payload.ActiveBackend, payload.ActiveModel = backend, model
+}
+
 // SetNextCompletionPrefill sets the next completion prefill message.
 // Note that not all inference engines support prefill messages.
 // Whitespace is trimmed from the end of the message.
@@ -0,0 +1,207 @@
+package cgpt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// DefaultCompletionsDelimiter separates completions in text output, with -n.
+const DefaultCompletionsDelimiter = "\n---\n"
+
+// MessageAlternatives records the completions generated alongside an
+// assistant message for the same user turn. Each alternative is a sibling
+// of the message: it continues the conversation before it.
+type MessageAlternatives struct {
+	// Message is the index of the assistant message in the conversation.
+	Message      int      `json:"message"`
+	Alternatives []string `json:"alternatives"`
+}
+
+// PerformCompletions generates n independent completions of payload. Models
+// that support several choices per request generate them in one request;
+// otherwise the completions are requested concurrently. The first
+// completion is added to payload as the assistant message, and the others
+// are recorded as its alternatives. Responses are not streamed.
+func (s *CompletionService) PerformCompletions(ctx context.Context, payload *ChatCompletionPayload, n int, cfg PerformCompletionConfig) ([]string, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("invalid number of completions %d", n)
+	}
+	if err := s.checkContextWindow(s.pendingMessages(payload)); err != nil {
+		return nil, err
+	}
+	if err := s.checkBudget(); err != nil {
+		return nil, err
+	}
+
+	prefill := s.nextCompletionPrefill
+	s.nextCompletionPrefill = ""
+	messages := payload.Messages
+	if prefill != "" {
+		messages = append(slices.Clone(messages), llms.TextParts(llms.ChatMessageTypeAI, prefill))
+	}
+	messages = s.prepareMessages(messages)
+	options := s.callOptions()
+
+	var stopSpinner func()
+	if cfg.ShowSpinner {
+		stopSpinner = spin(0)
+	}
+	stopSpinnerOnce := sync.OnceFunc(func() {
+		if stopSpinner != nil {
+			stopSpinner()
+		}
+	})
+	defer stopSpinnerOnce()
+	ctx, timer, cancel := s.withTimeouts(ctx, false)
+	defer cancel()
+
+	type result struct {
+		resp    *llms.ContentResponse
+		latency time.Duration
+		err     error
+		record  func()
+	}
+	// generate requests n completions. index is the position of the first
+	// among the completions, which tells identical requests apart in the
+	// response cache.
+	generate := func(n, index int) result {
+		ctx := withCompletionIndex(ctx, index)
+		ctx, recordThinking := s.startThinking(ctx, payload, func(text string) {
+			stopSpinnerOnce()
+			s.writeThinking(text)
+		})
+		options := options
+		if n > 1 {
+			options = append(slices.Clone(options), llms.WithN(n), llms.WithCandidateCount(n))
+		}
+		start := time.Now()
+		resp, err := s.model.GenerateContent(ctx, messages, options...)
+		if err == nil {
+			timer.token()
+		}
+		return result{resp, time.Since(start), timeoutError(ctx, err), recordThinking}
+	}
+
+	var results []result
+	missing := n
+	if s.capabilities.Choices {
+		r := generate(n, 0)
+		if r.err != nil {
+			return nil, fmt.Errorf("failed to generate content: %w", r.err)
+		}
+		results = append(results, r)
+		// Generate whatever the model did not, such as when a fallback
+		// model returned a single choice.
+		missing -= len(r.resp.Choices)
+	}
+	if missing > 0 {
+		more := make([]result, missing)
+		var wg sync.WaitGroup
+		for i := range more {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				more[i] = generate(1, n-missing+i)
+			}()
+		}
+		wg.Wait()
+		results = append(results, more...)
+	}
+	stopSpinnerOnce()
+	s.recordActiveModel(payload)
+
+	var (
+		contents []string
+		errs     []error
+	)
+	for _, r := range results {
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		for _, c := range r.resp.Choices {
+			if len(contents) < n {
+				contents = append(contents, c.Content)
+			}
+		}
+	}
+	if len(contents) == 0 {
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("failed to generate content: %w", errs[0])
+		}
+		return nil, fmt.Errorf("no response from model")
+	}
+	if len(errs) > 0 {
+		s.noticef("%d of %d completions failed: %v", n-len(contents), n, errs[0])
+	}
+
+	payload.addAssistantMessage(prefill + contents[0])
+	if len(contents) > 1 {
+		alternatives := make([]string, 0, len(contents)-1)
+		for _, c := range contents[1:] {
+			alternatives = append(alternatives, prefill+c)
+		}
+		payload.Alternatives = append(payload.Alternatives, MessageAlternatives{Message: len(payload.Messages) - 1, Alternatives: alternatives})
+	}
+	for i, r := range results {
+		if r.err != nil {
+			continue
+		}
+		if i == 0 {
+			// Only the thinking of the first completion belongs to the message.
+			r.record()
+		}
+		s.recordUsage(payload, r.resp, r.latency)
+	}
+
+	if cfg.EchoPrefill && prefill != "" {
+		for i := range contents {
+			contents[i] = prefill + contents[i]
+		}
+	}
+	return contents, nil
+}
+
+// runOneShotCompletions runs a one-shot completion generating
+// runCfg.NCompletions completions, written separated by the completions
+// delimiter, or as a JSON array of strings.
+func (s *CompletionService) runOneShotCompletions(ctx context.Context, runCfg RunOptions) error {
+	s.logger.Debug("running one-shot completion with several completions")
+
+	s.payload.Stream = false
+	contents, err := s.PerformCompletions(ctx, s.payload, runCfg.NCompletions, PerformCompletionConfig{
+		ShowSpinner: runCfg.ShowSpinner,
+		EchoPrefill: runCfg.EchoPrefill,
+	})
+	if err != nil {
+		return err
+	}
+	switch runCfg.CompletionsFormat {
+	case "", "text":
+		delimiter := runCfg.CompletionsDelimiter
+		if delimiter == "" {
+			delimiter = DefaultCompletionsDelimiter
+		}
+		runCfg.Stdout.Write([]byte(strings.Join(contents, delimiter)))
+	case "json":
+		b, err := json.MarshalIndent(contents, "", "  ")
+		if err != nil {
+			return err
+		}
+		runCfg.Stdout.Write(append(b, '\n'))
+	}
+	if err := s.saveHistory(); err != nil {
+		return fmt.Errorf("failed to save history: %w", err)
+	}
+	if err := s.renameChatHistory(ctx); err != nil {
+		return fmt.Errorf("failed to rename history: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package cgpt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tmc/langchaingo/llms"
+	"sigs.k8s.io/yaml"
+)
+
+// choicesModel is a test model that numbers the completions it generates.
+// With native set, it generates as many choices per call as asked for.
+type choicesModel struct {
+	native bool
+	calls  atomic.Int32
+	n      atomic.Int32
+}
+
+func (m *choicesModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+func (m *choicesModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	m.calls.Add(1)
+	opts := llms.CallOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	n := 1
+	if m.native && opts.N > 1 {
+		n = opts.N
+	}
+	resp := &llms.ContentResponse{}
+	for range n {
+		resp.Choices = append(resp.Choices, &llms.ContentChoice{
+			Content:        fmt.Sprintf("answer %d", m.n.Add(1)),
+			GenerationInfo: map[string]any{"InputTokens": 10, "OutputTokens": 2},
+		})
+	}
+	return resp, nil
+}
+
+func TestCompletions(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	yes := true
+	tests := []struct {
+		name      string
+		native    bool
+		choices   bool
+		format    string
+		wantCalls int32
+	}{
+		{name: "concurrent", wantCalls: 3},
+		{name: "native", native: true, choices: true, wantCalls: 1},
+		{name: "native fallback", choices: true, wantCalls: 3},
+		{name: "json", format: "json", wantCalls: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := &Config{Backend: "dummy", Model: "dummy"}
+			if tt.choices {
+				cfg.ModelCapabilities = map[string]ModelCapabilitiesOverride{"dummy:*": {Choices: &yes}}
+			}
+			model := &choicesModel{native: tt.native}
+			s, err := NewCompletionService(cfg, model, WithStderr(&bytes.Buffer{}), WithStdout(&bytes.Buffer{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stdout bytes.Buffer
+			historyOut := filepath.Join(dir, "history.yaml")
+			err = s.Run(context.Background(), RunOptions{
+				Config:               cfg,
+				InputStrings:         []string{"hi"},
+				HistoryOut:           historyOut,
+				NCompletions:         3,
+				StreamOutput:         true,
+				CompletionsDelimiter: "\n--\n",
+				CompletionsFormat:    tt.format,
+				Stdout:               &stdout,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := model.calls.Load(); got != tt.wantCalls {
+				t.Errorf("model called %d times, want %d", got, tt.wantCalls)
+			}
+
+			var got []string
+			if tt.format == "json" {
+				if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+					t.Fatalf("invalid JSON output %q: %v", stdout.String(), err)
+				}
+			} else {
+				got = strings.Split(stdout.String(), "\n--\n")
+			}
+			// Concurrent completions finish in any order.
+			sorted := slices.Sorted(slices.Values(got))
+			if diff := cmp.Diff([]string{"answer 1", "answer 2", "answer 3"}, sorted); diff != "" {
+				t.Errorf("output mismatch (-want +got):\n%s", diff)
+			}
+
+			b, err := os.ReadFile(historyOut)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var h history
+			if err := yaml.Unmarshal(b, &h); err != nil {
+				t.Fatal(err)
+			}
+			if len(h.Messages) != 2 {
+				t.Fatalf("history has %d messages, want 2", len(h.Messages))
+			}
+			wantAlternatives := []MessageAlternatives{{Message: 1, Alternatives: got[1:]}}
+			if diff := cmp.Diff(wantAlternatives, h.Alternatives); diff != "" {
+				t.Errorf("history alternatives mismatch (-want +got):\n%s", diff)
+			}
+			if text := h.Messages[1].Parts[0].(llms.TextContent).Text; text != got[0] {
+				t.Errorf("history message = %q, want %q", text, got[0])
+			}
+			if len(h.Usage) != int(tt.wantCalls) {
+				t.Errorf("history records usage of %d requests, want %d", len(h.Usage), tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestCompletionsContinuous(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cfg := &Config{Backend: "dummy", Model: "dummy"}
+	s, err := NewCompletionService(cfg, &choicesModel{}, WithStderr(&bytes.Buffer{}), WithStdout(&bytes.Buffer{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Run(context.Background(), RunOptions{Config: cfg, Continuous: true, NCompletions: 2, DisableHistory: true, Stdout: &bytes.Buffer{}})
+	if err == nil || !strings.Contains(err.Error(), "continuous mode") {
+		t.Errorf("Run in continuous mode with 2 completions = %v, want an error", err)
+	}
+}
+
+func TestCompletionsCache(t *testing.T) {
+	dir := t.TempDir()
+	model := &choicesModel{}
+	cm := &CacheModel{Model: model, Backend: "dummy", ModelID: "dummy", Mode: CacheReadWrite, Cache: &ResponseCache{Dir: dir}}
+	cfg := &Config{Backend: "dummy", Model: "dummy"}
+	s, err := NewCompletionService(cfg, cm, WithStderr(&bytes.Buffer{}), WithStdout(&bytes.Buffer{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for run := range 2 {
+		payload := newCompletionPayload(cfg)
+		payload.addUserMessage("hi")
+		got, err := s.PerformCompletions(context.Background(), payload, 3, PerformCompletionConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]string{"answer 1", "answer 2", "answer 3"}, slices.Sorted(slices.Values(got))); diff != "" {
+			t.Errorf("run %d: completions mismatch (-want +got):\n%s", run+1, diff)
+		}
+	}
+	if got := model.calls.Load(); got != 3 {
+		t.Errorf("model called %d times, want 3: the second run should be served from the cache", got)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.json")); len(files) != 3 {
+		t.Errorf("cache has %d entries, want one per completion", len(files))
+	}
+}
+
+// TestCompletionsNotices generates completions concurrently through a
+// cache that fails to store them, so that each reports the same notice.
+// Run it with -race.
+func TestCompletionsNotices(t *testing.T) {
+	notADir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(notADir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cm := &CacheModel{Model: &choicesModel{}, Backend: "dummy", ModelID: "dummy", Mode: CacheWrite, Cache: &ResponseCache{Dir: notADir}}
+	cfg := &Config{Backend: "dummy", Model: "dummy"}
+	var stderr bytes.Buffer
+	s, err := NewCompletionService(cfg, cm, WithStderr(&stderr), WithStdout(&bytes.Buffer{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.payload.addUserMessage("hi")
+	if _, err := s.PerformCompletions(context.Background(), s.payload, 8, PerformCompletionConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(stderr.String(), "failed to cache response"); n != 1 {
+		t.Errorf("got %d cache notices, want 1: %q", n, stderr.String())
+	}
+}
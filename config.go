@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"regexp"
 	"strings"
 	"time"
 
@@ -15,23 +14,6 @@ import (
 
 var defaultBackend = "anthropic" // Configurable via 'CGPT_BACKEND" (or via configuration files).
 
-var defaultModels = map[string]string{
-	"anthropic": "claude-3-7-sonnet-20250219",
-	"openai":    "gpt-4o",
-	"ollama":    "llama3.2",
-	"googleai":  "gemini-pro",
-	"dummy":     "dummy",
-}
-
-// tokenLimits is a map of regex patterns to token limits for each backend.
-// The key "*" is a catch-all for any patterns not explicitly defined.
-// The value for each key is the maximum number of tokens allowed for a completion.
-var tokenLimits = map[string]int{
-	"*":                    4096,
-	"google:*":             8192,
-	"anthropic:.*sonnet.*": 8000,
-}
-
 type Config struct {
 	Backend     string  `yaml:"backend"`
 	Model       string  `yaml:"model"`
@@ -39,16 +21,126 @@ type Config struct {
 	MaxTokens   int     `yaml:"maxTokens"`
 	Temperature float64 `yaml:"temperature"`
 
-	SystemPrompt string             `yaml:"systemPrompt"`
-	LogitBias    map[string]float64 `yaml:"logitBias"`
-
+	SystemPrompt string `yaml:"systemPrompt"`
+	Prefill      string `yaml:"prefill"`
+
+	// Sampling parameters. Zero values leave the provider's default.
+	// Parameters the model does not support are ignored with a warning.
+	//
+	// LogitBias maps token IDs to a bias between -100 and 100.
+	LogitBias map[string]float64 `yaml:"logitBias"`
+	// Stop sequences end the completion when generated.
+	Stop             []string `yaml:"stop"`
+	TopP             float64  `yaml:"topP"`
+	TopK             int      `yaml:"topK"`
+	Seed             int      `yaml:"seed"`
+	FrequencyPenalty float64  `yaml:"frequencyPenalty"`
+	PresencePenalty  float64  `yaml:"presencePenalty"`
+	// JSONMode asks the model to respond with a JSON object.
+	JSONMode bool `yaml:"jsonMode"`
+
+	// ThinkingBudget is the number of tokens Anthropic models may spend
+	// thinking, at least 1024. ReasoningEffort is the effort OpenAI
+	// reasoning models spend: "low", "medium" or "high". Each is derived
+	// from the other for backends that take the other.
+	ThinkingBudget  int    `yaml:"thinkingBudget"`
+	ReasoningEffort string `yaml:"reasoningEffort"`
+
+	// CompletionTimeout bounds the time a completion may take, from the
+	// request to the last token. FirstTokenTimeout bounds the time to the
+	// first token, and IdleTimeout the time between streamed tokens. Zero
+	// means no limit. A completion that times out keeps its partial output.
+	// With Fallbacks, FirstTokenTimeout applies to each model of the chain
+	// in turn, and moves on to the next when it passes.
 	CompletionTimeout time.Duration `yaml:"completionTimeout"`
+	FirstTokenTimeout time.Duration `yaml:"firstTokenTimeout"`
+	IdleTimeout       time.Duration `yaml:"idleTimeout"`
+
+	// ContextOverflow is what to do when a prompt does not fit in the
+	// model's context window, less MaxTokens: "warn" (the default),
+	// "error" or "ignore".
+	ContextOverflow string `yaml:"contextOverflow"`
+
+	// Fallbacks lists backend/model pairs to try, in order, when the
+	// configured backend fails with a retryable error.
+	Fallbacks []FallbackTarget `yaml:"fallbacks"`
+
+	// Retry is the retry policy for failed completions.
+	Retry RetryPolicy `yaml:"retry"`
+	// Faults injects failures into completions, for testing.
+	Faults Faults `yaml:"faults"`
+	// Backends holds per-backend settings, keyed by backend name.
+	Backends map[string]BackendConfig `yaml:"backends"`
+	// Providers defines named OpenAI-compatible endpoints, selectable as backends.
+	Providers map[string]ProviderConfig `yaml:"providers"`
+	// Profile selects one of Profiles.
+	Profile string `yaml:"profile"`
+	// Profiles are named sets of settings, selected with --profile.
+	Profiles map[string]Profile `yaml:"profiles"`
+	// ModelAliases maps short names to models, for any backend. Backends may
+	// define their own aliases, such as "fast" and "smart".
+	ModelAliases map[string]string `yaml:"modelAliases"`
+
+	// ModelCapabilities overrides built-in model capabilities, keyed by
+	// "backend:model" patterns in which '*' matches any characters.
+	ModelCapabilities map[string]ModelCapabilitiesOverride `yaml:"modelCapabilities"`
+	// Prices overrides built-in model prices, in US dollars per million
+	// tokens, keyed by "backend:model" patterns.
+	Prices map[string]Price `yaml:"prices"`
+	// UsageLedger is the file completions are recorded in,
+	// ~/.cgpt/usage.jsonl by default, or "off".
+	UsageLedger string `yaml:"usageLedger"`
+	// Budgets limits daily, monthly and per-session spending.
+	Budgets Budgets `yaml:"budgets"`
+	// Budget caps the cost of a single invocation, in US dollars.
+	Budget float64 `yaml:"budget"`
+
+	// Cache is the response cache mode: "off" (the default), "read",
+	// "write" or "readwrite".
+	Cache string `yaml:"cache"`
+	// CacheTTL is how long cached responses are used for, a week by default.
+	CacheTTL time.Duration `yaml:"cacheTTL"`
+	// CacheMaxBytes bounds the size of the response cache, 100MB by default.
+	CacheMaxBytes int64 `yaml:"cacheMaxBytes"`
+
+	// EmbeddingBackend is the backend 'cgpt embed' uses, the chat backend
+	// by default.
+	EmbeddingBackend string `yaml:"embeddingBackend"`
+	// EmbeddingModel is the model 'cgpt embed' uses, the embedding
+	// backend's default embedding model by default.
+	EmbeddingModel string `yaml:"embeddingModel"`
 
 	Debug bool `yaml:"debug"`
 
+	// DummyScript is a script of responses for the dummy backend.
+	DummyScript string `yaml:"dummyScript"`
+
 	OpenAIAPIKey    string `yaml:"openaiAPIKey"`
 	AnthropicAPIKey string `yaml:"anthropicAPIKey"`
 	GoogleAPIKey    string `yaml:"googleAPIKey"`
+	AzureAPIKey     string `yaml:"azureAPIKey"`
+
+	Azure AzureConfig `yaml:"azure"`
+
+	// temperatureSet records whether Temperature was set explicitly, rather
+	// than left at its default.
+	temperatureSet bool
+	// seedSet records whether Seed was set explicitly, so that a seed of 0
+	// can be requested.
+	seedSet bool
+}
+
+// BackendConfig holds settings that apply to a single backend.
+type BackendConfig struct {
+	Retry RetryPolicy `yaml:"retry"`
+
+	// RateLimit is shared by all models of the backend, except those listed
+	// in ModelRateLimits, which are limited separately.
+	RateLimit       RateLimit            `yaml:"rateLimit"`
+	ModelRateLimits map[string]RateLimit `yaml:"modelRateLimits"`
+
+	// Faults replaces the top-level faults for this backend.
+	Faults Faults `yaml:"faults"`
 }
 
 // LoadConfig loads the configuration from various sources in the following order of precedence:
@@ -86,6 +178,19 @@ func LoadConfig(path string, stderr io.Writer, flagSet *pflag.FlagSet) (*Config,
 		return nil, fmt.Errorf("unable to bind flags: %w", err)
 	}
 
+	// Apply the selected profile, which overrides the config file but not
+	// flags or environment variables.
+	profile, hasProfile, err := loadProfile(v)
+	if err != nil {
+		return nil, err
+	}
+	if hasProfile {
+		if verbose, _ := flagSet.GetBool("verbose"); verbose {
+			fmt.Fprintf(stderr, "cgpt: using profile %q\n", v.GetString("profile"))
+		}
+		profile.apply(v, flagSet, stderr)
+	}
+
 	// Get backend (respecting precedence)
 	backend := v.GetString("backend")
 	if verbose, _ := flagSet.GetBool("verbose"); verbose {
@@ -105,7 +210,15 @@ func LoadConfig(path string, stderr io.Writer, flagSet *pflag.FlagSet) (*Config,
 		}
 		hasModel = true
 		v.Set("model", os.Getenv("CGPT_MODEL"))
-	} else if v.InConfig("model") {
+	} else if profile.Model != "" {
+		if verbose, _ := flagSet.GetBool("verbose"); verbose {
+			fmt.Fprintf(stderr, "cgpt: model set by profile: %s\n", profile.Model)
+		}
+		hasModel = true
+		v.Set("model", profile.Model)
+	} else if v.InConfig("model") && profile.Backend == "" {
+		// A profile that selects a backend without a model uses the
+		// backend's default model rather than the file's model.
 		if verbose, _ := flagSet.GetBool("verbose"); verbose {
 			fmt.Fprintf(stderr, "cgpt: model set in config: %s\n", v.GetString("model"))
 		}
@@ -117,18 +230,51 @@ func LoadConfig(path string, stderr io.Writer, flagSet *pflag.FlagSet) (*Config,
 		if verbose, _ := flagSet.GetBool("verbose"); verbose {
 			fmt.Fprintln(stderr, "cgpt: no model set, using default")
 		}
-		if defaultModel, ok := defaultModels[backend]; ok {
-			v.Set("model", defaultModel)
+		if model, ok := defaultModel(backend); ok {
+			v.Set("model", model)
 			if verbose, _ := flagSet.GetBool("verbose"); verbose {
-				fmt.Fprintf(stderr, "cgpt: using default model for %s backend: %s\n", backend, defaultModel)
+				fmt.Fprintf(stderr, "cgpt: using default model for %s backend: %s\n", backend, model)
 			}
 		}
 	}
 
+	// Logit biases from the flag are id=bias strings; parse them here
+	// rather than leave the conversion to viper.
+	if flagSet.Changed("logit-bias") {
+		pairs, err := flagSet.GetStringToString("logit-bias")
+		if err != nil {
+			return nil, err
+		}
+		bias, err := parseLogitBias(pairs)
+		if err != nil {
+			return nil, err
+		}
+		v.Set("logitBias", bias)
+	}
+
 	if err := v.Unmarshal(cfg); err != nil {
 		return nil, fmt.Errorf("unable to unmarshal config: %w", err)
 	}
 
+	// Providers are only known once the config is unmarshaled.
+	if p, ok := cfg.Providers[strings.ToLower(backend)]; ok && !hasModel && p.DefaultModel != "" {
+		cfg.Model = p.DefaultModel
+		if verbose, _ := flagSet.GetBool("verbose"); verbose {
+			fmt.Fprintf(stderr, "cgpt: using default model for %s provider: %s\n", backend, cfg.Model)
+		}
+	}
+
+	cfg.temperatureSet = setExplicitly(v, flagSet, "temperature", "temperature") || hasProfile && profile.Temperature != nil
+	cfg.seedSet = setExplicitly(v, flagSet, "seed", "seed")
+
+	if spec := os.Getenv("CGPT_FAULTS"); spec != "" {
+		if cfg.Faults, err = ParseFaults(spec); err != nil {
+			return nil, fmt.Errorf("invalid CGPT_FAULTS: %w", err)
+		}
+	}
+
+	cfg.resolveModelAliases(stderr, flagSet)
+
 	logConfig(cfg, stderr, flagSet)
 	return cfg, nil
 }
@@ -158,9 +304,15 @@ func setupViper(v *viper.Viper, flagSet *pflag.FlagSet) {
 	v.BindEnv("openaiAPIKey", "OPENAI_API_KEY")
 	v.BindEnv("anthropicAPIKey", "ANTHROPIC_API_KEY")
 	v.BindEnv("googleAPIKey", "GOOGLE_API_KEY")
+	v.BindEnv("azureAPIKey", "AZURE_OPENAI_API_KEY")
+	v.BindEnv("azure.endpoint", "AZURE_OPENAI_ENDPOINT")
+	v.BindEnv("dummyScript", "CGPT_DUMMY_SCRIPT")
+	v.BindEnv("usageLedger", "CGPT_USAGE_LEDGER")
+	v.BindEnv("embeddingBackend", "CGPT_EMBEDDING_BACKEND")
+	v.BindEnv("embeddingModel", "CGPT_EMBEDDING_MODEL")
 
 	// Set config file if specified in flags
-	if flagConfigFilePath := flagSet.Lookup("config"); flagConfigFilePath.Changed {
+	if flagConfigFilePath := flagSet.Lookup("config"); flagConfigFilePath != nil && flagConfigFilePath.Changed {
 		v.SetConfigFile(flagConfigFilePath.Value.String())
 	}
 }
@@ -190,24 +342,6 @@ func setupFlagNormalization(flagSet *pflag.FlagSet) {
 	})
 }
 
-func setMaxTokens(cfg *Config) {
-	maxTokens := tokenLimits["*"]
-	backendModel := cfg.Backend + ":" + cfg.Model
-
-	for pattern, limit := range tokenLimits {
-		if pattern == "*" {
-			continue
-		}
-		if matched, _ := regexp.MatchString(pattern, backendModel); matched {
-			maxTokens = limit
-			break
-		}
-	}
-
-	if cfg.MaxTokens == 0 || cfg.MaxTokens > maxTokens {
-		cfg.MaxTokens = maxTokens
-	}
-}
 func handleConfigFile(v *viper.Viper, stderr io.Writer, flagSet *pflag.FlagSet) error {
 	if configFlag := flagSet.Lookup("config"); configFlag != nil && configFlag.Changed {
 		configFile := configFlag.Value.String()
@@ -3,6 +3,7 @@ package cgpt
 import (
 	"bytes"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -108,3 +109,43 @@ func TestBackendDefaultModel(t *testing.T) {
 		})
 	}
 }
+
+func TestTemperatureSet(t *testing.T) {
+	tests := []struct {
+		name       string
+		configYAML string
+		env        map[string]string
+		flags      []string
+		want       bool
+	}{
+		{name: "default"},
+		{name: "flag", flags: []string{"--temperature=0.05"}, want: true},
+		{name: "env", env: map[string]string{"CGPT_TEMPERATURE": "0.7"}, want: true},
+		{name: "config file", configYAML: "temperature: 0.7", want: true},
+		{name: "profile", configYAML: "profile: creative\nprofiles:\n  creative:\n    temperature: 1", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, []byte(tt.configYAML), 0644); err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			fs.String("config", "", "")
+			fs.Float64("temperature", 0.05, "")
+			if err := fs.Parse(append([]string{"--config", configPath}, tt.flags...)); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := LoadConfig(configPath, &bytes.Buffer{}, fs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.temperatureSet != tt.want {
+				t.Errorf("temperatureSet = %v, want %v", cfg.temperatureSet, tt.want)
+			}
+		})
+	}
+}
@@ -2,7 +2,12 @@ package cgpt
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"math/rand/v2"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/tmc/langchaingo/llms"
@@ -10,6 +15,11 @@ import (
 
 type DummyBackend struct {
 	GenerateText func() string
+
+	// script, if set, determines the responses instead of GenerateText.
+	script *DummyScript
+	mu     sync.Mutex
+	used   []int // times each scripted response has been used
 }
 
 func NewDummyBackend() (*DummyBackend, error) {
@@ -41,6 +51,13 @@ func (d *DummyBackend) Call(ctx context.Context, prompt string, options ...llms.
 }
 
 func (d *DummyBackend) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	if d.script != nil {
+		opts := llms.CallOptions{}
+		for _, opt := range options {
+			opt(&opts)
+		}
+		return d.generateScripted(ctx, messages, opts)
+	}
 	dummyText := d.GenerateText()
 	words := strings.Fields(dummyText)
 	response := &llms.ContentResponse{
@@ -74,7 +91,28 @@ func (d *DummyBackend) GenerateContent(ctx context.Context, messages []llms.Mess
 	return response, nil
 }
 
-func (d *DummyBackend) CreateEmbedding(ctx context.Context, text string) ([]float64, error) {
-	// Dummy embedding (just return a fixed-size vector of zeros)
-	return make([]float64, 128), nil
+// dummyEmbeddingSize is the length of the dummy backend's embeddings.
+const dummyEmbeddingSize = 128
+
+// CreateEmbedding returns a unit vector for each text, derived from a hash of
+// the text, so equal texts have equal embeddings.
+func (d *DummyBackend) CreateEmbedding(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		sum := sha256.Sum256([]byte(text))
+		rng := rand.New(rand.NewPCG(binary.LittleEndian.Uint64(sum[:8]), binary.LittleEndian.Uint64(sum[8:16])))
+		v := make([]float32, dummyEmbeddingSize)
+		var norm float64
+		for j := range v {
+			x := rng.NormFloat64()
+			v[j] = float32(x)
+			norm += x * x
+		}
+		norm = math.Sqrt(norm)
+		for j := range v {
+			v[j] /= float32(norm)
+		}
+		embeddings[i] = v
+	}
+	return embeddings, nil
 }
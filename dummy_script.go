@@ -0,0 +1,266 @@
+package cgpt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+	"golang.org/x/tools/txtar"
+	"sigs.k8s.io/yaml"
+)
+
+// DummyScript scripts the responses of the dummy backend.
+//
+// A script is a YAML file with a list of responses:
+//
+//	responses:
+//	  - match: "what is your name?"
+//	    chunks: ["I am ", "a dummy."]
+//	    latency: 100ms
+//	    usage: {inputTokens: 12, outputTokens: 4}
+//	  - contains: "weather"
+//	    toolCalls:
+//	      - name: get_weather
+//	        arguments: '{"city": "Paris"}'
+//	  - error: "API returned unexpected status code: 429: rate limited"
+//	    times: 1
+//	  - content: "Fallback answer."
+//
+// or a txtar archive, in which each file name is matched against the last
+// user message ("*" matches anything) and the file holds the response:
+//
+//	-- what is your name? --
+//	I am a dummy.
+//	-- * --
+//	Fallback answer.
+type DummyScript struct {
+	Responses []DummyResponse `json:"responses"`
+}
+
+// DummyResponse is a scripted response. The first response whose conditions
+// match the last user message is used; a response without conditions
+// matches any message.
+type DummyResponse struct {
+	// Match matches the last user message exactly, ignoring surrounding space.
+	Match string `json:"match,omitempty"`
+	// Contains matches last user messages containing the text.
+	Contains string `json:"contains,omitempty"`
+	// Regexp matches last user messages matching the regular expression.
+	Regexp string `json:"regexp,omitempty"`
+
+	// Content is the response text, streamed a word at a time.
+	Content string `json:"content,omitempty"`
+	// Chunks is the response text as streamed, overriding Content.
+	Chunks []string `json:"chunks,omitempty"`
+	// Latency is the delay before the first chunk, such as "500ms".
+	Latency string `json:"latency,omitempty"`
+	// ChunkDelay is the delay between chunks.
+	ChunkDelay string `json:"chunkDelay,omitempty"`
+	// Usage is reported in the response's generation info.
+	Usage DummyUsage `json:"usage,omitempty"`
+	// ToolCalls are returned with the response.
+	ToolCalls []DummyToolCall `json:"toolCalls,omitempty"`
+	// StopReason is the response's stop reason.
+	StopReason string `json:"stopReason,omitempty"`
+	// Error fails the call, after streaming any chunks.
+	Error string `json:"error,omitempty"`
+	// Times limits how often the response is used. Zero means no limit.
+	Times int `json:"times,omitempty"`
+
+	re         *regexp.Regexp
+	latency    time.Duration
+	chunkDelay time.Duration
+}
+
+// DummyUsage is the token usage reported by a scripted response.
+type DummyUsage struct {
+	InputTokens     int `json:"inputTokens,omitempty"`
+	OutputTokens    int `json:"outputTokens,omitempty"`
+	CacheReadTokens int `json:"cacheReadTokens,omitempty"`
+}
+
+// DummyToolCall is a tool call returned by a scripted response.
+type DummyToolCall struct {
+	ID        string `json:"id,omitempty"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+// LoadDummyScript reads a script from a YAML or txtar file. Files ending in
+// .txtar are read as txtar archives.
+func LoadDummyScript(path string) (*DummyScript, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("dummy: %w", err)
+	}
+	script := &DummyScript{}
+	if filepath.Ext(path) == ".txtar" {
+		for _, f := range txtar.Parse(b).Files {
+			r := DummyResponse{Content: strings.TrimSuffix(string(f.Data), "\n")}
+			if f.Name != "*" {
+				r.Match = f.Name
+			}
+			script.Responses = append(script.Responses, r)
+		}
+	} else if err := yaml.Unmarshal(b, script); err != nil {
+		return nil, fmt.Errorf("dummy: invalid script %s: %w", path, err)
+	}
+	for i := range script.Responses {
+		if err := script.Responses[i].compile(); err != nil {
+			return nil, fmt.Errorf("dummy: %s: response %d: %w", path, i+1, err)
+		}
+	}
+	return script, nil
+}
+
+func (r *DummyResponse) compile() error {
+	var err error
+	if r.Regexp != "" {
+		if r.re, err = regexp.Compile(r.Regexp); err != nil {
+			return err
+		}
+	}
+	if r.Latency != "" {
+		if r.latency, err = time.ParseDuration(r.Latency); err != nil {
+			return fmt.Errorf("latency: %w", err)
+		}
+	}
+	if r.ChunkDelay != "" {
+		if r.chunkDelay, err = time.ParseDuration(r.ChunkDelay); err != nil {
+			return fmt.Errorf("chunkDelay: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *DummyResponse) matches(message string) bool {
+	message = strings.TrimSpace(message)
+	if r.Match != "" && strings.TrimSpace(r.Match) != message {
+		return false
+	}
+	if r.Contains != "" && !strings.Contains(message, r.Contains) {
+		return false
+	}
+	if r.re != nil && !r.re.MatchString(message) {
+		return false
+	}
+	return true
+}
+
+func (r *DummyResponse) chunks() []string {
+	if len(r.Chunks) > 0 {
+		return r.Chunks
+	}
+	words := strings.Fields(r.Content)
+	chunks := make([]string, len(words))
+	for i, w := range words {
+		if i > 0 {
+			w = " " + w
+		}
+		chunks[i] = w
+	}
+	return chunks
+}
+
+// NewScriptedDummyBackend returns a dummy backend that responds as the
+// script at path describes.
+func NewScriptedDummyBackend(path string) (*DummyBackend, error) {
+	script, err := LoadDummyScript(path)
+	if err != nil {
+		return nil, err
+	}
+	return &DummyBackend{script: script, used: make([]int, len(script.Responses))}, nil
+}
+
+// respond returns the scripted response for messages.
+func (d *DummyBackend) respond(messages []llms.MessageContent) (*DummyResponse, error) {
+	last := lastUserMessage(messages)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range d.script.Responses {
+		r := &d.script.Responses[i]
+		if r.Times > 0 && d.used[i] >= r.Times {
+			continue
+		}
+		if r.matches(last) {
+			d.used[i]++
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("dummy: no scripted response for %q", last)
+}
+
+// generateScripted generates a scripted response.
+func (d *DummyBackend) generateScripted(ctx context.Context, messages []llms.MessageContent, opts llms.CallOptions) (*llms.ContentResponse, error) {
+	r, err := d.respond(messages)
+	if err != nil {
+		return nil, err
+	}
+	if err := sleepContext(ctx, r.latency); err != nil {
+		return nil, err
+	}
+	chunks := r.chunks()
+	if opts.StreamingFunc != nil {
+		for i, c := range chunks {
+			if i > 0 {
+				if err := sleepContext(ctx, r.chunkDelay); err != nil {
+					return nil, err
+				}
+			}
+			if err := opts.StreamingFunc(ctx, []byte(c)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if r.Error != "" {
+		return nil, errors.New(r.Error)
+	}
+
+	choice := &llms.ContentChoice{
+		Content:    strings.Join(chunks, ""),
+		StopReason: r.StopReason,
+		GenerationInfo: map[string]any{
+			"InputTokens":          r.Usage.InputTokens,
+			"OutputTokens":         r.Usage.OutputTokens,
+			"CacheReadInputTokens": r.Usage.CacheReadTokens,
+		},
+	}
+	for i, tc := range r.ToolCalls {
+		id := tc.ID
+		if id == "" {
+			id = fmt.Sprintf("call_%d", i+1)
+		}
+		choice.ToolCalls = append(choice.ToolCalls, llms.ToolCall{
+			ID:           id,
+			Type:         "function",
+			FunctionCall: &llms.FunctionCall{Name: tc.Name, Arguments: tc.Arguments},
+		})
+	}
+	if len(choice.ToolCalls) > 0 {
+		choice.FuncCall = choice.ToolCalls[0].FunctionCall
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{choice}}, nil
+}
+
+// lastUserMessage returns the text of the last user message.
+func lastUserMessage(messages []llms.MessageContent) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role != llms.ChatMessageTypeHuman {
+			continue
+		}
+		var parts []string
+		for _, p := range messages[i].Parts {
+			if t, ok := p.(llms.TextContent); ok {
+				parts = append(parts, t.Text)
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
@@ -0,0 +1,135 @@
+package cgpt
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tmc/langchaingo/llms"
+)
+
+const testDummyScript = `responses:
+  - match: "what is your name?"
+    chunks: ["I am ", "a dummy."]
+    usage: {inputTokens: 12, outputTokens: 4}
+  - contains: "weather"
+    toolCalls:
+      - name: get_weather
+        arguments: '{"city": "Paris"}'
+  - regexp: "^fail"
+    chunks: ["partial"]
+    error: "API returned unexpected status code: 500"
+  - match: "flaky"
+    error: "API returned unexpected status code: 429: rate limited"
+    times: 1
+  - match: "flaky"
+    content: "recovered after a retry"
+`
+
+func TestScriptedDummyBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.yaml")
+	if err := os.WriteFile(path, []byte(testDummyScript), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewScriptedDummyBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		message    string
+		wantChunks []string
+		wantTool   string
+		wantUsage  int
+		wantErr    string
+	}{
+		{message: " what is your name? ", wantChunks: []string{"I am ", "a dummy."}, wantUsage: 12},
+		{message: "what's the weather like?", wantTool: "get_weather"},
+		{message: "fail now", wantChunks: []string{"partial"}, wantErr: "status code: 500"},
+		{message: "flaky", wantErr: "429"},
+		{message: "flaky", wantChunks: []string{"recovered", " after", " a", " retry"}},
+		{message: "flaky", wantChunks: []string{"recovered", " after", " a", " retry"}},
+		{message: "unscripted", wantErr: `no scripted response for "unscripted"`},
+	}
+	for _, tt := range tests {
+		messages := []llms.MessageContent{
+			llms.TextParts(llms.ChatMessageTypeSystem, "be brief"),
+			llms.TextParts(llms.ChatMessageTypeHuman, "an earlier question"),
+			llms.TextParts(llms.ChatMessageTypeAI, "an earlier answer"),
+			llms.TextParts(llms.ChatMessageTypeHuman, tt.message),
+		}
+		var chunks []string
+		resp, err := db.GenerateContent(context.Background(), messages, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+			chunks = append(chunks, string(chunk))
+			return nil
+		}))
+		if diff := cmp.Diff(tt.wantChunks, chunks); diff != "" {
+			t.Errorf("%q: chunks mismatch (-want +got):\n%s", tt.message, diff)
+		}
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%q: error = %v, want %q", tt.message, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", tt.message, err)
+		}
+		choice := resp.Choices[0]
+		if want := strings.Join(tt.wantChunks, ""); choice.Content != want {
+			t.Errorf("%q: content = %q, want %q", tt.message, choice.Content, want)
+		}
+		if got := choice.GenerationInfo["InputTokens"]; got != tt.wantUsage {
+			t.Errorf("%q: InputTokens = %v, want %d", tt.message, got, tt.wantUsage)
+		}
+		if tt.wantTool != "" && (len(choice.ToolCalls) != 1 || choice.ToolCalls[0].FunctionCall.Name != tt.wantTool) {
+			t.Errorf("%q: tool calls = %+v, want %s", tt.message, choice.ToolCalls, tt.wantTool)
+		}
+	}
+}
+
+func TestLoadDummyScriptTxtar(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.txtar")
+	script := "-- what is your name? --\nI am a dummy.\n-- * --\nFallback answer.\n"
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewScriptedDummyBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for message, want := range map[string]string{
+		"what is your name?": "I am a dummy.",
+		"anything else":      "Fallback answer.",
+	} {
+		got, err := db.Call(context.Background(), message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Call(%q) = %q, want %q", message, got, want)
+		}
+	}
+}
+
+func TestLoadDummyScriptErrors(t *testing.T) {
+	tests := map[string]string{
+		"bad regexp":  "responses:\n  - regexp: \"(\"\n",
+		"bad latency": "responses:\n  - latency: soon\n",
+		"bad yaml":    "responses: [",
+	}
+	for name, script := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "script.yaml")
+			if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadDummyScript(path); err == nil {
+				t.Error("LoadDummyScript succeeded, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package cgpt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Embedder creates vector embeddings of texts. The langchaingo clients of
+// backends with the Embeddings capability implement it.
+type Embedder interface {
+	CreateEmbedding(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// ResolveEmbeddingModel returns the backend and model used for embeddings.
+// They are configured separately from the chat backend and model: the
+// embedding backend defaults to the chat backend, and the embedding model to
+// the backend's default embedding model.
+func (cfg *Config) ResolveEmbeddingModel() (backend, model string, err error) {
+	backend = cfg.EmbeddingBackend
+	if backend == "" {
+		backend = cfg.Backend
+	}
+	b, ok := cfg.lookupBackend(backend)
+	if !ok {
+		return "", "", fmt.Errorf("unknown backend %q (available: %s)", backend, strings.Join(cfg.BackendNames(), ", "))
+	}
+	if !b.Capabilities.Embeddings {
+		return "", "", fmt.Errorf("backend %q does not support embeddings (set embeddingBackend)", backend)
+	}
+	model = cfg.EmbeddingModel
+	if model == "" {
+		model = b.DefaultEmbeddingModel
+	}
+	if model == "" {
+		return "", "", fmt.Errorf("no embedding model configured for backend %q (set embeddingModel)", backend)
+	}
+	return backend, model, nil
+}
+
+// NewEmbedder returns an Embedder for the configured embedding backend and
+// model.
+func NewEmbedder(cfg *Config, opts ...InferenceProviderOption) (Embedder, error) {
+	mo := &InferenceProviderOptions{}
+	for _, opt := range opts {
+		opt(mo)
+	}
+	backend, model, err := cfg.ResolveEmbeddingModel()
+	if err != nil {
+		return nil, err
+	}
+	b, _ := cfg.lookupBackend(backend)
+	ecfg := *cfg
+	ecfg.Backend, ecfg.Model = backend, model
+	// On Azure the embedding model names its deployment.
+	ecfg.Azure.Deployment = ""
+	m, err := b.New(&ecfg, mo)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := m.(Embedder)
+	if !ok {
+		return nil, fmt.Errorf("backend %q does not support embeddings", backend)
+	}
+	return e, nil
+}
+
+// EmbedOptions controls how inputs are split and embedded.
+type EmbedOptions struct {
+	// Split is how inputs are split into texts: "lines" (the default),
+	// "files" or "chunks".
+	Split string
+	// ChunkSize is the maximum length of a chunk in characters, 2000 by
+	// default. Chunks end at whitespace where possible.
+	ChunkSize int
+	// ChunkOverlap is the number of characters consecutive chunks share.
+	ChunkOverlap int
+	// BatchSize is the number of texts embedded per request, 100 by default.
+	BatchSize int
+}
+
+const (
+	defaultChunkSize      = 2000
+	defaultEmbedBatchSize = 100
+)
+
+// Embedding is the embedding of a piece of an input.
+type Embedding struct {
+	// Index is the position of the text among all texts embedded.
+	Index int             `json:"index"`
+	Type  InputSourceType `json:"type"`
+	// Source identifies the input, such as a file path.
+	Source string `json:"source,omitempty"`
+	// Line is the line number of the text in its input, when splitting
+	// by lines.
+	Line      int       `json:"line,omitempty"`
+	Text      string    `json:"text"`
+	Embedding []float32 `json:"embedding"`
+}
+
+// SplitInputs reads the inputs of opts and splits them into texts to
+// embed, as eo.Split specifies. Blank texts are skipped. The returned
+// embeddings have no vectors yet.
+func SplitInputs(ctx context.Context, opts RunOptions, eo EmbedOptions) ([]Embedding, error) {
+	size := eo.ChunkSize
+	if size <= 0 {
+		size = defaultChunkSize
+	}
+	if eo.ChunkOverlap < 0 || eo.ChunkOverlap >= size {
+		return nil, fmt.Errorf("chunk overlap %d must be less than the chunk size %d", eo.ChunkOverlap, size)
+	}
+	var split func(string) []string
+	switch eo.Split {
+	case "", "lines":
+		split = func(s string) []string { return strings.Split(s, "\n") }
+	case "files":
+		split = func(s string) []string { return []string{s} }
+	case "chunks":
+		split = func(s string) []string { return splitChunks(s, size, eo.ChunkOverlap) }
+	default:
+		return nil, fmt.Errorf("cannot split inputs by %q: want lines, files or chunks", eo.Split)
+	}
+
+	sources, err := opts.inputHandler().Sources(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get inputs: %w", err)
+	}
+	var texts []Embedding
+	for _, src := range sources {
+		b, err := io.ReadAll(src.Reader)
+		if c, ok := src.Reader.(io.Closer); ok {
+			c.Close()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read inputs: %w", err)
+		}
+		for i, text := range split(string(b)) {
+			text = strings.TrimSpace(text)
+			if text == "" {
+				continue
+			}
+			e := Embedding{Index: len(texts), Type: src.Type, Source: src.Name, Text: text}
+			if eo.Split == "" || eo.Split == "lines" {
+				e.Line = i + 1
+			}
+			texts = append(texts, e)
+		}
+	}
+	return texts, nil
+}
+
+// splitChunks splits s into chunks of at most size characters, sharing
+// overlap characters. A chunk ends after whitespace in its second half if
+// there is any, so that words are not cut in two.
+func splitChunks(s string, size, overlap int) []string {
+	r := []rune(s)
+	var chunks []string
+	for start := 0; start < len(r); {
+		end := min(start+size, len(r))
+		if end < len(r) {
+			for i := end; i > start+size/2; i-- {
+				if unicode.IsSpace(r[i-1]) {
+					end = i
+					break
+				}
+			}
+		}
+		chunks = append(chunks, string(r[start:end]))
+		if end == len(r) {
+			break
+		}
+		start = max(end-overlap, start+1)
+	}
+	return chunks
+}
+
+// Embed fills in the vectors of texts, in batches of eo.BatchSize.
+func Embed(ctx context.Context, e Embedder, texts []Embedding, eo EmbedOptions) error {
+	batch := eo.BatchSize
+	if batch <= 0 {
+		batch = defaultEmbedBatchSize
+	}
+	for start := 0; start < len(texts); start += batch {
+		end := min(start+batch, len(texts))
+		in := make([]string, 0, end-start)
+		for _, t := range texts[start:end] {
+			in = append(in, t.Text)
+		}
+		vectors, err := e.CreateEmbedding(ctx, in)
+		if err != nil {
+			return fmt.Errorf("failed to create embeddings: %w", err)
+		}
+		if len(vectors) != len(in) {
+			return fmt.Errorf("failed to create embeddings: got %d vectors for %d texts", len(vectors), len(in))
+		}
+		for i, v := range vectors {
+			texts[start+i].Embedding = v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package cgpt
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestSplitInputs(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(file, []byte("first line\n\nsecond line\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := RunOptions{InputFiles: []string{file}, InputStrings: []string{"the quick brown fox"}}
+	tests := []struct {
+		name string
+		eo   EmbedOptions
+		want []Embedding
+	}{
+		{"lines", EmbedOptions{}, []Embedding{
+			{Index: 0, Type: InputSourceFile, Source: file, Line: 1, Text: "first line"},
+			{Index: 1, Type: InputSourceFile, Source: file, Line: 3, Text: "second line"},
+			{Index: 2, Type: InputSourceString, Line: 1, Text: "the quick brown fox"},
+		}},
+		{"files", EmbedOptions{Split: "files"}, []Embedding{
+			{Index: 0, Type: InputSourceFile, Source: file, Text: "first line\n\nsecond line"},
+			{Index: 1, Type: InputSourceString, Text: "the quick brown fox"},
+		}},
+		{"chunks", EmbedOptions{Split: "chunks", ChunkSize: 12, ChunkOverlap: 4}, []Embedding{
+			{Index: 0, Type: InputSourceFile, Source: file, Text: "first line"},
+			{Index: 1, Type: InputSourceFile, Source: file, Text: "ne\n\nsecond"},
+			{Index: 2, Type: InputSourceFile, Source: file, Text: "ond line"},
+			{Index: 3, Type: InputSourceString, Text: "the quick"},
+			{Index: 4, Type: InputSourceString, Text: "ick brown"},
+			{Index: 5, Type: InputSourceString, Text: "own fox"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitInputs(context.Background(), opts, tt.eo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("SplitInputs mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+	if _, err := SplitInputs(context.Background(), opts, EmbedOptions{Split: "words"}); err == nil {
+		t.Error("SplitInputs by words succeeded, want an error")
+	}
+	if _, err := SplitInputs(context.Background(), opts, EmbedOptions{Split: "chunks", ChunkSize: 10, ChunkOverlap: 10}); err == nil {
+		t.Error("SplitInputs with overlap equal to the chunk size succeeded, want an error")
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	cfg := &Config{Backend: "anthropic", EmbeddingBackend: "dummy"}
+	backend, model, err := cfg.ResolveEmbeddingModel()
+	if err != nil || backend != "dummy" || model != "dummy" {
+		t.Fatalf("ResolveEmbeddingModel() = %q, %q, %v, want dummy, dummy", backend, model, err)
+	}
+	e, err := NewEmbedder(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []Embedding
+	for _, s := range []string{"a", "b", "a"} {
+		texts = append(texts, Embedding{Text: s})
+	}
+	counter := &countingEmbedder{Embedder: e}
+	if err := Embed(context.Background(), counter, texts, EmbedOptions{BatchSize: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if counter.calls != 2 {
+		t.Errorf("got %d requests, want 2", counter.calls)
+	}
+	if len(texts[0].Embedding) != dummyEmbeddingSize {
+		t.Errorf("got an embedding of length %d, want %d", len(texts[0].Embedding), dummyEmbeddingSize)
+	}
+	if !cmp.Equal(texts[0].Embedding, texts[2].Embedding) || cmp.Equal(texts[0].Embedding, texts[1].Embedding, cmpopts.EquateApprox(0, 1e-6)) {
+		t.Error("want equal embeddings for equal texts only")
+	}
+
+	cfg = &Config{Backend: "anthropic"}
+	if _, err := NewEmbedder(cfg); err == nil || !strings.Contains(err.Error(), "does not support embeddings") {
+		t.Errorf("NewEmbedder for anthropic = %v, want an unsupported error", err)
+	}
+}
+
+type countingEmbedder struct {
+	Embedder
+	calls int
+}
+
+func (e *countingEmbedder) CreateEmbedding(ctx context.Context, texts []string) ([][]float32, error) {
+	e.calls++
+	return e.Embedder.CreateEmbedding(ctx, texts)
+}
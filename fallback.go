@@ -0,0 +1,196 @@
+package cgpt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// FallbackTarget is a backend/model pair in a fallback chain.
+// If Model is empty the backend's default model is used.
+type FallbackTarget struct {
+	Backend string `yaml:"backend"`
+	Model   string `yaml:"model"`
+}
+
+// ErrFirstTokenTimeout is returned when a model does not produce its first
+// token within the configured deadline.
+var ErrFirstTokenTimeout = errors.New("timed out waiting for first token")
+
+// FallbackEntry is a model in a FallbackModel chain.
+type FallbackEntry struct {
+	Backend string
+	ModelID string
+	Model   llms.Model
+}
+
+// FallbackModel is an llms.Model that tries each of its entries in order.
+// It moves on to the next entry when a call fails with a retryable error, or
+// when it produces no token within FirstTokenTimeout. Once a streaming call
+// has produced output it is never retried, so callers never see duplicate
+// chunks.
+type FallbackModel struct {
+	Entries []FallbackEntry
+
+	// FirstTokenTimeout bounds the time to wait for the first streamed chunk.
+	// Without streaming, the whole response counts as the first token. Zero
+	// means no limit.
+	FirstTokenTimeout time.Duration
+
+	// OnFallback, if set, is called when an entry fails and the next one is tried.
+	OnFallback func(from, to FallbackEntry, err error)
+
+	mu     sync.Mutex
+	active int
+}
+
+// newFallbackModel builds a FallbackModel from the configured backend followed
+// by each of cfg.Fallbacks.
+func newFallbackModel(cfg *Config, mo *InferenceProviderOptions) (*FallbackModel, error) {
+	targets := append([]FallbackTarget{{Backend: cfg.Backend, Model: cfg.Model}}, cfg.Fallbacks...)
+	fm := &FallbackModel{FirstTokenTimeout: cfg.FirstTokenTimeout}
+	for _, t := range targets {
+		b, ok := cfg.lookupBackend(t.Backend)
+		if !ok {
+			return nil, fmt.Errorf("unknown fallback backend %q", t.Backend)
+		}
+		if t.Model == "" {
+			t.Model = b.DefaultModel
+		}
+		c := *cfg
+		c.Backend, c.Model, c.Fallbacks = t.Backend, t.Model, nil
+		m, err := newBackendModel(b, &c, mo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize fallback %s/%s: %w", t.Backend, t.Model, err)
+		}
+		fm.Entries = append(fm.Entries, FallbackEntry{Backend: t.Backend, ModelID: t.Model, Model: m})
+	}
+	return fm, nil
+}
+
+// ActiveModel reports the backend and model that served the most recent call.
+func (m *FallbackModel) ActiveModel() (backend, model string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.Entries) == 0 {
+		return "", ""
+	}
+	e := m.Entries[m.active]
+	return e.Backend, e.ModelID
+}
+
+func (m *FallbackModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+func (m *FallbackModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	if len(m.Entries) == 0 {
+		return nil, errors.New("fallback chain is empty")
+	}
+	opts := llms.CallOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	var errs []error
+	for i, e := range m.Entries {
+		resp, emitted, err := m.try(ctx, e, messages, opts.StreamingFunc, options)
+		if err == nil || emitted || ctx.Err() != nil || !isRetryableError(err) || i == len(m.Entries)-1 {
+			m.mu.Lock()
+			m.active = i
+			m.mu.Unlock()
+			if err != nil && len(errs) > 0 {
+				err = errors.Join(append(errs, fmt.Errorf("%s/%s: %w", e.Backend, e.ModelID, err))...)
+			}
+			return resp, err
+		}
+		errs = append(errs, fmt.Errorf("%s/%s: %w", e.Backend, e.ModelID, err))
+		if m.OnFallback != nil {
+			m.OnFallback(e, m.Entries[i+1], err)
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// try calls a single entry, reporting whether any chunk was streamed.
+func (m *FallbackModel) try(ctx context.Context, e FallbackEntry, messages []llms.MessageContent, streamingFunc func(context.Context, []byte) error, options []llms.CallOption) (*llms.ContentResponse, bool, error) {
+	callCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var (
+		mu       sync.Mutex
+		emitted  bool
+		timedOut bool
+	)
+	if m.FirstTokenTimeout > 0 {
+		timer := time.AfterFunc(m.FirstTokenTimeout, func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if !emitted {
+				timedOut = true
+				cancel(ErrFirstTokenTimeout)
+			}
+		})
+		defer timer.Stop()
+	}
+
+	if streamingFunc != nil {
+		options = append(slices.Clone(options), llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+			mu.Lock()
+			if timedOut {
+				mu.Unlock()
+				return ErrFirstTokenTimeout
+			}
+			emitted = true
+			mu.Unlock()
+			return streamingFunc(ctx, chunk)
+		}))
+	}
+
+	resp, err := e.Model.GenerateContent(callCtx, messages, options...)
+	mu.Lock()
+	defer mu.Unlock()
+	if timedOut {
+		return nil, emitted, ErrFirstTokenTimeout
+	}
+	return resp, emitted, err
+}
+
+var statusCodePattern = regexp.MustCompile(`status code:? (\d{3})`)
+
+// isRetryableError reports whether err is likely to be transient: rate limits,
+// overloaded or failing servers, network errors and first-token timeouts.
+func isRetryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrFirstTokenTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	if m := statusCodePattern.FindStringSubmatch(err.Error()); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		return isRetryableStatus(code)
+	}
+	return false
+}
+
+// isRetryableStatus reports whether an HTTP status code indicates a transient failure.
+func isRetryableStatus(code int) bool {
+	switch code {
+	case 408, 409, 425, 429, 500, 502, 503, 504, 529:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,246 @@
+package cgpt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+	"sigs.k8s.io/yaml"
+)
+
+// stubModel is a test model that returns a fixed response or error. It
+// records the messages and options of the last call.
+type stubModel struct {
+	chunks []string
+	err    error
+	delay  time.Duration
+	calls  int
+	// info is returned as the response's generation info.
+	info map[string]any
+
+	messages []llms.MessageContent
+	options  llms.CallOptions
+}
+
+func (m *stubModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+func (m *stubModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	m.calls++
+	opts := llms.CallOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	m.messages, m.options = messages, opts
+	select {
+	case <-time.After(m.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	for _, c := range m.chunks {
+		if opts.StreamingFunc != nil {
+			if err := opts.StreamingFunc(ctx, []byte(c)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: strings.Join(m.chunks, ""), GenerationInfo: m.info}}}, nil
+}
+
+func TestFallbackModel(t *testing.T) {
+	overloaded := errors.New("API returned unexpected status code: 529: overloaded")
+	badRequest := errors.New("API returned unexpected status code: 400: bad request")
+
+	tests := []struct {
+		name        string
+		first       *stubModel
+		stream      bool
+		timeout     time.Duration
+		want        string
+		wantErr     bool
+		wantBackend string
+		wantSecond  int
+	}{
+		{
+			name:        "retryable error falls back",
+			first:       &stubModel{err: overloaded},
+			want:        "second",
+			wantBackend: "b",
+			wantSecond:  1,
+		},
+		{
+			name:        "retryable streaming error falls back",
+			first:       &stubModel{err: overloaded},
+			stream:      true,
+			want:        "second",
+			wantBackend: "b",
+			wantSecond:  1,
+		},
+		{
+			name:        "non-retryable error does not fall back",
+			first:       &stubModel{err: badRequest},
+			wantErr:     true,
+			wantBackend: "a",
+		},
+		{
+			name:        "error after first chunk does not fall back",
+			first:       &stubModel{chunks: []string{"partial"}, err: overloaded},
+			stream:      true,
+			want:        "partial",
+			wantErr:     true,
+			wantBackend: "a",
+		},
+		{
+			name:        "first token timeout falls back",
+			first:       &stubModel{chunks: []string{"slow"}, delay: time.Second},
+			stream:      true,
+			timeout:     20 * time.Millisecond,
+			want:        "second",
+			wantBackend: "b",
+			wantSecond:  1,
+		},
+		{
+			name:        "slow non-streaming response falls back",
+			first:       &stubModel{chunks: []string{"slow"}, delay: time.Second},
+			timeout:     20 * time.Millisecond,
+			want:        "second",
+			wantBackend: "b",
+			wantSecond:  1,
+		},
+		{
+			name:        "non-streaming response in time does not fall back",
+			first:       &stubModel{chunks: []string{"first"}, delay: 10 * time.Millisecond},
+			timeout:     time.Second,
+			want:        "first",
+			wantBackend: "a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			second := &stubModel{chunks: []string{"second"}}
+			fm := &FallbackModel{
+				Entries: []FallbackEntry{
+					{Backend: "a", ModelID: "model-a", Model: tt.first},
+					{Backend: "b", ModelID: "model-b", Model: second},
+				},
+				FirstTokenTimeout: tt.timeout,
+			}
+			var streamed strings.Builder
+			var options []llms.CallOption
+			if tt.stream {
+				options = append(options, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+					streamed.Write(chunk)
+					return nil
+				}))
+			}
+			resp, err := fm.GenerateContent(context.Background(), nil, options...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GenerateContent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got := streamed.String()
+			if !tt.stream && resp != nil {
+				got = resp.Choices[0].Content
+			}
+			if got != tt.want {
+				t.Errorf("content = %q, want %q", got, tt.want)
+			}
+			if backend, _ := fm.ActiveModel(); backend != tt.wantBackend {
+				t.Errorf("ActiveModel() backend = %q, want %q", backend, tt.wantBackend)
+			}
+			if second.calls != tt.wantSecond {
+				t.Errorf("second model called %d times, want %d", second.calls, tt.wantSecond)
+			}
+		})
+	}
+}
+
+func TestFallbackPerformCompletionSlowResponse(t *testing.T) {
+	fm := &FallbackModel{
+		Entries: []FallbackEntry{
+			{Backend: "anthropic", ModelID: "claude", Model: &stubModel{chunks: []string{"slow"}, delay: time.Second}},
+			{Backend: "dummy", ModelID: "dummy-fallback", Model: &stubModel{chunks: []string{"fast"}}},
+		},
+		FirstTokenTimeout: 20 * time.Millisecond,
+	}
+	s, err := NewCompletionService(&Config{Backend: "anthropic", Model: "claude"}, fm, WithStdout(io.Discard), WithStderr(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.payload.addUserMessage("hi")
+	got, err := s.PerformCompletion(context.Background(), s.payload, PerformCompletionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "fast" {
+		t.Errorf("PerformCompletion() = %q, want the fallback's %q", got, "fast")
+	}
+}
+
+func TestFallbackHistoryRecordsActiveModel(t *testing.T) {
+	fm := &FallbackModel{
+		Entries: []FallbackEntry{
+			{Backend: "anthropic", ModelID: "claude", Model: &stubModel{err: errors.New("API returned unexpected status code: 429")}},
+			{Backend: "dummy", ModelID: "dummy-fallback", Model: &stubModel{chunks: []string{"hello"}}},
+		},
+	}
+	historyFile := filepath.Join(t.TempDir(), "history.yaml")
+	cfg := &Config{Backend: "anthropic", Model: "claude"}
+	s, err := NewCompletionService(cfg, fm, WithStdout(io.Discard), WithStderr(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.historyOutFile = historyFile
+	s.payload.addUserMessage("hi")
+	if _, err := s.PerformCompletion(context.Background(), s.payload, PerformCompletionConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.saveHistory(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(historyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h history
+	if err := yaml.Unmarshal(b, &h); err != nil {
+		t.Fatal(err)
+	}
+	if h.Backend != "dummy" || h.Model != "dummy-fallback" {
+		t.Errorf("history backend/model = %s/%s, want the answering dummy/dummy-fallback", h.Backend, h.Model)
+	}
+	if h.ConfiguredBackend != "anthropic" || h.ConfiguredModel != "claude" {
+		t.Errorf("history configured backend/model = %s/%s, want anthropic/claude", h.ConfiguredBackend, h.ConfiguredModel)
+	}
+	if s.payload.Model != "claude" {
+		t.Errorf("payload model = %q, want the configured claude", s.payload.Model)
+	}
+
+	// A continued conversation starts with the configured model again.
+	f, err := os.Open(historyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s2, err := NewCompletionService(cfg, fm, WithStdout(io.Discard), WithStderr(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2.historyIn = f
+	if err := s2.loadHistory(); err != nil {
+		t.Fatal(err)
+	}
+	if s2.payload.Model != "claude" {
+		t.Errorf("loaded payload model = %q, want the configured claude", s2.payload.Model)
+	}
+}
@@ -0,0 +1,313 @@
+package cgpt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// Faults configures failures injected into completions, to test how cgpt
+// recovers from them. Each probability is between 0 and 1 and is rolled
+// for every call; at most one of timeout, rate limit, disconnect and
+// truncation applies to a call, in that order.
+//
+// Faults are set under 'faults' in the config file (or per backend), or
+// with CGPT_FAULTS as comma-separated key=value pairs, such as
+// "rateLimit=1,retryAfter=2s,limit=1".
+type Faults struct {
+	// Timeout fails calls with context.DeadlineExceeded after TimeoutAfter.
+	Timeout      float64       `yaml:"timeout"`
+	TimeoutAfter time.Duration `yaml:"timeoutAfter"`
+	// RateLimit fails calls with a 429 error asking to retry after RetryAfter.
+	RateLimit  float64       `yaml:"rateLimit"`
+	RetryAfter time.Duration `yaml:"retryAfter"`
+	// Disconnect fails calls after Chunks chunks, as if the connection dropped.
+	Disconnect float64 `yaml:"disconnect"`
+	// Truncate ends responses early, without an error, after Chunks chunks.
+	Truncate float64 `yaml:"truncate"`
+	// Chunks is the number of chunks delivered before a disconnect or
+	// truncation, zero by default. Responses that are not streamed are cut
+	// to as many words.
+	Chunks int `yaml:"chunks"`
+	// SlowFirstToken delays the first chunk by FirstTokenDelay.
+	SlowFirstToken  float64       `yaml:"slowFirstToken"`
+	FirstTokenDelay time.Duration `yaml:"firstTokenDelay"`
+	// Limit caps the number of calls that faults are injected into.
+	// Zero is unlimited.
+	Limit int `yaml:"limit"`
+	// Seed, if set, makes the injected faults reproducible.
+	Seed uint64 `yaml:"seed"`
+}
+
+var defaultFaults = Faults{
+	TimeoutAfter:    10 * time.Second,
+	RetryAfter:      time.Second,
+	FirstTokenDelay: 5 * time.Second,
+}
+
+func (f Faults) enabled() bool {
+	return f.Timeout > 0 || f.RateLimit > 0 || f.Disconnect > 0 || f.Truncate > 0 || f.SlowFirstToken > 0
+}
+
+// withDefaults returns f with unset delays filled in.
+func (f Faults) withDefaults() Faults {
+	if f.TimeoutAfter == 0 {
+		f.TimeoutAfter = defaultFaults.TimeoutAfter
+	}
+	if f.RetryAfter == 0 {
+		f.RetryAfter = defaultFaults.RetryAfter
+	}
+	if f.FirstTokenDelay == 0 {
+		f.FirstTokenDelay = defaultFaults.FirstTokenDelay
+	}
+	return f
+}
+
+// faults returns the faults to inject into the named backend. Backend
+// settings replace the top-level settings.
+func (cfg *Config) faults(backend string) Faults {
+	if bc, ok := cfg.Backends[backend]; ok && bc.Faults.enabled() {
+		return bc.Faults
+	}
+	return cfg.Faults
+}
+
+// ParseFaults parses a fault specification of comma-separated key=value
+// pairs, where keys are the Faults field names, case-insensitively.
+func ParseFaults(spec string) (Faults, error) {
+	var f Faults
+	for _, kv := range strings.Split(spec, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return Faults{}, fmt.Errorf("invalid fault %q: want key=value", kv)
+		}
+		var err error
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "timeout":
+			f.Timeout, err = parseProbability(value)
+		case "timeoutafter":
+			f.TimeoutAfter, err = time.ParseDuration(value)
+		case "ratelimit":
+			f.RateLimit, err = parseProbability(value)
+		case "retryafter":
+			f.RetryAfter, err = time.ParseDuration(value)
+		case "disconnect":
+			f.Disconnect, err = parseProbability(value)
+		case "truncate":
+			f.Truncate, err = parseProbability(value)
+		case "chunks":
+			f.Chunks, err = strconv.Atoi(value)
+		case "slowfirsttoken":
+			f.SlowFirstToken, err = parseProbability(value)
+		case "firsttokendelay":
+			f.FirstTokenDelay, err = time.ParseDuration(value)
+		case "limit":
+			f.Limit, err = strconv.Atoi(value)
+		case "seed":
+			f.Seed, err = strconv.ParseUint(value, 10, 64)
+		default:
+			return Faults{}, fmt.Errorf("unknown fault %q", key)
+		}
+		if err != nil {
+			return Faults{}, fmt.Errorf("invalid fault %q: %w", kv, err)
+		}
+	}
+	return f, nil
+}
+
+func parseProbability(s string) (float64, error) {
+	p, err := strconv.ParseFloat(s, 64)
+	if err == nil && (p < 0 || p > 1) {
+		err = fmt.Errorf("probability %v is not between 0 and 1", p)
+	}
+	return p, err
+}
+
+// FaultError is the error returned for an injected rate limit. Its message
+// matches a provider's, so it is retried like one.
+type FaultError struct {
+	RetryAfter time.Duration
+}
+
+func (e *FaultError) Error() string {
+	return fmt.Sprintf("fault: API returned unexpected status code: 429: rate limited, retry after %v", e.RetryAfter)
+}
+
+// retryDelay returns the delay the error asks for before retrying.
+func (e *FaultError) retryDelay() time.Duration { return e.RetryAfter }
+
+// FaultModel is an llms.Model that injects the configured Faults into calls
+// to the wrapped model.
+type FaultModel struct {
+	Model  llms.Model
+	Faults Faults
+
+	// OnFault, if set, is called when a fault is injected.
+	OnFault func(fault string)
+
+	mu       sync.Mutex
+	rand     *rand.Rand
+	injected int
+}
+
+// NewFaultModel returns a FaultModel injecting faults into m.
+func NewFaultModel(m llms.Model, faults Faults) *FaultModel {
+	seed := faults.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	return &FaultModel{Model: m, Faults: faults.withDefaults(), rand: rand.New(rand.NewPCG(seed, seed))}
+}
+
+func (m *FaultModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+// pick rolls the dice for a call, returning the fault to inject, if any,
+// and whether to delay the first token.
+func (m *FaultModel) pick() (fault string, slow bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Faults.Limit > 0 && m.injected >= m.Faults.Limit {
+		return "", false
+	}
+	for _, f := range []struct {
+		name string
+		p    float64
+	}{
+		{"timeout", m.Faults.Timeout},
+		{"rate limit", m.Faults.RateLimit},
+		{"disconnect", m.Faults.Disconnect},
+		{"truncate", m.Faults.Truncate},
+	} {
+		if f.p > 0 && m.rand.Float64() < f.p {
+			fault = f.name
+			break
+		}
+	}
+	slow = m.Faults.SlowFirstToken > 0 && m.rand.Float64() < m.Faults.SlowFirstToken
+	if fault != "" || slow {
+		m.injected++
+	}
+	return fault, slow
+}
+
+func (m *FaultModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	fault, slow := m.pick()
+	if m.OnFault != nil {
+		if fault != "" {
+			m.OnFault(fault)
+		}
+		if slow {
+			m.OnFault("slow first token")
+		}
+	}
+
+	switch fault {
+	case "timeout":
+		if err := sleepContext(ctx, m.Faults.TimeoutAfter); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("fault: request timed out after %v: %w", m.Faults.TimeoutAfter, context.DeadlineExceeded)
+	case "rate limit":
+		return nil, &FaultError{RetryAfter: m.Faults.RetryAfter}
+	}
+
+	opts := llms.CallOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	if opts.StreamingFunc == nil {
+		if slow {
+			if err := sleepContext(ctx, m.Faults.FirstTokenDelay); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := m.Model.GenerateContent(ctx, messages, options...)
+		if err != nil {
+			return resp, err
+		}
+		switch fault {
+		case "disconnect":
+			return nil, errDisconnected
+		case "truncate":
+			for _, c := range resp.Choices {
+				c.Content = firstWords(c.Content, m.Faults.Chunks)
+			}
+		}
+		return resp, nil
+	}
+
+	// Deliver chunks until the fault strikes. Once it does, the rest of
+	// the response is dropped while the wrapped model finishes.
+	var (
+		delivered strings.Builder
+		n         int
+		cut       bool
+	)
+	genCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	options = append(slices.Clone(options), llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+		if cut {
+			return nil
+		}
+		if n == 0 && slow {
+			if err := sleepContext(ctx, m.Faults.FirstTokenDelay); err != nil {
+				return err
+			}
+		}
+		if (fault == "disconnect" || fault == "truncate") && n >= m.Faults.Chunks {
+			cut = true
+			if fault == "disconnect" {
+				cancel()
+			}
+			return nil
+		}
+		n++
+		delivered.Write(chunk)
+		return opts.StreamingFunc(ctx, chunk)
+	}))
+	resp, err := m.Model.GenerateContent(genCtx, messages, options...)
+	switch {
+	case fault == "disconnect" && (cut || err == nil):
+		return nil, errDisconnected
+	case err != nil:
+		return resp, err
+	case cut && len(resp.Choices) > 0:
+		resp.Choices[0].Content = delivered.String()
+	}
+	return resp, nil
+}
+
+// errDisconnected is returned for an injected mid-stream disconnect.
+var errDisconnected = fmt.Errorf("fault: connection lost mid-stream: %w", io.ErrUnexpectedEOF)
+
+// firstWords returns the first n whitespace-separated words of s, with
+// their leading space.
+func firstWords(s string, n int) string {
+	words := 0
+	inWord := false
+	for i, r := range s {
+		space := r == ' ' || r == '\n' || r == '\t' || r == '\r'
+		if !space && !inWord {
+			if words == n {
+				return strings.TrimRight(s[:i], " \n\t\r")
+			}
+			words++
+		}
+		inWord = !space
+	}
+	return s
+}
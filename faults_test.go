@@ -0,0 +1,246 @@
+package cgpt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/pflag"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestParseFaults(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Faults
+		wantErr bool
+	}{
+		{spec: "", want: Faults{}},
+		{spec: "rateLimit=1, retryAfter=2s,limit=1", want: Faults{RateLimit: 1, RetryAfter: 2 * time.Second, Limit: 1}},
+		{spec: "disconnect=0.5,chunks=2,seed=7", want: Faults{Disconnect: 0.5, Chunks: 2, Seed: 7}},
+		{spec: "SLOWFIRSTTOKEN=1,firstTokenDelay=10ms", want: Faults{SlowFirstToken: 1, FirstTokenDelay: 10 * time.Millisecond}},
+		{spec: "timeout", wantErr: true},
+		{spec: "timeout=2", wantErr: true},
+		{spec: "explode=1", wantErr: true},
+		{spec: "retryAfter=soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFaults(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseFaults(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseFaults(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestFaultModel(t *testing.T) {
+	words := []string{"one", " two", " three", " four", " five"}
+	tests := []struct {
+		name        string
+		faults      Faults
+		stream      bool
+		wantChunks  []string
+		wantContent string
+		wantErr     error
+	}{
+		{
+			name:        "no faults",
+			stream:      true,
+			wantChunks:  words,
+			wantContent: "one two three four five",
+		},
+		{
+			name:    "timeout",
+			faults:  Faults{Timeout: 1, TimeoutAfter: time.Millisecond},
+			stream:  true,
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name:    "rate limit",
+			faults:  Faults{RateLimit: 1},
+			wantErr: &FaultError{},
+		},
+		{
+			name:       "disconnect mid-stream",
+			faults:     Faults{Disconnect: 1, Chunks: 2},
+			stream:     true,
+			wantChunks: words[:2],
+			wantErr:    io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "disconnect before the first chunk",
+			faults:  Faults{Disconnect: 1},
+			stream:  true,
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:        "truncate stream",
+			faults:      Faults{Truncate: 1, Chunks: 3},
+			stream:      true,
+			wantChunks:  words[:3],
+			wantContent: "one two three",
+		},
+		{
+			name:        "truncate without streaming",
+			faults:      Faults{Truncate: 1, Chunks: 2},
+			wantContent: "one two",
+		},
+		{
+			name:        "slow first token",
+			faults:      Faults{SlowFirstToken: 1, FirstTokenDelay: time.Millisecond},
+			stream:      true,
+			wantChunks:  words,
+			wantContent: "one two three four five",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var injected []string
+			m := NewFaultModel(&stubModel{chunks: words}, tt.faults)
+			m.OnFault = func(fault string) { injected = append(injected, fault) }
+			var chunks []string
+			var options []llms.CallOption
+			if tt.stream {
+				options = append(options, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+					chunks = append(chunks, string(chunk))
+					return nil
+				}))
+			}
+			resp, err := m.GenerateContent(context.Background(), []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "count")}, options...)
+			if diff := cmp.Diff(tt.wantChunks, chunks); diff != "" {
+				t.Errorf("chunks mismatch (-want +got):\n%s", diff)
+			}
+			if tt.wantErr != nil {
+				var fe *FaultError
+				if errors.As(tt.wantErr, &fe) {
+					if !errors.As(err, &fe) || !isRetryableError(err) {
+						t.Errorf("error = %v, want a retryable FaultError", err)
+					}
+				} else if !errors.Is(err, tt.wantErr) {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := resp.Choices[0].Content; got != tt.wantContent {
+				t.Errorf("content = %q, want %q", got, tt.wantContent)
+			}
+			if tt.faults.enabled() && len(injected) != 1 {
+				t.Errorf("OnFault called for %q, want one fault", injected)
+			}
+		})
+	}
+}
+
+func TestFaultModelLimitAndRetry(t *testing.T) {
+	stub := &stubModel{chunks: []string{"recovered"}}
+	faults := NewFaultModel(stub, Faults{RateLimit: 1, RetryAfter: 3 * time.Second, Limit: 1})
+	var delays []time.Duration
+	m := &RetryModel{
+		Model:  faults,
+		Policy: defaultRetryPolicy,
+		sleep: func(ctx context.Context, d time.Duration) error {
+			delays = append(delays, d)
+			return nil
+		},
+	}
+	resp, err := m.GenerateContent(context.Background(), []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "hi")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Choices[0].Content != "recovered" {
+		t.Errorf("content = %q, want %q", resp.Choices[0].Content, "recovered")
+	}
+	if diff := cmp.Diff([]time.Duration{3 * time.Second}, delays); diff != "" {
+		t.Errorf("retry delays mismatch (-want +got):\n%s", diff)
+	}
+	if stub.calls != 1 {
+		t.Errorf("wrapped model called %d times, want 1", stub.calls)
+	}
+}
+
+func TestFaultsFromEnv(t *testing.T) {
+	loadConfig := func() (*Config, error) {
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		fs.String("config", "", "")
+		fs.String("backend", "dummy", "")
+		fs.String("model", "dummy", "")
+		return LoadConfig("", io.Discard, fs)
+	}
+	t.Setenv("CGPT_FAULTS", "truncate=1,chunks=1")
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Faults{Truncate: 1, Chunks: 1}); cfg.Faults != want {
+		t.Fatalf("Faults = %+v, want %+v", cfg.Faults, want)
+	}
+	model, err := InitializeModel(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := model.(*RetryModel).Model.(*FaultModel); !ok {
+		t.Fatalf("model is not wrapped with faults: %T", model.(*RetryModel).Model)
+	}
+
+	t.Setenv("CGPT_FAULTS", "explode=1")
+	if _, err := loadConfig(); err == nil {
+		t.Error("LoadConfig succeeded with an invalid CGPT_FAULTS")
+	}
+}
+
+// TestFaultRecovery checks that a failed streaming completion leaves the
+// conversation without the prefill or an empty reply, and keeps partial output.
+func TestFaultRecovery(t *testing.T) {
+	tests := []struct {
+		name       string
+		faults     Faults
+		wantOutput string
+		wantLast   string
+	}{
+		{name: "disconnect before the first chunk", faults: Faults{Disconnect: 1}, wantLast: "hi"},
+		{name: "disconnect mid-stream", faults: Faults{Disconnect: 1, Chunks: 2}, wantOutput: "one two", wantLast: "one two"},
+		{name: "timeout", faults: Faults{Timeout: 1, TimeoutAfter: time.Millisecond}, wantLast: "hi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := NewFaultModel(&stubModel{chunks: []string{"one", " two", " three"}}, tt.faults)
+			s, err := NewCompletionService(&Config{Backend: "dummy", Model: "dummy"}, model, WithStdout(io.Discard), WithStderr(io.Discard))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.payload.addUserMessage("hi")
+			s.SetNextCompletionPrefill("Sure,")
+			ch, err := s.PerformCompletionStreaming(context.Background(), s.payload, PerformCompletionConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out strings.Builder
+			for chunk := range ch {
+				out.WriteString(chunk)
+			}
+			if out.String() != tt.wantOutput {
+				t.Errorf("output = %q, want %q", out.String(), tt.wantOutput)
+			}
+			msgs := s.payload.Messages
+			last := msgs[len(msgs)-1]
+			if got := last.Parts[0].(llms.TextContent).Text; got != tt.wantLast {
+				t.Errorf("last message = %q, want %q (messages: %v)", got, tt.wantLast, msgs)
+			}
+			for _, m := range msgs {
+				if m.Role == llms.ChatMessageTypeAI && m.Parts[0].(llms.TextContent).Text == "Sure," {
+					t.Errorf("prefill left in conversation: %v", msgs)
+				}
+			}
+		})
+	}
+}
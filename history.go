@@ -12,9 +12,19 @@ import (
 )
 
 type history struct {
+	// Backend and Model served the last completion.
 	Backend  string                `json:"backend"`
 	Model    string                `json:"model"`
 	Messages []llms.MessageContent `json:"messages"`
+	Usage    []MessageUsage        `json:"usage,omitempty"`
+	Thinking []MessageThinking     `json:"thinking,omitempty"`
+	// Alternatives are sibling completions of assistant messages.
+	Alternatives []MessageAlternatives `json:"alternatives,omitempty"`
+	// ConfiguredBackend and ConfiguredModel are the backend and model the
+	// conversation was configured with, if they differ from Backend and
+	// Model (e.g. after a fallback). Later turns start with them.
+	ConfiguredBackend string `json:"configuredBackend,omitempty"`
+	ConfiguredModel   string `json:"configuredModel,omitempty"`
 }
 
 // loadHistory loads the history from the history file (as yaml)
@@ -30,10 +40,17 @@ func (s *CompletionService) loadHistory() error {
 	if err := yaml.Unmarshal(b, &h); err != nil {
 		return err
 	}
-	if h.Model != "" {
+	switch {
+	case h.ConfiguredModel != "":
+		s.payload.Model = h.ConfiguredModel
+		s.payload.ActiveBackend, s.payload.ActiveModel = h.Backend, h.Model
+	case h.Model != "":
 		s.payload.Model = h.Model
 	}
 	s.payload.Messages = h.Messages
+	s.payload.Usage = h.Usage
+	s.payload.Thinking = h.Thinking
+	s.payload.Alternatives = h.Alternatives
 	return nil
 }
 
@@ -62,6 +79,22 @@ func (s *CompletionService) saveHistory() error {
 	return nil
 }
 
+// historyPath returns the path the history is saved to, or "" if history
+// is disabled.
+func (s *CompletionService) historyPath() string {
+	if s.disableHistory {
+		return ""
+	}
+	if s.historyOutFile != "" {
+		return s.historyOutFile
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".cgpt", fmt.Sprintf("default-history-%s.yaml", s.sessionTimestamp))
+}
+
 // saveHistory saves the history to the history file (as yaml)
 func createHistoryFile(historyOutFile string, backend string, payload *ChatCompletionPayload, messages []llms.MessageContent) error {
 	f, err := os.Create(historyOutFile)
@@ -73,9 +106,16 @@ func createHistoryFile(historyOutFile string, backend string, payload *ChatCompl
 		return nil
 	}
 	h := history{
-		Backend:  backend,
-		Model:    payload.Model,
-		Messages: messages,
+		Backend:      backend,
+		Model:        payload.Model,
+		Messages:     messages,
+		Usage:        payload.Usage,
+		Thinking:     payload.Thinking,
+		Alternatives: payload.Alternatives,
+	}
+	if payload.ActiveBackend != "" {
+		h.Backend, h.Model = payload.ActiveBackend, payload.ActiveModel
+		h.ConfiguredBackend, h.ConfiguredModel = backend, payload.Model
 	}
 	// encode with k8s yaml encoder: which doesn't define NewEncoder:
 	ybytes, err := yaml.Marshal(h)
@@ -0,0 +1,316 @@
+// Package httprecord records HTTP traffic to cassette files and replays it,
+// so that backends can be exercised end-to-end without network access.
+//
+// A cassette is a JSON file holding a sequence of request/response pairs.
+// Credentials are redacted before anything is written to disk.
+package httprecord
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces secrets in recorded traffic.
+const Redacted = "REDACTED"
+
+// RedactedHeaders are headers whose values are never written to a cassette.
+var RedactedHeaders = []string{
+	"Authorization",
+	"Api-Key",
+	"X-Api-Key",
+	"X-Goog-Api-Key",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// redactedParams are query parameters whose values are never written to a cassette.
+var redactedParams = []string{"key", "api-key", "api_key"}
+
+// Cassette is a recorded sequence of HTTP interactions.
+type Cassette struct {
+	// Comment describes the cassette, such as how a hand-written one was
+	// made. Recorded cassettes have none.
+	Comment      string        `json:"comment,omitempty"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a recorded request and its response.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is a recorded HTTP request.
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// Response is a recorded HTTP response.
+type Response struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// Load reads a cassette file.
+func Load(path string) (*Cassette, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cassette{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("httprecord: invalid cassette %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Save writes the cassette to path.
+func (c *Cassette) Save(path string) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// Recorder is an http.RoundTripper that passes requests to an underlying
+// transport and records them, with their responses, to a cassette file.
+// The file is rewritten as each interaction completes, so a recording
+// survives the process exiting without cleanup.
+type Recorder struct {
+	path string
+	base http.RoundTripper
+
+	mu       sync.Mutex
+	cassette Cassette
+	secrets  []string
+}
+
+// NewRecorder returns a Recorder writing to path. If base is nil,
+// http.DefaultTransport is used. Values in secrets are redacted wherever
+// they appear, in addition to the values of RedactedHeaders.
+func NewRecorder(path string, base http.RoundTripper, secrets ...string) *Recorder {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	r := &Recorder{path: path, base: base}
+	for _, v := range secrets {
+		r.addSecret(v)
+	}
+	return r
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	for _, name := range RedactedHeaders {
+		for _, v := range req.Header.Values(name) {
+			r.addSecret(strings.TrimPrefix(v, "Bearer "))
+		}
+	}
+	for _, name := range redactedParams {
+		r.addSecret(req.URL.Query().Get(name))
+	}
+	recorded := Request{
+		Method: req.Method,
+		URL:    r.redact(redactParams(req.URL)),
+		Header: r.redactHeader(req.Header),
+		Body:   r.redact(string(body)),
+	}
+	r.mu.Unlock()
+
+	resp, err := r.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	// Record the response body as it is read, so streamed responses still
+	// reach the caller incrementally.
+	resp.Body = &recordingBody{
+		ReadCloser: resp.Body,
+		done: func(b []byte) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+				Request: recorded,
+				Response: Response{
+					StatusCode: resp.StatusCode,
+					Header:     r.redactHeader(resp.Header),
+					Body:       r.redact(string(b)),
+				},
+			})
+			// An error here leaves the previous version of the file, and
+			// there is no caller to report it to.
+			r.cassette.Save(r.path)
+		},
+	}
+	return resp, nil
+}
+
+// addSecret adds a value to redact. r.mu must be held.
+func (r *Recorder) addSecret(v string) {
+	if v != "" && !slices.Contains(r.secrets, v) {
+		r.secrets = append(r.secrets, v)
+	}
+}
+
+func (r *Recorder) redact(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+	return s
+}
+
+func (r *Recorder) redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, name := range RedactedHeaders {
+		if h.Get(name) != "" {
+			h.Set(name, Redacted)
+		}
+	}
+	for name, values := range h {
+		for i, v := range values {
+			values[i] = r.redact(v)
+		}
+		h[name] = values
+	}
+	return h
+}
+
+// recordingBody captures a response body, calling done once with everything
+// read when the body reaches EOF or is closed.
+type recordingBody struct {
+	io.ReadCloser
+	buf  bytes.Buffer
+	once sync.Once
+	done func([]byte)
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if err == io.EOF {
+		b.once.Do(func() { b.done(b.buf.Bytes()) })
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	b.once.Do(func() { b.done(b.buf.Bytes()) })
+	return b.ReadCloser.Close()
+}
+
+// Replayer is an http.RoundTripper that serves responses from a cassette.
+// Each request is answered by the first unused interaction with the same
+// method, URL and body, so repeated identical requests replay in order.
+type Replayer struct {
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool
+}
+
+// NewReplayer returns a Replayer serving the cassette at path.
+func NewReplayer(path string) (*Replayer, error) {
+	c, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Replayer{cassette: c, used: make([]bool, len(c.Interactions))}, nil
+}
+
+// ErrNoInteraction is returned for requests that are not in the cassette.
+var ErrNoInteraction = errors.New("httprecord: no recorded interaction")
+
+// RoundTrip implements http.RoundTripper.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+	u := redactParams(req.URL)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, in := range r.cassette.Interactions {
+		if r.used[i] || in.Request.Method != req.Method || in.Request.URL != u || in.Request.Body != string(body) {
+			continue
+		}
+		r.used[i] = true
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Response.StatusCode, http.StatusText(in.Response.StatusCode)),
+			StatusCode:    in.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        in.Response.Header.Clone(),
+			Body:          io.NopCloser(strings.NewReader(in.Response.Body)),
+			ContentLength: int64(len(in.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("%w for %s %s", ErrNoInteraction, req.Method, u)
+}
+
+// Unused returns the number of interactions that have not been replayed.
+func (r *Replayer) Unused() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, used := range r.used {
+		if !used {
+			n++
+		}
+	}
+	return n
+}
+
+// redactParams returns u as a string with secret query parameters redacted,
+// as they appear in a cassette. Other secrets are only known while recording,
+// so requests carrying them will not match.
+func redactParams(u *url.URL) string {
+	u2 := *u
+	q := u2.Query()
+	for _, name := range redactedParams {
+		if q.Has(name) {
+			q.Set(name, Redacted)
+		}
+	}
+	u2.RawQuery = q.Encode()
+	return u2.String()
+}
+
+// readBody reads and replaces a request body, so it can be read again.
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+	b, err := io.ReadAll(*body)
+	(*body).Close()
+	if err != nil {
+		return nil, err
+	}
+	*body = io.NopCloser(bytes.NewReader(b))
+	return b, nil
+}
@@ -0,0 +1,87 @@
+package httprecord
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=abc")
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: call %d for %s\n\n", calls, body)
+		w.(http.Flusher).Flush()
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	recording := &http.Client{Transport: NewRecorder(path, nil, "body-secret")}
+	do := func(client *http.Client, body string) (string, error) {
+		req, err := http.NewRequest("POST", srv.URL+"/v1/chat?key=query-secret&alt=sse", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer header-secret")
+		req.Header.Set("X-Api-Key", "other-secret")
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		return string(b), err
+	}
+
+	var recorded []string
+	for _, body := range []string{"first", "first", "second with body-secret"} {
+		got, err := do(recording, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorded = append(recorded, got)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"query-secret", "header-secret", "other-secret", "body-secret", "session=abc"} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("cassette contains %q:\n%s", secret, b)
+		}
+	}
+
+	srv.Close()
+	replayer, err := NewReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaying := &http.Client{Transport: replayer}
+	// The redacted body secret no longer matches, so replay the redacted body.
+	for i, body := range []string{"first", "first", "second with " + Redacted} {
+		got, err := do(replaying, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := strings.ReplaceAll(recorded[i], "body-secret", Redacted); got != want {
+			t.Errorf("replay %d = %q, want %q", i, got, want)
+		}
+	}
+	if n := replayer.Unused(); n != 0 {
+		t.Errorf("Unused() = %d, want 0", n)
+	}
+	if _, err := do(replaying, "first"); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("replay past the cassette: error = %v, want ErrNoInteraction", err)
+	}
+}
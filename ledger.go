@@ -0,0 +1,154 @@
+package cgpt
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// LedgerEntry is a completion recorded in the usage ledger.
+type LedgerEntry struct {
+	Time    time.Time `json:"time"`
+	Backend string    `json:"backend"`
+	Model   string    `json:"model"`
+	Profile string    `json:"profile,omitempty"`
+	// Dir is the working directory of the run.
+	Dir string `json:"dir,omitempty"`
+	// History is the history file of the conversation.
+	History string `json:"history,omitempty"`
+	Usage
+	// LatencyMs is the duration of the request in milliseconds.
+	LatencyMs int64 `json:"latencyMs"`
+}
+
+// DefaultLedgerPath returns the default path of the usage ledger,
+// ~/.cgpt/usage.jsonl.
+func DefaultLedgerPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	return filepath.Join(home, ".cgpt", "usage.jsonl"), nil
+}
+
+// UsageLedgerPath returns the path of the usage ledger, or "" if it is
+// disabled.
+func (cfg *Config) UsageLedgerPath() (string, error) {
+	switch cfg.UsageLedger {
+	case "off":
+		return "", nil
+	case "":
+		return DefaultLedgerPath()
+	}
+	return expandTilde(cfg.UsageLedger), nil
+}
+
+// AppendLedger appends an entry to the ledger at path, creating it if
+// needed.
+func AppendLedger(path string, e LedgerEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	// Write each entry with a single call so concurrent runs do not
+	// interleave lines.
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadLedger reads the entries of the ledger at path. A missing ledger has
+// no entries. Lines that cannot be parsed, such as one cut short by a
+// crash, are skipped.
+func ReadLedger(path string) ([]LedgerEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []LedgerEntry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var e LedgerEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// UsageSummary is the usage of a group of ledger entries.
+type UsageSummary struct {
+	Key      string `json:"key"`
+	Requests int    `json:"requests"`
+	Usage
+}
+
+// usageGroupings maps the ways usage can be summarized to the key of an
+// entry.
+var usageGroupings = map[string]func(LedgerEntry) string{
+	"day":     func(e LedgerEntry) string { return e.Time.Local().Format(time.DateOnly) },
+	"model":   func(e LedgerEntry) string { return e.Backend + "/" + e.Model },
+	"dir":     func(e LedgerEntry) string { return e.Dir },
+	"profile": func(e LedgerEntry) string { return e.Profile },
+}
+
+// SummarizeUsage totals entries by "day", "model", "dir" or "profile",
+// sorted by key.
+func SummarizeUsage(entries []LedgerEntry, by string) ([]UsageSummary, error) {
+	key, ok := usageGroupings[by]
+	if !ok {
+		return nil, fmt.Errorf("cannot summarize usage by %q: want day, model, dir or profile", by)
+	}
+	groups := make(map[string]*UsageSummary)
+	for _, e := range entries {
+		k := key(e)
+		g, ok := groups[k]
+		if !ok {
+			g = &UsageSummary{Key: k}
+			groups[k] = g
+		}
+		g.Requests++
+		g.Add(e.Usage)
+	}
+	summaries := make([]UsageSummary, 0, len(groups))
+	for _, g := range groups {
+		summaries = append(summaries, *g)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Key < summaries[j].Key })
+	return summaries, nil
+}
+
+// appendLedger records a completion in the usage ledger, if enabled.
+func (s *CompletionService) appendLedger(e LedgerEntry) {
+	if s.usageLedger == "" {
+		return
+	}
+	e.Time = time.Now()
+	e.Profile = s.cfg.Profile
+	e.Dir, _ = os.Getwd()
+	e.History = s.historyPath()
+	if err := AppendLedger(s.usageLedger, e); err != nil {
+		s.noticef("failed to record usage: %v", err)
+	}
+}
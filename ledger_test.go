@@ -0,0 +1,132 @@
+package cgpt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLedger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cgpt", "usage.jsonl")
+	day1 := time.Date(2025, 3, 1, 12, 0, 0, 0, time.Local)
+	day2 := day1.AddDate(0, 0, 1)
+	entries := []LedgerEntry{
+		{Time: day1, Backend: "anthropic", Model: "claude", Dir: "/src/a", Profile: "work", Usage: Usage{InputTokens: 100, OutputTokens: 10, Cost: 0.5}},
+		{Time: day1, Backend: "openai", Model: "gpt-4o", Dir: "/src/b", Usage: Usage{InputTokens: 200, OutputTokens: 20, Cost: 1}},
+		{Time: day2, Backend: "anthropic", Model: "claude", Dir: "/src/a", Profile: "work", Usage: Usage{InputTokens: 300, CacheReadTokens: 30, Cost: 2}},
+	}
+	for _, e := range entries[:2] {
+		if err := AppendLedger(path, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A line cut short by a crash is skipped.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time":"2025-03-`)
+	f.WriteString("\n")
+	f.Close()
+	if err := AppendLedger(path, entries[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(entries, got, cmp.Comparer(func(a, b time.Time) bool { return a.Equal(b) })); diff != "" {
+		t.Fatalf("ReadLedger mismatch (-want +got):\n%s", diff)
+	}
+
+	tests := []struct {
+		by   string
+		want []UsageSummary
+	}{
+		{"day", []UsageSummary{
+			{Key: "2025-03-01", Requests: 2, Usage: Usage{InputTokens: 300, OutputTokens: 30, Cost: 1.5}},
+			{Key: "2025-03-02", Requests: 1, Usage: Usage{InputTokens: 300, CacheReadTokens: 30, Cost: 2}},
+		}},
+		{"model", []UsageSummary{
+			{Key: "anthropic/claude", Requests: 2, Usage: Usage{InputTokens: 400, OutputTokens: 10, CacheReadTokens: 30, Cost: 2.5}},
+			{Key: "openai/gpt-4o", Requests: 1, Usage: Usage{InputTokens: 200, OutputTokens: 20, Cost: 1}},
+		}},
+		{"dir", []UsageSummary{
+			{Key: "/src/a", Requests: 2, Usage: Usage{InputTokens: 400, OutputTokens: 10, CacheReadTokens: 30, Cost: 2.5}},
+			{Key: "/src/b", Requests: 1, Usage: Usage{InputTokens: 200, OutputTokens: 20, Cost: 1}},
+		}},
+		{"profile", []UsageSummary{
+			{Key: "", Requests: 1, Usage: Usage{InputTokens: 200, OutputTokens: 20, Cost: 1}},
+			{Key: "work", Requests: 2, Usage: Usage{InputTokens: 400, OutputTokens: 10, CacheReadTokens: 30, Cost: 2.5}},
+		}},
+	}
+	for _, tt := range tests {
+		got, err := SummarizeUsage(entries, tt.by)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("SummarizeUsage(%q) mismatch (-want +got):\n%s", tt.by, diff)
+		}
+	}
+	if _, err := SummarizeUsage(entries, "week"); err == nil {
+		t.Error("SummarizeUsage by week succeeded, want an error")
+	}
+
+	if got, err := ReadLedger(filepath.Join(t.TempDir(), "missing.jsonl")); err != nil || got != nil {
+		t.Errorf("ReadLedger of a missing file = %v, %v, want no entries", got, err)
+	}
+}
+
+func TestCompletionLedger(t *testing.T) {
+	dir := t.TempDir()
+	ledger := filepath.Join(dir, "usage.jsonl")
+	cfg := &Config{Backend: "dummy", Model: "dummy", Profile: "work", UsageLedger: ledger}
+	model := &stubModel{chunks: []string{"hello"}, info: map[string]any{"InputTokens": 10, "OutputTokens": 2}}
+	s, err := NewCompletionService(cfg, model, WithStderr(&bytes.Buffer{}), WithStdout(&bytes.Buffer{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	historyOut := filepath.Join(dir, "history.yaml")
+	if err := s.Run(context.Background(), RunOptions{Config: cfg, InputStrings: []string{"hi"}, HistoryOut: historyOut, Stdout: &bytes.Buffer{}}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ReadLedger(ledger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d ledger entries, want 1", len(entries))
+	}
+	e := entries[0]
+	wd, _ := os.Getwd()
+	if e.Backend != "dummy" || e.Model != "dummy" || e.Profile != "work" || e.Dir != wd || e.History != historyOut || e.InputTokens != 10 || e.OutputTokens != 2 {
+		t.Errorf("unexpected ledger entry: %+v", e)
+	}
+	if time.Since(e.Time) > time.Minute {
+		t.Errorf("ledger entry time = %v, want about now", e.Time)
+	}
+}
+
+func TestCompletionLedgerSkipsUnreportedUsage(t *testing.T) {
+	ledger := filepath.Join(t.TempDir(), "usage.jsonl")
+	cfg := &Config{Backend: "dummy", Model: "dummy", UsageLedger: ledger}
+	s, err := NewCompletionService(cfg, &stubModel{chunks: []string{"hello"}}, WithStderr(&bytes.Buffer{}), WithStdout(&bytes.Buffer{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(context.Background(), RunOptions{Config: cfg, InputStrings: []string{"hi"}, DisableHistory: true, Stdout: &bytes.Buffer{}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(ledger); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ledger written for a completion without usage: %v", err)
+	}
+}
@@ -0,0 +1,375 @@
+package cgpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ModelLister lists the IDs of the models a backend serves.
+type ModelLister func(ctx context.Context, cfg *Config, client *http.Client) ([]string, error)
+
+// errBackendNotConfigured is returned by a ModelLister when the backend has
+// no credentials or endpoint configured.
+var errBackendNotConfigured = errors.New("backend is not configured")
+
+// ModelInfo describes a model available from a backend.
+type ModelInfo struct {
+	Backend      string            `json:"backend"`
+	ID           string            `json:"id"`
+	Default      bool              `json:"default,omitempty"`
+	Aliases      []string          `json:"aliases,omitempty"`
+	Capabilities ModelCapabilities `json:"capabilities"`
+}
+
+// ListModelsOptions controls ListModels.
+type ListModelsOptions struct {
+	// Backends to list. If empty, all backends that support listing are
+	// queried, and those without credentials are skipped.
+	Backends []string
+	// HTTPClient is used for requests, if set.
+	HTTPClient *http.Client
+
+	// CacheFile stores results between runs. Caching is disabled if empty.
+	CacheFile string
+	// TTL is how long cached results are used before querying again.
+	TTL time.Duration
+	// Refresh ignores cached results.
+	Refresh bool
+	// Offline only uses cached results, however old.
+	Offline bool
+}
+
+// DefaultModelsCacheTTL is the default time cached model lists are used for.
+const DefaultModelsCacheTTL = 24 * time.Hour
+
+// DefaultModelsCacheFile returns the default model list cache location.
+func DefaultModelsCacheFile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	return filepath.Join(home, ".cgpt", "models.json"), nil
+}
+
+// modelsCacheEntry is the cached model list of a backend.
+type modelsCacheEntry struct {
+	FetchedAt time.Time `json:"fetchedAt"`
+	Models    []string  `json:"models"`
+}
+
+// ListModels returns the models available from the configured backends,
+// merged with their capabilities. If some backends fail, the models of the
+// others are returned along with an error describing the failures. Stale
+// cached results are used for backends that fail.
+func ListModels(ctx context.Context, cfg *Config, opts ListModelsOptions) ([]ModelInfo, error) {
+	explicit := len(opts.Backends) > 0
+	names := opts.Backends
+	if !explicit {
+		for _, name := range cfg.BackendNames() {
+			// The dummy backend is for testing, and only listed on request.
+			if name != "dummy" || cfg.Backend == "dummy" {
+				names = append(names, name)
+			}
+		}
+	}
+	client := opts.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	cache := map[string]modelsCacheEntry{}
+	if opts.CacheFile != "" {
+		if b, err := os.ReadFile(opts.CacheFile); err == nil {
+			// A corrupt cache is ignored and rewritten.
+			json.Unmarshal(b, &cache)
+		}
+	}
+
+	type result struct {
+		models  []string
+		fetched bool
+		err     error
+	}
+	results := make([]result, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		b, ok := cfg.lookupBackend(name)
+		if !ok {
+			results[i].err = fmt.Errorf("unknown backend %q (available: %s)", name, strings.Join(cfg.BackendNames(), ", "))
+			continue
+		}
+		if b.ListModels == nil {
+			if explicit {
+				results[i].err = fmt.Errorf("%s: listing models is not supported", name)
+			}
+			continue
+		}
+		cached, hasCache := cache[name]
+		if hasCache && !opts.Refresh && (opts.Offline || time.Since(cached.FetchedAt) < opts.TTL) {
+			results[i].models = cached.Models
+			continue
+		}
+		if opts.Offline {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+			defer cancel()
+			models, err := b.ListModels(ctx, cfg, client)
+			switch {
+			case errors.Is(err, errBackendNotConfigured) && !explicit:
+			case err != nil:
+				results[i].err = fmt.Errorf("%s: %w", name, err)
+				if hasCache {
+					results[i].models = cached.Models
+				}
+			default:
+				results[i] = result{models: models, fetched: true}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var (
+		models  []ModelInfo
+		errs    []error
+		updated bool
+	)
+	for i, r := range results {
+		if r.err != nil {
+			errs = append(errs, r.err)
+		}
+		if r.fetched {
+			cache[names[i]] = modelsCacheEntry{FetchedAt: time.Now(), Models: r.models}
+			updated = true
+		}
+		for _, id := range r.models {
+			models = append(models, cfg.modelInfo(names[i], id))
+		}
+	}
+	sort.SliceStable(models, func(i, j int) bool {
+		if models[i].Backend != models[j].Backend {
+			return models[i].Backend < models[j].Backend
+		}
+		return models[i].ID < models[j].ID
+	})
+
+	if updated && opts.CacheFile != "" {
+		if err := writeModelsCache(opts.CacheFile, cache); err != nil {
+			errs = append(errs, fmt.Errorf("failed to write models cache: %w", err))
+		}
+	}
+	return models, errors.Join(errs...)
+}
+
+// modelInfo describes a model, with its capabilities and aliases.
+func (cfg *Config) modelInfo(backend, id string) ModelInfo {
+	info := ModelInfo{
+		Backend:      backend,
+		ID:           id,
+		Capabilities: cfg.modelCapabilities(backend, id),
+	}
+	if b, ok := cfg.lookupBackend(backend); ok {
+		info.Default = b.DefaultModel == id
+		for alias, model := range b.ModelAliases {
+			if model == id {
+				info.Aliases = append(info.Aliases, alias)
+			}
+		}
+	}
+	for alias, model := range cfg.ModelAliases {
+		if model == id && !slices.Contains(info.Aliases, alias) {
+			info.Aliases = append(info.Aliases, alias)
+		}
+	}
+	sort.Strings(info.Aliases)
+	return info
+}
+
+func writeModelsCache(path string, cache map[string]modelsCacheEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// getJSON performs a GET request and decodes the JSON response into out.
+func getJSON(ctx context.Context, client *http.Client, u string, headers map[string]string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("API returned unexpected status code: %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// listOpenAICompatibleModels lists models from an OpenAI-compatible /models endpoint.
+func listOpenAICompatibleModels(ctx context.Context, client *http.Client, baseURL string, headers map[string]string) ([]string, error) {
+	var resp struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := getJSON(ctx, client, strings.TrimSuffix(baseURL, "/")+"/models", headers, &resp); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(resp.Data))
+	for _, m := range resp.Data {
+		ids = append(ids, m.ID)
+	}
+	return ids, nil
+}
+
+func listOpenAIModels(ctx context.Context, cfg *Config, client *http.Client) ([]string, error) {
+	if cfg.OpenAIAPIKey == "" {
+		return nil, errBackendNotConfigured
+	}
+	baseURL := os.Getenv("OPENAI_BASE_URL")
+	if baseURL == "" {
+		baseURL = "https://api.openai.com/v1"
+	}
+	return listOpenAICompatibleModels(ctx, client, baseURL, map[string]string{"Authorization": "Bearer " + cfg.OpenAIAPIKey})
+}
+
+func listAnthropicModels(ctx context.Context, cfg *Config, client *http.Client) ([]string, error) {
+	if cfg.AnthropicAPIKey == "" {
+		return nil, errBackendNotConfigured
+	}
+	headers := map[string]string{
+		"x-api-key":         cfg.AnthropicAPIKey,
+		"anthropic-version": "2023-06-01",
+	}
+	var ids []string
+	after := ""
+	for {
+		u := "https://api.anthropic.com/v1/models?limit=1000"
+		if after != "" {
+			u += "&after_id=" + url.QueryEscape(after)
+		}
+		var resp struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+			HasMore bool   `json:"has_more"`
+			LastID  string `json:"last_id"`
+		}
+		if err := getJSON(ctx, client, u, headers, &resp); err != nil {
+			return nil, err
+		}
+		for _, m := range resp.Data {
+			ids = append(ids, m.ID)
+		}
+		if !resp.HasMore || resp.LastID == "" {
+			return ids, nil
+		}
+		after = resp.LastID
+	}
+}
+
+func listOllamaModels(ctx context.Context, cfg *Config, client *http.Client) ([]string, error) {
+	var resp struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := getJSON(ctx, client, ollamaBaseURL()+"/api/tags", nil, &resp); err != nil {
+		// Ollama needs no credentials, so a local server that is not
+		// running is how it goes unconfigured, unless it is in use.
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && os.Getenv("OLLAMA_HOST") == "" && cfg.Backend != "ollama" {
+			return nil, errBackendNotConfigured
+		}
+		return nil, err
+	}
+	ids := make([]string, 0, len(resp.Models))
+	for _, m := range resp.Models {
+		ids = append(ids, m.Name)
+	}
+	return ids, nil
+}
+
+// ollamaBaseURL returns the Ollama server URL, following the same OLLAMA_HOST
+// conventions as the ollama client.
+func ollamaBaseURL() string {
+	host := os.Getenv("OLLAMA_HOST")
+	scheme, hostport, ok := strings.Cut(host, "://")
+	if !ok {
+		scheme, hostport = "http", host
+	}
+	h, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		h, port = "127.0.0.1", "11434"
+		if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+			h = ip.String()
+		}
+	}
+	return scheme + "://" + net.JoinHostPort(h, port)
+}
+
+func listGoogleAIModels(ctx context.Context, cfg *Config, client *http.Client) ([]string, error) {
+	if cfg.GoogleAPIKey == "" {
+		return nil, errBackendNotConfigured
+	}
+	headers := map[string]string{"x-goog-api-key": cfg.GoogleAPIKey}
+	var ids []string
+	pageToken := ""
+	for {
+		u := "https://generativelanguage.googleapis.com/v1beta/models?pageSize=1000"
+		if pageToken != "" {
+			u += "&pageToken=" + url.QueryEscape(pageToken)
+		}
+		var resp struct {
+			Models []struct {
+				Name                       string   `json:"name"`
+				SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+			} `json:"models"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := getJSON(ctx, client, u, headers, &resp); err != nil {
+			return nil, err
+		}
+		for _, m := range resp.Models {
+			if slices.Contains(m.SupportedGenerationMethods, "generateContent") {
+				ids = append(ids, strings.TrimPrefix(m.Name, "models/"))
+			}
+		}
+		if resp.NextPageToken == "" {
+			return ids, nil
+		}
+		pageToken = resp.NextPageToken
+	}
+}
@@ -0,0 +1,170 @@
+package cgpt
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestListModels(t *testing.T) {
+	var requests atomic.Int32
+	var failing atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var resp any
+		switch r.URL.Path {
+		case "/v1/models":
+			if r.Header.Get("x-api-key") == "anthropic-key" {
+				resp = map[string]any{"data": []map[string]string{{"id": "claude-3-7-sonnet-20250219"}, {"id": "claude-3-5-haiku-latest"}}}
+			} else if r.Header.Get("Authorization") == "Bearer openai-key" {
+				resp = map[string]any{"data": []map[string]string{{"id": "gpt-4o"}, {"id": "o1-mini"}}}
+			} else {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		case "/api/tags":
+			resp = map[string]any{"models": []map[string]string{{"name": "llama3.2:latest"}}}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+	t.Setenv("OPENAI_BASE_URL", "")
+
+	cfg := &Config{
+		Backend:         "anthropic",
+		OpenAIAPIKey:    "openai-key",
+		AnthropicAPIKey: "anthropic-key",
+		ModelAliases:    map[string]string{"local": "llama3.2:latest"},
+	}
+	opts := ListModelsOptions{
+		Backends:   []string{"anthropic", "openai", "ollama"},
+		HTTPClient: newRedirectClient(t, srv),
+		CacheFile:  filepath.Join(t.TempDir(), "models.json"),
+		TTL:        DefaultModelsCacheTTL,
+	}
+	ids := func(models []ModelInfo) string {
+		var s []string
+		for _, m := range models {
+			s = append(s, m.Backend+"/"+m.ID)
+		}
+		return strings.Join(s, " ")
+	}
+	const want = "anthropic/claude-3-5-haiku-latest anthropic/claude-3-7-sonnet-20250219 ollama/llama3.2:latest openai/gpt-4o openai/o1-mini"
+
+	models, err := ListModels(context.Background(), cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(models); got != want {
+		t.Errorf("models = %s, want %s", got, want)
+	}
+	for _, m := range models {
+		switch m.ID {
+		case "claude-3-7-sonnet-20250219":
+			if !m.Default || strings.Join(m.Aliases, ",") != "smart" || m.Capabilities.MaxOutputTokens != 64000 {
+				t.Errorf("%s = %+v", m.ID, m)
+			}
+		case "o1-mini":
+			if m.Capabilities.Temperature || m.Capabilities.Streaming {
+				t.Errorf("%s capabilities = %+v", m.ID, m.Capabilities)
+			}
+		case "llama3.2:latest":
+			if strings.Join(m.Aliases, ",") != "local" {
+				t.Errorf("%s aliases = %v", m.ID, m.Aliases)
+			}
+		}
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("requests = %d, want 3", n)
+	}
+
+	// Cached results are used within the TTL, and when offline.
+	failing.Store(true)
+	for _, o := range []ListModelsOptions{opts, {Backends: opts.Backends, CacheFile: opts.CacheFile, Offline: true}} {
+		models, err = ListModels(context.Background(), cfg, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(models); got != want {
+			t.Errorf("cached models = %s, want %s", got, want)
+		}
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("requests = %d, want 3 (cached)", n)
+	}
+
+	// Stale results are used when a refresh fails.
+	opts.Refresh = true
+	models, err = ListModels(context.Background(), cfg, opts)
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("ListModels() error = %v, want status 503", err)
+	}
+	if got := ids(models); got != want {
+		t.Errorf("stale models = %s, want %s", got, want)
+	}
+}
+
+func TestListModelsSkipsUnconfiguredBackends(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			t.Errorf("unexpected request to %s", r.URL)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"models": []map[string]string{{"name": "llama3.2:latest"}}})
+	}))
+	defer srv.Close()
+
+	cfg := &Config{Backend: "ollama"}
+	models, err := ListModels(context.Background(), cfg, ListModelsOptions{HTTPClient: newRedirectClient(t, srv)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 1 || models[0].ID != "llama3.2:latest" {
+		t.Errorf("models = %+v, want only llama3.2:latest", models)
+	}
+
+	if _, err := ListModels(context.Background(), cfg, ListModelsOptions{Backends: []string{"openai"}, HTTPClient: newRedirectClient(t, srv)}); err == nil {
+		t.Error("ListModels(openai) without an API key succeeded, want error")
+	}
+}
+
+func TestListModelsSkipsUnreachableOllama(t *testing.T) {
+	t.Setenv("OLLAMA_HOST", "")
+	srv := httptest.NewServer(http.NotFoundHandler())
+	client := newRedirectClient(t, srv)
+	srv.Close()
+
+	tests := []struct {
+		name     string
+		backend  string
+		backends []string
+		wantErr  bool
+	}{
+		{name: "not in use", backend: "anthropic"},
+		{name: "configured backend", backend: "ollama", wantErr: true},
+		{name: "named", backend: "anthropic", backends: []string{"ollama"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Backend: tt.backend}
+			models, err := ListModels(context.Background(), cfg, ListModelsOptions{Backends: tt.backends, HTTPClient: client})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ListModels() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(models) != 0 {
+				t.Errorf("models = %+v, want none", models)
+			}
+		})
+	}
+}
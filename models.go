@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/llms/anthropic"
@@ -14,91 +16,284 @@ import (
 )
 
 // InferenceProviderOption is a function that modifies the model options
-type InferenceProviderOption func(*inferenceProviderOptions)
+type InferenceProviderOption func(*InferenceProviderOptions)
 
-type inferenceProviderOptions struct {
-	httpClient *http.Client
+// InferenceProviderOptions holds the options passed to a backend constructor.
+type InferenceProviderOptions struct {
+	// HTTPClient is the HTTP client the backend should use, if set.
+	HTTPClient *http.Client
 
-	openaiCompatUseLegacyMaxTokens bool
+	// OpenAICompatUseLegacyMaxTokens requests 'max_tokens' rather than
+	// 'max_completion_tokens' for OpenAI compatible backends.
+	OpenAICompatUseLegacyMaxTokens bool
 }
 
 // WithHTTPClient sets a custom HTTP client for the model
 func WithHTTPClient(client *http.Client) InferenceProviderOption {
-	return func(mo *inferenceProviderOptions) {
-		mo.httpClient = client
+	return func(mo *InferenceProviderOptions) {
+		mo.HTTPClient = client
 	}
 }
 
 // WithUseLegacyMaxTokens sets whether to use legacy max tokens behavior for OpenAI compatibility
 func WithUseLegacyMaxTokens(useLegacy bool) InferenceProviderOption {
-	return func(mo *inferenceProviderOptions) {
-		mo.openaiCompatUseLegacyMaxTokens = useLegacy
+	return func(mo *InferenceProviderOptions) {
+		mo.OpenAICompatUseLegacyMaxTokens = useLegacy
 	}
 }
 
 // InitializeModel initializes the model with the given configuration and options.
 func InitializeModel(cfg *Config, opts ...InferenceProviderOption) (llms.Model, error) {
-	mo := &inferenceProviderOptions{}
+	mo := &InferenceProviderOptions{}
 	for _, opt := range opts {
 		opt(mo)
 	}
 
-	constructor, ok := modelConstructors[cfg.Backend]
-	if !ok {
-		return nil, fmt.Errorf("unknown backend %q", cfg.Backend)
+	var (
+		m   llms.Model
+		err error
+	)
+	if len(cfg.Fallbacks) > 0 {
+		m, err = newFallbackModel(cfg, mo)
+	} else {
+		b, ok := cfg.lookupBackend(cfg.Backend)
+		if !ok {
+			return nil, fmt.Errorf("unknown backend %q (available: %s)", cfg.Backend, strings.Join(cfg.BackendNames(), ", "))
+		}
+		m, err = newBackendModel(b, cfg, mo)
+	}
+	if err != nil {
+		return nil, err
 	}
+	return cfg.withResponseCache(m)
+}
 
-	return constructor(cfg, mo)
+// newBackendModel constructs a model for b, wrapped with the rate limit and
+// retry policy configured for the backend. Each retry waits for the rate limiter,
+// and is subject to any injected faults.
+func newBackendModel(b Backend, cfg *Config, mo *InferenceProviderOptions) (llms.Model, error) {
+	policy := cfg.retryPolicy(b.Name)
+	var hints *retryHintTransport
+	if policy.MaxAttempts > 1 {
+		mo, hints = withRetryHints(mo)
+	}
+	m, err := b.New(cfg, mo)
+	if err != nil {
+		return nil, err
+	}
+	if faults := cfg.faults(b.Name); faults.enabled() {
+		m = NewFaultModel(m, faults)
+	}
+	if limit, key, ok := cfg.rateLimit(b.Name, cfg.Model); ok {
+		dir, err := defaultRateLimitDir()
+		if err != nil {
+			return nil, err
+		}
+		m = &RateLimitedModel{Model: m, Limiter: &RateLimiter{Key: key, Limit: limit, Dir: dir}}
+	}
+	if hints != nil {
+		m = &RetryModel{Model: m, Policy: policy, RetryAfter: hints.take}
+	}
+	return m, nil
 }
 
-type modelConstructor func(*Config, *inferenceProviderOptions) (llms.Model, error)
+// BackendConstructor creates a model for a backend from the given configuration.
+type BackendConstructor func(*Config, *InferenceProviderOptions) (llms.Model, error)
 
-var modelConstructors = map[string]modelConstructor{
-	"openai": func(cfg *Config, mo *inferenceProviderOptions) (llms.Model, error) {
-		options := []openai.Option{openai.WithModel(cfg.Model)}
-		if cfg.OpenAIAPIKey != "" {
-			options = append(options, openai.WithToken(cfg.OpenAIAPIKey))
-		}
-		if mo.httpClient != nil {
-			options = append(options, openai.WithHTTPClient(mo.httpClient))
-		}
-		if mo.openaiCompatUseLegacyMaxTokens {
-			options = append(options, openai.WithUseLegacyMaxTokens(true))
-		}
+// BackendCapabilities describes the features a backend supports.
+type BackendCapabilities struct {
+	Streaming    bool `json:"streaming"`
+	SystemPrompt bool `json:"systemPrompt"`
+	Prefill      bool `json:"prefill"`
+	Embeddings   bool `json:"embeddings"`
+}
 
-		return openai.New(options...)
-	},
-	"anthropic": func(cfg *Config, mo *inferenceProviderOptions) (llms.Model, error) {
-		options := []anthropic.Option{anthropic.WithModel(cfg.Model)}
-		if cfg.AnthropicAPIKey != "" {
-			options = append(options, anthropic.WithToken(cfg.AnthropicAPIKey))
-		}
-		if strings.Contains(cfg.Model, "sonnet") {
-			options = append(options, anthropic.WithAnthropicBetaHeader(anthropic.MaxTokensAnthropicSonnet35))
-		}
-		if mo.httpClient != nil {
-			options = append(options, anthropic.WithHTTPClient(mo.httpClient))
-		}
-		return anthropic.New(options...)
-	},
-	"ollama": func(cfg *Config, mo *inferenceProviderOptions) (llms.Model, error) {
-		options := []ollama.Option{ollama.WithModel(cfg.Model)}
-		if mo.httpClient != nil {
-			options = append(options, ollama.WithHTTPClient(mo.httpClient))
-		}
-		return ollama.New(options...)
-	},
-	"googleai": func(cfg *Config, mo *inferenceProviderOptions) (llms.Model, error) {
-		options := []googleai.Option{googleai.WithDefaultModel(cfg.Model)}
+// Backend describes an inference backend that can be selected with --backend.
+type Backend struct {
+	// Name is the name used to select the backend.
+	Name string
+	// DefaultModel is the model used when none is configured.
+	DefaultModel string
+	// DefaultEmbeddingModel is the embedding model used when none is
+	// configured.
+	DefaultEmbeddingModel string
+	// ModelAliases maps short names, such as "fast" and "smart", to models.
+	ModelAliases map[string]string
+	// New constructs the model.
+	New BackendConstructor
+	// ListModels lists the models the backend serves, if supported.
+	ListModels ModelLister
+	// Capabilities describes what the backend supports.
+	Capabilities BackendCapabilities
+	// CountTokens counts prompt tokens for the backend's models. If nil,
+	// tiktoken's cl100k_base encoding is used.
+	CountTokens TokenCounter
+}
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]Backend{}
+)
+
+// RegisterBackend makes a backend available by the provided name.
+// Packages providing a backend typically call it from an init function so
+// that a blank import is enough to make the backend selectable.
+// It panics if the name is empty, the constructor is nil, or a backend with
+// the same name is already registered.
+func RegisterBackend(b Backend) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if b.Name == "" {
+		panic("cgpt: RegisterBackend with empty name")
+	}
+	if b.New == nil {
+		panic("cgpt: RegisterBackend constructor is nil for " + b.Name)
+	}
+	if _, dup := backends[b.Name]; dup {
+		panic("cgpt: RegisterBackend called twice for backend " + b.Name)
+	}
+	backends[b.Name] = b
+}
+
+// LookupBackend returns the backend registered under name.
+func LookupBackend(name string) (Backend, bool) {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	b, ok := backends[name]
+	return b, ok
+}
+
+// BackendNames returns the sorted names of the registered backends.
+func BackendNames() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// defaultModel returns the default model for the named backend.
+func defaultModel(backend string) (string, bool) {
+	b, ok := LookupBackend(backend)
+	if !ok || b.DefaultModel == "" {
+		return "", false
+	}
+	return b.DefaultModel, true
+}
+
+func init() {
+	RegisterBackend(Backend{
+		Name:                  "openai",
+		DefaultModel:          "gpt-4o",
+		DefaultEmbeddingModel: "text-embedding-3-small",
+		ModelAliases:          map[string]string{"fast": "gpt-4o-mini", "smart": "gpt-4o", "reasoning": "o3-mini"},
+		New:                   newOpenAIModel,
+		ListModels:            listOpenAIModels,
+		Capabilities:          BackendCapabilities{Streaming: true, SystemPrompt: true, Embeddings: true},
+	})
+	RegisterBackend(Backend{
+		Name:         "anthropic",
+		DefaultModel: "claude-3-7-sonnet-20250219",
+		ModelAliases: map[string]string{"fast": "claude-3-5-haiku-latest", "smart": "claude-3-7-sonnet-20250219"},
+		New:          newAnthropicModel,
+		ListModels:   listAnthropicModels,
+		Capabilities: BackendCapabilities{Streaming: true, SystemPrompt: true, Prefill: true},
+		CountTokens:  countAnthropicTokens,
+	})
+	RegisterBackend(Backend{
+		Name:                  "ollama",
+		DefaultModel:          "llama3.2",
+		DefaultEmbeddingModel: "nomic-embed-text",
+		New:                   newOllamaModel,
+		ListModels:            listOllamaModels,
+		Capabilities:          BackendCapabilities{Streaming: true, SystemPrompt: true, Prefill: true, Embeddings: true},
+	})
+	RegisterBackend(Backend{
+		Name:                  "googleai",
+		DefaultModel:          "gemini-pro",
+		DefaultEmbeddingModel: "text-embedding-004",
+		ModelAliases:          map[string]string{"fast": "gemini-1.5-flash", "smart": "gemini-1.5-pro"},
+		New:                   newGoogleAIModel,
+		ListModels:            listGoogleAIModels,
+		Capabilities:          BackendCapabilities{Streaming: true, SystemPrompt: true, Embeddings: true},
+		CountTokens:           countEstimatedTokens,
+	})
+	RegisterBackend(Backend{
+		Name:                  "dummy",
+		DefaultModel:          "dummy",
+		DefaultEmbeddingModel: "dummy",
+		New: func(cfg *Config, mo *InferenceProviderOptions) (llms.Model, error) {
+			if cfg.DummyScript != "" {
+				return NewScriptedDummyBackend(cfg.DummyScript)
+			}
+			return NewDummyBackend()
+		},
+		ListModels: func(ctx context.Context, cfg *Config, client *http.Client) ([]string, error) {
+			return []string{"dummy"}, nil
+		},
+		Capabilities: BackendCapabilities{Streaming: true, SystemPrompt: true, Prefill: true, Embeddings: true},
+		CountTokens:  countEstimatedTokens,
+	})
+}
+
+func newOpenAIModel(cfg *Config, mo *InferenceProviderOptions) (llms.Model, error) {
+	// The model doubles as the embedding model when constructed for
+	// embeddings.
+	options := []openai.Option{openai.WithModel(cfg.Model), openai.WithEmbeddingModel(cfg.Model)}
+	if cfg.OpenAIAPIKey != "" {
+		options = append(options, openai.WithToken(cfg.OpenAIAPIKey))
+	}
+	if client := withRequestFields(mo.HTTPClient, cfg.openAIRequestFields()); client != nil {
+		options = append(options, openai.WithHTTPClient(client))
+	}
+	if mo.OpenAICompatUseLegacyMaxTokens {
+		options = append(options, openai.WithUseLegacyMaxTokens(true))
+	}
+
+	return openai.New(options...)
+}
+
+func newAnthropicModel(cfg *Config, mo *InferenceProviderOptions) (llms.Model, error) {
+	options := []anthropic.Option{anthropic.WithModel(cfg.Model)}
+	if cfg.AnthropicAPIKey != "" {
+		options = append(options, anthropic.WithToken(cfg.AnthropicAPIKey))
+	}
+	if strings.Contains(cfg.Model, "sonnet") {
+		options = append(options, anthropic.WithAnthropicBetaHeader(anthropic.MaxTokensAnthropicSonnet35))
+	}
+	client := mo.HTTPClient
+	if cfg.modelCapabilities(cfg.Backend, cfg.Model).Thinking {
+		client = withAnthropicThinking(client, cfg.thinkingBudget())
+	}
+	if client != nil {
+		options = append(options, anthropic.WithHTTPClient(client))
+	}
+	return anthropic.New(options...)
+}
+
+func newOllamaModel(cfg *Config, mo *InferenceProviderOptions) (llms.Model, error) {
+	options := []ollama.Option{ollama.WithModel(cfg.Model)}
+	if mo.HTTPClient != nil {
+		options = append(options, ollama.WithHTTPClient(mo.HTTPClient))
+	}
+	return ollama.New(options...)
+}
+
+func newGoogleAIModel(cfg *Config, mo *InferenceProviderOptions) (llms.Model, error) {
+	options := []googleai.Option{googleai.WithDefaultModel(cfg.Model), googleai.WithDefaultEmbeddingModel(cfg.Model)}
+	if cfg.GoogleAPIKey != "" {
+		options = append(options, googleai.WithAPIKey(cfg.GoogleAPIKey))
+	}
+	if mo.HTTPClient != nil {
+		client := mo.HTTPClient
 		if cfg.GoogleAPIKey != "" {
-			options = append(options, googleai.WithAPIKey(cfg.GoogleAPIKey))
-		}
-		if mo.httpClient != nil {
-			options = append(options, googleai.WithHTTPClient(mo.httpClient))
+			// A custom HTTP client replaces API key authentication, so send the key ourselves.
+			client = withHeaders(client, map[string]string{"x-goog-api-key": cfg.GoogleAPIKey})
 		}
-		return googleai.New(context.TODO(), options...)
-	},
-	"dummy": func(cfg *Config, mo *inferenceProviderOptions) (llms.Model, error) {
-		return NewDummyBackend()
-	},
+		options = append(options, googleai.WithHTTPClient(client))
+	}
+	return googleai.New(context.TODO(), options...)
 }
@@ -0,0 +1,46 @@
+package cgpt
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestRegisterBackend(t *testing.T) {
+	var gotCfg *Config
+	RegisterBackend(Backend{
+		Name:         "test-registry",
+		DefaultModel: "test-model",
+		New: func(cfg *Config, mo *InferenceProviderOptions) (llms.Model, error) {
+			gotCfg = cfg
+			return NewDummyBackend()
+		},
+	})
+
+	if !slices.Contains(BackendNames(), "test-registry") {
+		t.Fatalf("BackendNames() = %v, want to contain %q", BackendNames(), "test-registry")
+	}
+	if m, ok := defaultModel("test-registry"); !ok || m != "test-model" {
+		t.Errorf("defaultModel() = %q, %v, want %q, true", m, ok, "test-model")
+	}
+
+	cfg := &Config{Backend: "test-registry", Model: "test-model"}
+	if _, err := InitializeModel(cfg); err != nil {
+		t.Fatalf("InitializeModel: %v", err)
+	}
+	if gotCfg != cfg {
+		t.Errorf("constructor was not called with the config")
+	}
+
+	if _, err := InitializeModel(&Config{Backend: "no-such-backend"}); err == nil {
+		t.Errorf("InitializeModel with unknown backend: want error")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("duplicate RegisterBackend: want panic")
+		}
+	}()
+	RegisterBackend(Backend{Name: "test-registry", New: func(*Config, *InferenceProviderOptions) (llms.Model, error) { return nil, nil }})
+}
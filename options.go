@@ -23,6 +23,14 @@ type RunOptions struct {
 	StreamOutput bool `json:"streamOutput,omitempty" yaml:"streamOutput,omitempty"`
 	ShowSpinner  bool `json:"showSpinner,omitempty" yaml:"showSpinner,omitempty"`
 	EchoPrefill  bool `json:"echoPrefill,omitempty" yaml:"echoPrefill,omitempty"`
+	// ThinkingOutput is a file model thinking is appended to, instead of
+	// being written dimmed to stderr.
+	ThinkingOutput string `json:"thinkingOutput,omitempty" yaml:"thinkingOutput,omitempty"`
+	// CompletionsDelimiter separates completions in the output when
+	// NCompletions is more than one, and CompletionsFormat is "json" to
+	// write them as a JSON array of strings instead.
+	CompletionsDelimiter string `json:"completionsDelimiter,omitempty" yaml:"completionsDelimiter,omitempty"`
+	CompletionsFormat    string `json:"completionsFormat,omitempty" yaml:"completionsFormat,omitempty"`
 
 	// Verbosity options
 	Verbose   bool `json:"verbose,omitempty" yaml:"verbose,omitempty"`
@@ -45,19 +53,26 @@ type RunOptions struct {
 
 	ConfigPath string `json:"configPath,omitempty" yaml:"configPath,omitempty"`
 
+	// HTTP record/replay, for testing backends without network access.
+	HTTPRecordFile string `json:"httpRecordFile,omitempty" yaml:"httpRecordFile,omitempty"`
+	HTTPReplayFile string `json:"httpReplayFile,omitempty" yaml:"httpReplayFile,omitempty"`
+
 	// Backend/Provider-specific options.
 	OpenAIUseLegacyMaxTokens bool `json:"openaiUseLegacyMaxTokens,omitempty"`
 }
 
 // GetCombinedInputReader returns an io.Reader that combines all input sources.
 func (ro *RunOptions) GetCombinedInputReader(ctx context.Context) (io.Reader, error) {
-	handler := &InputHandler{
+	return ro.inputHandler().Process(ctx)
+}
+
+func (ro *RunOptions) inputHandler() *InputHandler {
+	return &InputHandler{
 		Files:   ro.InputFiles,
 		Strings: ro.InputStrings,
 		Args:    ro.PositionalArgs,
 		Stdin:   ro.Stdin,
 	}
-	return handler.Process(ctx)
 }
 
 // InputSourceType represents the type of input source.
@@ -72,7 +87,9 @@ const (
 
 // InputSource represents a single input source.
 type InputSource struct {
-	Type   InputSourceType
+	Type InputSourceType
+	// Name identifies the source, such as a file path.
+	Name   string
 	Reader io.Reader
 }
 
@@ -93,34 +110,47 @@ type InputSources []InputSource
 // 2. Strings
 // 3. Args
 func (h *InputHandler) Process(ctx context.Context) (io.Reader, error) {
-	var readers []io.Reader
+	sources, err := h.Sources(ctx)
+	if err != nil {
+		return nil, err
+	}
+	readers := make([]io.Reader, len(sources))
+	for i, src := range sources {
+		readers[i] = src.Reader
+	}
+	return io.MultiReader(readers...), nil
+}
+
+// Sources returns the input sources, in the order Process reads them.
+func (h *InputHandler) Sources(ctx context.Context) (InputSources, error) {
+	var sources InputSources
 	stdinReader := h.getStdinReader()
 
 	for _, file := range h.Files {
 		if file == "-" {
 			if stdinReader != nil {
-				readers = append(readers, stdinReader)
+				sources = append(sources, InputSource{Type: InputSourceStdin, Name: "-", Reader: stdinReader})
 			} else {
-				readers = append(readers, strings.NewReader(""))
+				sources = append(sources, InputSource{Type: InputSourceStdin, Name: "-", Reader: strings.NewReader("")})
 			}
 		} else {
 			f, err := os.Open(file)
 			if err != nil {
 				return nil, err
 			}
-			readers = append(readers, f)
+			sources = append(sources, InputSource{Type: InputSourceFile, Name: file, Reader: f})
 		}
 	}
 
 	for _, s := range h.Strings {
-		readers = append(readers, strings.NewReader(s))
+		sources = append(sources, InputSource{Type: InputSourceString, Reader: strings.NewReader(s)})
 	}
 
 	for _, arg := range h.Args {
-		readers = append(readers, strings.NewReader(arg))
+		sources = append(sources, InputSource{Type: InputSourceArg, Reader: strings.NewReader(arg)})
 	}
 
-	return io.MultiReader(readers...), nil
+	return sources, nil
 }
 
 func (h *InputHandler) getStdinReader() io.Reader {
@@ -4,8 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/tmc/langchaingo/llms"
 )
@@ -29,6 +30,18 @@ type ChatCompletionPayload struct {
 	Model    string `json:"model"`
 	Messages []llms.MessageContent
 	Stream   bool `json:"stream,omitempty"`
+	// Usage records the usage of the completions that produced assistant
+	// messages.
+	Usage []MessageUsage `json:"usage,omitempty"`
+	// Thinking records the thinking that preceded assistant messages.
+	Thinking []MessageThinking `json:"thinking,omitempty"`
+	// Alternatives records the other completions generated for the same
+	// user turns as assistant messages.
+	Alternatives []MessageAlternatives `json:"alternatives,omitempty"`
+	// ActiveBackend and ActiveModel are the backend and model that served
+	// the last completion, if they differ from the configured ones.
+	ActiveBackend string `json:"activeBackend,omitempty"`
+	ActiveModel   string `json:"activeModel,omitempty"`
 }
 
 func (p *ChatCompletionPayload) addMessage(role llms.ChatMessageType, content string) {
@@ -47,8 +60,29 @@ func (p *ChatCompletionPayload) addAssistantMessage(content string) {
 	p.addMessage(llms.ChatMessageTypeAI, content)
 }
 
-func (s *CompletionService) PerformCompletionStreaming(ctx context.Context, payload *ChatCompletionPayload, cfg PerformCompletionConfig) (<-chan string, error) {
-	ch := make(chan string)
+// PerformCompletionEvents streams a completion of payload as events: text
+// and thinking deltas as they arrive, then any tool calls, the usage and
+// the finish reason. A failed completion ends with an error event instead,
+// after any partial output, which is kept as the assistant message. The
+// channel is closed after the last event; callers must receive until then,
+// or cancel ctx.
+func (s *CompletionService) PerformCompletionEvents(ctx context.Context, payload *ChatCompletionPayload, cfg PerformCompletionConfig) (<-chan StreamEvent, error) {
+	if err := s.checkContextWindow(s.pendingMessages(payload)); err != nil {
+		return nil, err
+	}
+	if err := s.checkBudget(); err != nil {
+		return nil, err
+	}
+	ch := make(chan StreamEvent)
+	// send sends an event unless sendCtx is done, reporting whether it did.
+	send := func(sendCtx context.Context, ev StreamEvent) bool {
+		select {
+		case ch <- ev:
+			return true
+		case <-sendCtx.Done():
+			return false
+		}
+	}
 	go func() {
 		defer close(ch)
 		fullResponse := strings.Builder{}
@@ -62,9 +96,7 @@ func (s *CompletionService) PerformCompletionStreaming(ctx context.Context, payl
 			if cfg.EchoPrefill {
 				spinnerPos = len(s.nextCompletionPrefill) + 1
 			}
-			select {
-			case ch <- s.nextCompletionPrefill + " ":
-			case <-ctx.Done():
+			if !send(ctx, StreamEvent{Type: StreamEventText, Text: s.nextCompletionPrefill + " "}) {
 				prefillCleanup()
 				return
 			}
@@ -78,63 +110,111 @@ func (s *CompletionService) PerformCompletionStreaming(ctx context.Context, payl
 		if cfg.ShowSpinner {
 			spinnerStop = spin(spinnerPos)
 		}
+		// Thinking may arrive on another goroutine, so stop the spinner once.
+		stopSpinner := sync.OnceFunc(func() {
+			if spinnerStop != nil {
+				spinnerStop()
+			}
+		})
 
-		// Create a cancellable context for the generation
-		genCtx, cancel := context.WithCancel(ctx)
+		// Create a cancellable context for the generation, which the
+		// completion timeouts also cancel.
+		genCtx, timer, cancel := s.withTimeouts(ctx, s.capabilities.Streaming)
 		defer cancel()
 
-		// Handle ctrl-c by cancelling the generation context
-		go func() {
-			select {
-			case <-ctx.Done():
-				cancel()
-			case <-genCtx.Done():
+		genCtx, recordThinking := s.startThinking(genCtx, payload, func(text string) {
+			stopSpinner()
+			timer.token()
+			send(genCtx, StreamEvent{Type: StreamEventThinking, Text: text})
+		})
+
+		onChunk := func(ctx context.Context, chunk []byte) error {
+			timer.token()
+			if firstChunk {
+				prefillCleanup()
+				stopSpinner()
+				firstChunk = false
 			}
-		}()
-
-		_, err := s.model.GenerateContent(genCtx, payload.Messages,
-			llms.WithMaxTokens(s.cfg.MaxTokens),
-			llms.WithTemperature(s.cfg.Temperature),
-			llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
-				if firstChunk {
-					prefillCleanup()
-					if spinnerStop != nil {
-						spinnerStop()
-						spinnerStop = nil
-					}
-					firstChunk = false
-				}
-
-				select {
-				case ch <- string(chunk):
-					fullResponse.Write(chunk)
-					return nil
-				case <-ctx.Done():
-					return ctx.Err()
-				}
-			}))
-
-		if err != nil && !errors.Is(err, context.Canceled) {
-			log.Printf("failed to generate content: %v", err)
+
+			if !send(ctx, StreamEvent{Type: StreamEventText, Text: string(chunk)}) {
+				return ctx.Err()
+			}
+			fullResponse.Write(chunk)
+			return nil
+		}
+		options := s.callOptions()
+		if s.capabilities.Streaming {
+			options = append(options, llms.WithStreamingFunc(onChunk))
+		} else {
+			s.noticef("%s does not support streaming, waiting for the full response", s.cfg.Model)
 		}
 
-		// Clean up spinner if it's still running
-		if spinnerStop != nil {
-			spinnerStop()
+		start := time.Now()
+		resp, err := s.model.GenerateContent(genCtx, s.prepareMessages(payload.Messages), options...)
+		if err == nil && !s.capabilities.Streaming && len(resp.Choices) > 0 {
+			err = onChunk(genCtx, []byte(resp.Choices[0].Content))
 		}
+		err = timeoutError(genCtx, err)
+		s.recordActiveModel(payload)
+
+		// Remove the prefill even if no chunk arrived.
+		prefillCleanup()
 
-		// Add the assistant message if we haven't already
-		if !addedAssistantMessage {
-			payload.addAssistantMessage(fullResponse.String())
+		// Clean up spinner if it's still running
+		stopSpinner()
+
+		// Add the assistant message if we haven't already, keeping any
+		// partial response to a failed request.
+		var (
+			usage    Usage
+			hasUsage bool
+		)
+		if err == nil || fullResponse.Len() > 0 {
+			if !addedAssistantMessage {
+				payload.addAssistantMessage(fullResponse.String())
+			}
+			recordThinking()
+			usage, hasUsage = s.recordUsage(payload, resp, time.Since(start))
 		}
 
 		s.nextCompletionPrefill = ""
+
+		// The final events are sent unless the caller gives up, even when
+		// a timeout cancelled the generation.
+		if err != nil {
+			send(ctx, StreamEvent{Type: StreamEventError, Err: err})
+			return
+		}
+		if len(resp.Choices) == 0 {
+			send(ctx, StreamEvent{Type: StreamEventError, Err: errors.New("no response from model")})
+			return
+		}
+		choice := resp.Choices[0]
+		for i, tc := range choice.ToolCalls {
+			d := &ToolCallDelta{Index: i, ID: tc.ID}
+			if tc.FunctionCall != nil {
+				d.Name, d.Arguments = tc.FunctionCall.Name, tc.FunctionCall.Arguments
+			}
+			if !send(ctx, StreamEvent{Type: StreamEventToolCall, ToolCall: d}) {
+				return
+			}
+		}
+		if hasUsage && !send(ctx, StreamEvent{Type: StreamEventUsage, Usage: &usage}) {
+			return
+		}
+		send(ctx, StreamEvent{Type: StreamEventFinish, FinishReason: choice.StopReason})
 	}()
 	return ch, nil
 }
 
 // PerformCompletion provides a non-streaming version of the completion.
 func (s *CompletionService) PerformCompletion(ctx context.Context, payload *ChatCompletionPayload, cfg PerformCompletionConfig) (string, error) {
+	if err := s.checkContextWindow(s.pendingMessages(payload)); err != nil {
+		return "", err
+	}
+	if err := s.checkBudget(); err != nil {
+		return "", err
+	}
 	var stopSpinner func()
 	var spinnerPos int
 	addedAssistantMessage := false
@@ -149,15 +229,27 @@ func (s *CompletionService) PerformCompletion(ctx context.Context, payload *Chat
 
 	if cfg.ShowSpinner {
 		stopSpinner = spin(spinnerPos)
-		defer stopSpinner()
 	}
-
-	response, err := s.model.GenerateContent(ctx, payload.Messages,
-		llms.WithMaxTokens(s.cfg.MaxTokens),
-		llms.WithTemperature(s.cfg.Temperature))
+	stopSpinnerOnce := sync.OnceFunc(func() {
+		if stopSpinner != nil {
+			stopSpinner()
+		}
+	})
+	defer stopSpinnerOnce()
+	ctx, timer, cancel := s.withTimeouts(ctx, false)
+	defer cancel()
+	ctx, recordThinking := s.startThinking(ctx, payload, func(text string) {
+		stopSpinnerOnce()
+		s.writeThinking(text)
+	})
+
+	start := time.Now()
+	response, err := s.model.GenerateContent(ctx, s.prepareMessages(payload.Messages), s.callOptions()...)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate content: %w", err)
+		return "", fmt.Errorf("failed to generate content: %w", timeoutError(ctx, err))
 	}
+	timer.token()
+	s.recordActiveModel(payload)
 	if len(response.Choices) == 0 {
 		return "", fmt.Errorf("no response from model")
 	}
@@ -166,6 +258,8 @@ func (s *CompletionService) PerformCompletion(ctx context.Context, payload *Chat
 	if !addedAssistantMessage {
 		payload.addAssistantMessage(content)
 	}
+	recordThinking()
+	s.recordUsage(payload, response, time.Since(start))
 
 	return content, nil
 }
@@ -0,0 +1,124 @@
+package cgpt
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Profile is a named set of settings, selected with --profile.
+// Settings left empty keep their value from the rest of the configuration.
+type Profile struct {
+	Backend      string   `yaml:"backend"`
+	Model        string   `yaml:"model"`
+	SystemPrompt string   `yaml:"systemPrompt"`
+	Temperature  *float64 `yaml:"temperature"`
+	MaxTokens    int      `yaml:"maxTokens"`
+	Prefill      string   `yaml:"prefill"`
+}
+
+// loadProfile returns the profile selected by the "profile" key, if any.
+func loadProfile(v *viper.Viper) (Profile, bool, error) {
+	name := v.GetString("profile")
+	if name == "" {
+		return Profile{}, false, nil
+	}
+	var profiles map[string]Profile
+	if err := v.UnmarshalKey("profiles", &profiles); err != nil {
+		return Profile{}, false, fmt.Errorf("unable to read profiles: %w", err)
+	}
+	// Configuration keys are case-insensitive, and are stored lowercased.
+	p, ok := profiles[strings.ToLower(name)]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for n := range profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Profile{}, false, fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return p, true, nil
+}
+
+// apply sets the profile's settings in v. Profile settings take precedence
+// over the configuration file, but not over flags or environment variables.
+// The model is handled by LoadConfig, as it depends on the backend.
+func (p Profile) apply(v *viper.Viper, flagSet *pflag.FlagSet, stderr io.Writer) {
+	settings := []struct {
+		flag, key string
+		value     any
+		set       bool
+	}{
+		{"backend", "backend", p.Backend, p.Backend != ""},
+		{"system-prompt", "systemPrompt", p.SystemPrompt, p.SystemPrompt != ""},
+		{"temperature", "temperature", p.Temperature, p.Temperature != nil},
+		{"max-tokens", "maxTokens", p.MaxTokens, p.MaxTokens != 0},
+		{"prefill", "prefill", p.Prefill, p.Prefill != ""},
+	}
+	for _, s := range settings {
+		if !s.set || setByFlagOrEnv(flagSet, s.flag, s.key) {
+			continue
+		}
+		if f, ok := s.value.(*float64); ok {
+			s.value = *f
+		}
+		v.Set(s.key, s.value)
+		if verbose, _ := flagSet.GetBool("verbose"); verbose {
+			fmt.Fprintf(stderr, "cgpt: %s set by profile: %v\n", s.key, s.value)
+		}
+	}
+}
+
+// setByFlagOrEnv reports whether a setting was given on the command line or
+// in a CGPT_ environment variable.
+func setByFlagOrEnv(flagSet *pflag.FlagSet, flag, key string) bool {
+	if f := flagSet.Lookup(flag); f != nil && f.Changed {
+		return true
+	}
+	return isEnvSet("CGPT_" + strings.ToUpper(key))
+}
+
+// setExplicitly reports whether a setting was given on the command line, in
+// a CGPT_ environment variable or in the configuration file.
+func setExplicitly(v *viper.Viper, flagSet *pflag.FlagSet, flag, key string) bool {
+	return setByFlagOrEnv(flagSet, flag, key) || v.InConfig(key)
+}
+
+// resolveModelAlias returns the model that alias refers to for the backend.
+// Aliases in the configuration take precedence over those of the backend.
+// Names that are not aliases are returned unchanged.
+func (cfg *Config) resolveModelAlias(backend, alias string) string {
+	if model, ok := cfg.ModelAliases[strings.ToLower(alias)]; ok {
+		return model
+	}
+	if b, ok := cfg.lookupBackend(backend); ok {
+		if model, ok := b.ModelAliases[strings.ToLower(alias)]; ok {
+			return model
+		}
+	}
+	return alias
+}
+
+// resolveModelAliases replaces model aliases in cfg with the models they refer to.
+func (cfg *Config) resolveModelAliases(stderr io.Writer, flagSet *pflag.FlagSet) {
+	resolve := func(backend string, model *string) {
+		resolved := cfg.resolveModelAlias(backend, *model)
+		if resolved == *model {
+			return
+		}
+		if verbose, _ := flagSet.GetBool("verbose"); verbose {
+			fmt.Fprintf(stderr, "cgpt: model alias %q for %s backend is %s\n", *model, backend, resolved)
+		}
+		*model = resolved
+	}
+	resolve(cfg.Backend, &cfg.Model)
+	for i := range cfg.Fallbacks {
+		if cfg.Fallbacks[i].Model != "" {
+			resolve(cfg.Fallbacks[i].Backend, &cfg.Fallbacks[i].Model)
+		}
+	}
+}
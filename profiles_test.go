@@ -0,0 +1,169 @@
+package cgpt
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestProfiles(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configPath, []byte(`
+backend: anthropic
+model: claude-3-5-sonnet-latest
+temperature: 0.7
+modelAliases:
+  local: llama3.1:70b
+profiles:
+  review:
+    model: smart
+    systemPrompt: "You are a careful code reviewer."
+    temperature: 0.2
+    maxTokens: 2000
+    prefill: "Findings:"
+  quick:
+    backend: openai
+  zero:
+    temperature: 0
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		args        []string
+		env         map[string]string
+		wantErr     string
+		wantBackend string
+		wantModel   string
+		wantSystem  string
+		wantTemp    float64
+		wantMax     int
+		wantPrefill string
+	}{
+		{
+			name:        "no profile",
+			wantBackend: "anthropic",
+			wantModel:   "claude-3-5-sonnet-latest",
+			wantTemp:    0.7,
+			wantMax:     4096,
+		},
+		{
+			name:        "profile overrides file",
+			args:        []string{"--profile", "review"},
+			wantBackend: "anthropic",
+			wantModel:   "claude-3-7-sonnet-20250219",
+			wantSystem:  "You are a careful code reviewer.",
+			wantTemp:    0.2,
+			wantMax:     2000,
+			wantPrefill: "Findings:",
+		},
+		{
+			name:        "flags override profile",
+			args:        []string{"--profile", "review", "-m", "fast", "-T", "0.9", "--system-prompt", "Be terse."},
+			wantBackend: "anthropic",
+			wantModel:   "claude-3-5-haiku-latest",
+			wantSystem:  "Be terse.",
+			wantTemp:    0.9,
+			wantMax:     2000,
+			wantPrefill: "Findings:",
+		},
+		{
+			name:        "env overrides profile",
+			args:        []string{"--profile", "review"},
+			env:         map[string]string{"CGPT_MODEL": "claude-3-opus-latest", "CGPT_MAXTOKENS": "100"},
+			wantBackend: "anthropic",
+			wantModel:   "claude-3-opus-latest",
+			wantSystem:  "You are a careful code reviewer.",
+			wantTemp:    0.2,
+			wantMax:     100,
+			wantPrefill: "Findings:",
+		},
+		{
+			name:        "profile from env",
+			env:         map[string]string{"CGPT_PROFILE": "quick"},
+			wantBackend: "openai",
+			wantModel:   "gpt-4o",
+			wantTemp:    0.7,
+			wantMax:     4096,
+		},
+		{
+			name:        "zero temperature",
+			args:        []string{"--profile", "zero"},
+			wantBackend: "anthropic",
+			wantModel:   "claude-3-5-sonnet-latest",
+			wantMax:     4096,
+		},
+		{
+			name:        "configured alias",
+			args:        []string{"-b", "ollama", "-m", "local"},
+			wantBackend: "ollama",
+			wantModel:   "llama3.1:70b",
+			wantTemp:    0.7,
+			wantMax:     4096,
+		},
+		{
+			name:        "backend alias",
+			args:        []string{"-b", "openai", "-m", "FAST"},
+			wantBackend: "openai",
+			wantModel:   "gpt-4o-mini",
+			wantTemp:    0.7,
+			wantMax:     4096,
+		},
+		{
+			name:    "unknown profile",
+			args:    []string{"--profile", "missing"},
+			wantErr: `unknown profile "missing" (available: quick, review, zero)`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			fs.String("config", "", "")
+			fs.StringP("backend", "b", "anthropic", "")
+			fs.StringP("model", "m", "claude-3-7-sonnet-20250219", "")
+			fs.StringP("system-prompt", "s", "", "")
+			fs.IntP("max-tokens", "t", 0, "")
+			fs.Float64P("temperature", "T", 0.05, "")
+			fs.StringP("prefill", "p", "", "")
+			fs.String("profile", "", "")
+			if err := fs.Parse(append([]string{"--config", configPath}, tt.args...)); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := LoadConfig(configPath, &bytes.Buffer{}, fs)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Backend != tt.wantBackend || cfg.Model != tt.wantModel {
+				t.Errorf("backend/model = %s/%s, want %s/%s", cfg.Backend, cfg.Model, tt.wantBackend, tt.wantModel)
+			}
+			if cfg.SystemPrompt != tt.wantSystem {
+				t.Errorf("SystemPrompt = %q, want %q", cfg.SystemPrompt, tt.wantSystem)
+			}
+			if cfg.Temperature != tt.wantTemp {
+				t.Errorf("Temperature = %v, want %v", cfg.Temperature, tt.wantTemp)
+			}
+			if cfg.MaxTokens != tt.wantMax {
+				t.Errorf("MaxTokens = %d, want %d", cfg.MaxTokens, tt.wantMax)
+			}
+			if cfg.Prefill != tt.wantPrefill {
+				t.Errorf("Prefill = %q, want %q", cfg.Prefill, tt.wantPrefill)
+			}
+		})
+	}
+}
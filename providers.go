@@ -0,0 +1,149 @@
+package cgpt
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/openai"
+)
+
+// ProviderConfig defines a named OpenAI-compatible endpoint, such as a vLLM,
+// LM Studio or LiteLLM server. Each provider is selectable with --backend.
+type ProviderConfig struct {
+	// BaseURL is the API base URL, e.g. http://localhost:8000/v1.
+	BaseURL string `yaml:"baseURL"`
+	// APIKeyEnv names the environment variable holding the API key.
+	APIKeyEnv string `yaml:"apiKeyEnv"`
+	// Organization is sent as the OpenAI-Organization header.
+	Organization string `yaml:"organization"`
+	// Headers are added to every request.
+	Headers map[string]string `yaml:"headers"`
+	// DefaultModel is used when no model is configured.
+	DefaultModel string `yaml:"defaultModel"`
+	// ModelAliases maps short names to models served by the provider.
+	ModelAliases map[string]string `yaml:"modelAliases"`
+}
+
+// lookupBackend returns the backend with the given name. Providers defined in
+// the configuration take precedence over registered backends.
+func (cfg *Config) lookupBackend(name string) (Backend, bool) {
+	// Configuration keys are case-insensitive, and are stored lowercased.
+	// The backend is named by its key, so that per-backend settings under
+	// 'backends', also keyed in lowercase, apply to it.
+	key := strings.ToLower(name)
+	if p, ok := cfg.Providers[key]; ok {
+		return Backend{
+			Name:         key,
+			DefaultModel: p.DefaultModel,
+			ModelAliases: p.ModelAliases,
+			New: func(cfg *Config, mo *InferenceProviderOptions) (llms.Model, error) {
+				return newOpenAICompatibleModel(cfg, mo, p)
+			},
+			ListModels: func(ctx context.Context, cfg *Config, client *http.Client) ([]string, error) {
+				return listProviderModels(ctx, client, p)
+			},
+			Capabilities: BackendCapabilities{Streaming: true, SystemPrompt: true, Embeddings: true},
+		}, true
+	}
+	return LookupBackend(name)
+}
+
+// BackendNames returns the sorted names of the registered backends followed
+// by the sorted names of the configured providers.
+func (cfg *Config) BackendNames() []string {
+	names := BackendNames()
+	var providers []string
+	for name := range cfg.Providers {
+		if _, ok := LookupBackend(name); !ok {
+			providers = append(providers, name)
+		}
+	}
+	slices.Sort(providers)
+	return append(names, providers...)
+}
+
+func newOpenAICompatibleModel(cfg *Config, mo *InferenceProviderOptions, p ProviderConfig) (llms.Model, error) {
+	// Always pass a token: the openai package otherwise falls back to
+	// OPENAI_API_KEY, which must not leak to other endpoints. Many local
+	// servers accept any token.
+	token := "unused"
+	if p.APIKeyEnv != "" {
+		if key := os.Getenv(p.APIKeyEnv); key != "" {
+			token = key
+		}
+	}
+	options := []openai.Option{
+		openai.WithModel(cfg.Model),
+		openai.WithEmbeddingModel(cfg.Model),
+		openai.WithToken(token),
+	}
+	if p.BaseURL != "" {
+		options = append(options, openai.WithBaseURL(p.BaseURL))
+	}
+	if p.Organization != "" {
+		options = append(options, openai.WithOrganization(p.Organization))
+	}
+	if client := withHeaders(withRequestFields(mo.HTTPClient, cfg.openAIRequestFields()), p.Headers); client != nil {
+		options = append(options, openai.WithHTTPClient(client))
+	}
+	if mo.OpenAICompatUseLegacyMaxTokens {
+		options = append(options, openai.WithUseLegacyMaxTokens(true))
+	}
+	return openai.New(options...)
+}
+
+func listProviderModels(ctx context.Context, client *http.Client, p ProviderConfig) ([]string, error) {
+	headers := map[string]string{}
+	for k, v := range p.Headers {
+		headers[k] = v
+	}
+	if p.APIKeyEnv != "" {
+		if key := os.Getenv(p.APIKeyEnv); key != "" {
+			headers["Authorization"] = "Bearer " + key
+		}
+	}
+	if p.Organization != "" {
+		headers["OpenAI-Organization"] = p.Organization
+	}
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.openai.com/v1"
+	}
+	return listOpenAICompatibleModels(ctx, client, baseURL, headers)
+}
+
+// withHeaders returns a copy of client that adds headers to every request.
+// It returns client unchanged if there are no headers.
+func withHeaders(client *http.Client, headers map[string]string) *http.Client {
+	if len(headers) == 0 {
+		return client
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	c := *client
+	c.Transport = &headerTransport{headers: headers, base: client.Transport}
+	return &c
+}
+
+// headerTransport adds fixed headers to outgoing requests.
+type headerTransport struct {
+	headers map[string]string
+	base    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	req = req.Clone(req.Context())
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	return base.RoundTrip(req)
+}
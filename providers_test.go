@@ -0,0 +1,122 @@
+package cgpt
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestProviders(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Clone(context.Background())
+		writeOpenAIResponse(w, r, "hi from the gateway")
+	}))
+	defer srv.Close()
+
+	t.Setenv("GATEWAY_KEY", "gateway-secret")
+	t.Setenv("OPENAI_API_KEY", "openai-secret")
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configPath, []byte(`
+providers:
+  gateway:
+    baseURL: `+srv.URL+`/v1
+    apiKeyEnv: GATEWAY_KEY
+    organization: my-org
+    headers:
+      X-Team: platform
+    defaultModel: team-default
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.String("config", "", "")
+	fs.String("backend", "anthropic", "")
+	fs.String("model", "claude-3-7-sonnet-20250219", "")
+	if err := fs.Parse([]string{"--config", configPath, "--backend", "gateway"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(configPath, &bytes.Buffer{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Backend != "gateway" || cfg.Model != "team-default" {
+		t.Fatalf("backend/model = %s/%s, want gateway/team-default", cfg.Backend, cfg.Model)
+	}
+	cfg.Retry.MaxAttempts = 1
+
+	model, err := InitializeModel(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := llms.GenerateFromSinglePrompt(context.Background(), model, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content != "hi from the gateway" {
+		t.Errorf("content = %q", content)
+	}
+
+	if got.URL.Path != "/v1/chat/completions" {
+		t.Errorf("path = %q, want /v1/chat/completions", got.URL.Path)
+	}
+	for header, want := range map[string]string{
+		"Authorization":       "Bearer gateway-secret",
+		"Openai-Organization": "my-org",
+		"X-Team":              "platform",
+	} {
+		if v := got.Header.Get(header); v != want {
+			t.Errorf("header %s = %q, want %q", header, v, want)
+		}
+	}
+}
+
+func TestProviderNames(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configPath, []byte(`
+providers:
+  vllm:
+    baseURL: http://localhost:8000/v1
+  MyGateway:
+    baseURL: http://localhost:4000/v1
+backends:
+  MyGateway:
+    retry:
+      maxAttempts: 7
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.String("config", "", "")
+	fs.Set("config", configPath)
+	cfg, err := LoadConfig(configPath, &bytes.Buffer{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := cfg.BackendNames()
+	if got, want := names[len(names)-2:], []string{"mygateway", "vllm"}; !slices.Equal(got, want) {
+		t.Errorf("BackendNames() ends with %q, want %q", got, want)
+	}
+	b, ok := cfg.lookupBackend("MyGateway")
+	if !ok {
+		t.Fatal("provider MyGateway not found")
+	}
+	if b.Name != "mygateway" {
+		t.Errorf("backend name = %q, want mygateway", b.Name)
+	}
+	if got := cfg.retryPolicy(b.Name).MaxAttempts; got != 7 {
+		t.Errorf("retry max attempts = %d, want the backend's 7", got)
+	}
+}
@@ -0,0 +1,330 @@
+package cgpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// RateLimit limits the request rate, token rate and concurrency of a backend
+// or model. Limits are shared by every cgpt process on the machine.
+// Zero fields are unlimited.
+type RateLimit struct {
+	RequestsPerMinute int `yaml:"requestsPerMinute"`
+	TokensPerMinute   int `yaml:"tokensPerMinute"`
+	MaxConcurrent     int `yaml:"maxConcurrent"`
+}
+
+func (l RateLimit) enabled() bool {
+	return l.RequestsPerMinute > 0 || l.TokensPerMinute > 0 || l.MaxConcurrent > 0
+}
+
+// rateLimit returns the rate limit for a backend and model, along with the
+// key under which its state is shared. A model-specific limit gets its own
+// state; otherwise all models of a backend share the backend's limit.
+func (cfg *Config) rateLimit(backend, model string) (RateLimit, string, bool) {
+	bc, ok := cfg.Backends[backend]
+	if !ok {
+		return RateLimit{}, "", false
+	}
+	if l, ok := bc.ModelRateLimits[model]; ok && l.enabled() {
+		return l, backend + ":" + model, true
+	}
+	if bc.RateLimit.enabled() {
+		return bc.RateLimit, backend, true
+	}
+	return RateLimit{}, "", false
+}
+
+// defaultRateLimitDir returns the directory holding shared rate limit state.
+func defaultRateLimitDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	return filepath.Join(home, ".cgpt", "ratelimit"), nil
+}
+
+// RateLimiter is a token bucket limiter whose state lives in a file, so that
+// concurrent cgpt processes queue behind each other instead of tripping
+// provider rate limits.
+type RateLimiter struct {
+	Key   string
+	Limit RateLimit
+	Dir   string
+
+	// LeaseTimeout is how long a concurrency slot stays taken if its process
+	// exits without releasing it and the exit goes unnoticed. Slots of
+	// processes that are no longer running are reclaimed right away.
+	LeaseTimeout time.Duration
+
+	now func() time.Time
+}
+
+type rateLimitState struct {
+	Requests float64              `json:"requests"`
+	Tokens   float64              `json:"tokens"`
+	Updated  time.Time            `json:"updated"`
+	Leases   map[string]time.Time `json:"leases,omitempty"`
+}
+
+const defaultLeaseTimeout = 10 * time.Minute
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+func (l *RateLimiter) path() string {
+	return filepath.Join(l.Dir, unsafeFileChars.ReplaceAllString(l.Key, "_")+".json")
+}
+
+func (l *RateLimiter) clock() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+// Acquire blocks until a request estimated to use the given number of tokens
+// may proceed, or ctx is done. onWait, if non-nil, is called once if the
+// request has to wait. The returned release function must be called when the
+// request finishes, with the number of tokens used beyond the estimate.
+func (l *RateLimiter) Acquire(ctx context.Context, tokens int, onWait func(time.Duration)) (release func(extraTokens int), err error) {
+	if err := os.MkdirAll(l.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create rate limit directory: %w", err)
+	}
+	id := fmt.Sprintf("%d-%x", os.Getpid(), rand.Uint64())
+	notified := false
+	for {
+		var wait time.Duration
+		err := l.update(ctx, func(st *rateLimitState) {
+			wait = l.take(st, id, tokens)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if wait == 0 {
+			return func(extraTokens int) {
+				_ = l.update(context.Background(), func(st *rateLimitState) {
+					delete(st.Leases, id)
+					if l.Limit.TokensPerMinute > 0 {
+						st.Tokens -= float64(extraTokens)
+					}
+				})
+			}, nil
+		}
+		if onWait != nil && !notified {
+			onWait(wait)
+			notified = true
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// take refills the buckets and, if the request fits, takes its share and
+// returns zero. Otherwise it returns how long to wait before trying again.
+func (l *RateLimiter) take(st *rateLimitState, id string, tokens int) time.Duration {
+	now := l.clock()
+	leaseTimeout := l.LeaseTimeout
+	if leaseTimeout == 0 {
+		leaseTimeout = defaultLeaseTimeout
+	}
+	if st.Updated.IsZero() {
+		st.Requests = float64(l.Limit.RequestsPerMinute)
+		st.Tokens = float64(l.Limit.TokensPerMinute)
+	} else if elapsed := now.Sub(st.Updated).Minutes(); elapsed > 0 {
+		st.Requests = min(st.Requests+elapsed*float64(l.Limit.RequestsPerMinute), float64(l.Limit.RequestsPerMinute))
+		st.Tokens = min(st.Tokens+elapsed*float64(l.Limit.TokensPerMinute), float64(l.Limit.TokensPerMinute))
+	}
+	st.Updated = now
+	for lease, expires := range st.Leases {
+		if now.After(expires) || leaseHolderExited(lease) {
+			delete(st.Leases, lease)
+		}
+	}
+
+	var wait time.Duration
+	if l.Limit.MaxConcurrent > 0 && len(st.Leases) >= l.Limit.MaxConcurrent {
+		wait = 250 * time.Millisecond
+	}
+	if rpm := l.Limit.RequestsPerMinute; rpm > 0 && st.Requests < 1 {
+		wait = max(wait, minutes((1-st.Requests)/float64(rpm)))
+	}
+	if tpm := l.Limit.TokensPerMinute; tpm > 0 {
+		// Requests larger than the bucket proceed once it is full.
+		need := min(float64(tokens), float64(tpm))
+		if st.Tokens < need {
+			wait = max(wait, minutes((need-st.Tokens)/float64(tpm)))
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	if l.Limit.RequestsPerMinute > 0 {
+		st.Requests--
+	}
+	if l.Limit.TokensPerMinute > 0 {
+		st.Tokens -= float64(tokens)
+	}
+	if l.Limit.MaxConcurrent > 0 {
+		if st.Leases == nil {
+			st.Leases = map[string]time.Time{}
+		}
+		st.Leases[id] = now.Add(leaseTimeout)
+	}
+	return 0
+}
+
+// leaseHolderExited reports whether the process that took a lease, whose id
+// starts with its PID, is no longer running.
+func leaseHolderExited(id string) bool {
+	pid, _, ok := strings.Cut(id, "-")
+	if !ok {
+		return false
+	}
+	n, err := strconv.Atoi(pid)
+	if err != nil || n <= 0 {
+		return false
+	}
+	if n == os.Getpid() {
+		return false
+	}
+	return !processRunning(n)
+}
+
+// processRunning reports whether a process with the given PID exists. Signal
+// errors other than the process being gone, such as a permission error,
+// count as running.
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return !errors.Is(err, os.ErrProcessDone) && !errors.Is(err, syscall.ESRCH)
+}
+
+func minutes(m float64) time.Duration {
+	return max(time.Duration(m*float64(time.Minute)), time.Millisecond)
+}
+
+// update runs fn on the shared state while holding the state file lock.
+func (l *RateLimiter) update(ctx context.Context, fn func(*rateLimitState)) error {
+	path := l.path()
+	unlock, err := lockFile(ctx, path+".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var st rateLimitState
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read rate limit state: %w", err)
+	}
+	if len(b) > 0 {
+		// A corrupt state file is treated as empty.
+		_ = json.Unmarshal(b, &st)
+	}
+	fn(&st)
+	b, err = json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("failed to write rate limit state: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// staleLockAge is the age after which a lock file is assumed to belong to a
+// process that died while holding it.
+const staleLockAge = 10 * time.Second
+
+// lockFile takes an exclusive lock by creating path, waiting while another
+// process holds it.
+func lockFile(ctx context.Context, path string) (unlock func(), err error) {
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) > staleLockAge {
+			os.Remove(path)
+			continue
+		}
+		if err := sleepContext(ctx, 10*time.Millisecond); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// RateLimitedModel is an llms.Model that waits for its RateLimiter before
+// each call.
+type RateLimitedModel struct {
+	Model   llms.Model
+	Limiter *RateLimiter
+
+	// OnWait, if set, is called when a call has to wait for capacity.
+	OnWait func(key string, wait time.Duration)
+}
+
+func (m *RateLimitedModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+func (m *RateLimitedModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	var onWait func(time.Duration)
+	if m.OnWait != nil {
+		onWait = func(d time.Duration) { m.OnWait(m.Limiter.Key, d) }
+	}
+	release, err := m.Limiter.Acquire(ctx, estimateMessageTokens(messages), onWait)
+	if err != nil {
+		return nil, fmt.Errorf("rate limiter: %w", err)
+	}
+	resp, err := m.Model.GenerateContent(ctx, messages, options...)
+	var output int
+	if resp != nil {
+		for _, c := range resp.Choices {
+			output += estimateTextTokens(c.Content)
+		}
+	}
+	release(output)
+	return resp, err
+}
+
+// estimateMessageTokens roughly estimates the tokens in messages.
+func estimateMessageTokens(messages []llms.MessageContent) int {
+	n := 0
+	for _, m := range messages {
+		for _, p := range m.Parts {
+			if t, ok := p.(llms.TextContent); ok {
+				n += estimateTextTokens(t.Text)
+			}
+		}
+	}
+	return n
+}
+
+// estimateTextTokens roughly estimates the tokens in s, at four bytes per token.
+func estimateTextTokens(s string) int {
+	return (len(s) + 3) / 4
+}
@@ -0,0 +1,170 @@
+package cgpt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := &RateLimiter{
+		Key:   "test",
+		Limit: RateLimit{RequestsPerMinute: 2, TokensPerMinute: 1000},
+		now:   func() time.Time { return now },
+	}
+	var st rateLimitState
+	if wait := l.take(&st, "a", 100); wait != 0 {
+		t.Fatalf("first request waited %v", wait)
+	}
+	if wait := l.take(&st, "b", 100); wait != 0 {
+		t.Fatalf("second request waited %v", wait)
+	}
+	if wait := l.take(&st, "c", 100); wait != 30*time.Second {
+		t.Errorf("third request wait = %v, want 30s", wait)
+	}
+	now = now.Add(30 * time.Second)
+	if wait := l.take(&st, "c", 100); wait != 0 {
+		t.Errorf("request after refill waited %v", wait)
+	}
+
+	// A request larger than the token bucket waits for it to fill up.
+	now = now.Add(time.Minute)
+	if wait := l.take(&st, "d", 600); wait != 0 {
+		t.Fatalf("token request waited %v", wait)
+	}
+	if wait := l.take(&st, "e", 5000); wait != 36*time.Second {
+		t.Errorf("oversized request wait = %v, want 36s", wait)
+	}
+}
+
+func TestRateLimiterConcurrencyAcrossProcesses(t *testing.T) {
+	dir := t.TempDir()
+	newLimiter := func() *RateLimiter {
+		// Each limiter stands in for a separate process sharing the state directory.
+		return &RateLimiter{Key: "anthropic", Limit: RateLimit{MaxConcurrent: 1}, Dir: dir}
+	}
+	ctx := context.Background()
+
+	release, err := newLimiter().Acquire(ctx, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu     sync.Mutex
+		waited bool
+		done   = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		release2, err := newLimiter().Acquire(ctx, 10, func(time.Duration) {
+			mu.Lock()
+			waited = true
+			mu.Unlock()
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		release2(0)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("second acquire did not wait for the first to release")
+	case <-time.After(100 * time.Millisecond):
+	}
+	release(0)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second acquire did not proceed after release")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !waited {
+		t.Error("onWait was not called")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "anthropic.json")); err != nil {
+		t.Errorf("state file not written: %v", err)
+	}
+}
+
+func TestRateLimitConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configPath, []byte(`
+backend: dummy
+backends:
+  anthropic:
+    rateLimit:
+      requestsPerMinute: 50
+      maxConcurrent: 4
+    modelRateLimits:
+      claude-3-opus-20240229:
+        tokensPerMinute: 20000
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.String("config", "", "")
+	fs.Set("config", configPath)
+	cfg, err := LoadConfig(configPath, &bytes.Buffer{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		model   string
+		want    RateLimit
+		wantKey string
+	}{
+		{"claude-3-7-sonnet-20250219", RateLimit{RequestsPerMinute: 50, MaxConcurrent: 4}, "anthropic"},
+		{"claude-3-opus-20240229", RateLimit{TokensPerMinute: 20000}, "anthropic:claude-3-opus-20240229"},
+	}
+	for _, tt := range tests {
+		got, key, ok := cfg.rateLimit("anthropic", tt.model)
+		if !ok || got != tt.want || key != tt.wantKey {
+			t.Errorf("rateLimit(anthropic, %s) = %+v, %q, %v, want %+v, %q", tt.model, got, key, ok, tt.want, tt.wantKey)
+		}
+	}
+	if _, _, ok := cfg.rateLimit("openai", "gpt-4o"); ok {
+		t.Errorf("rateLimit(openai) configured, want none")
+	}
+}
+
+func TestRateLimiterReclaimsExitedLeases(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	exited := cmd.Process.Pid
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := &RateLimiter{
+		Key:   "test",
+		Limit: RateLimit{MaxConcurrent: 1},
+		now:   func() time.Time { return now },
+	}
+	st := rateLimitState{Leases: map[string]time.Time{
+		fmt.Sprintf("%d-1", exited): now.Add(time.Hour),
+	}}
+	if wait := l.take(&st, fmt.Sprintf("%d-2", os.Getpid()), 10); wait != 0 {
+		t.Fatalf("lease of exited process %d was not reclaimed, waited %v", exited, wait)
+	}
+
+	// A lease held by a running process still counts.
+	if wait := l.take(&st, fmt.Sprintf("%d-3", os.Getpid()), 10); wait == 0 {
+		t.Error("lease of running process was reclaimed")
+	}
+}
@@ -0,0 +1,133 @@
+package cgpt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tmc/cgpt/httprecord"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// writeAnthropicResponse writes a messages API response, as an event stream if requested.
+func writeAnthropicResponse(w http.ResponseWriter, r *http.Request, chunks ...string) {
+	if !strings.Contains(readBody(r), `"stream":true`) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude","content":[{"type":"text","text":%q}],"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":3}}`, strings.Join(chunks, ""))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	event := func(name, data string) { fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data) }
+	event("message_start", `{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[],"model":"claude","usage":{"input_tokens":3,"output_tokens":0}}}`)
+	event("content_block_start", `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`)
+	for _, c := range chunks {
+		event("content_block_delta", fmt.Sprintf(`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":%q}}`, c))
+	}
+	event("content_block_stop", `{"type":"content_block_stop","index":0}`)
+	event("message_delta", `{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":3}}`)
+	event("message_stop", `{"type":"message_stop"}`)
+}
+
+// writeOllamaResponse writes a chat response as newline-delimited JSON.
+func writeOllamaResponse(w http.ResponseWriter, r *http.Request, chunks ...string) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	for _, c := range chunks {
+		fmt.Fprintf(w, "{\"model\":\"llama3.2\",\"message\":{\"role\":\"assistant\",\"content\":%q},\"done\":false}\n", c)
+	}
+	fmt.Fprint(w, "{\"model\":\"llama3.2\",\"message\":{\"role\":\"assistant\",\"content\":\"\"},\"done_reason\":\"stop\",\"done\":true}\n")
+}
+
+// writeGoogleAIResponse writes a generateContent response.
+func writeGoogleAIResponse(w http.ResponseWriter, r *http.Request, chunks ...string) {
+	resp := map[string]any{
+		"candidates": []map[string]any{{
+			"content":      map[string]any{"role": "model", "parts": []map[string]string{{"text": strings.Join(chunks, "")}}},
+			"finishReason": "STOP",
+		}},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func TestBackendsRecordReplay(t *testing.T) {
+	tests := []struct {
+		backend string
+		cfg     Config
+		write   func(http.ResponseWriter, *http.Request, ...string)
+		stream  bool
+	}{
+		{"openai", Config{Model: "gpt-4o", OpenAIAPIKey: "sk-openai-secret"}, writeOpenAIResponse, true},
+		{"anthropic", Config{Model: "claude-3-7-sonnet-20250219", AnthropicAPIKey: "sk-ant-secret"}, writeAnthropicResponse, true},
+		{"ollama", Config{Model: "llama3.2"}, writeOllamaResponse, true},
+		{"googleai", Config{Model: "gemini-pro", GoogleAPIKey: "google-secret"}, writeGoogleAIResponse, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.backend, func(t *testing.T) {
+			t.Setenv("OPENAI_BASE_URL", "")
+			t.Setenv("OLLAMA_HOST", "")
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tt.write(w, r, "hello ", "from ", tt.backend)
+			}))
+			cassette := filepath.Join(t.TempDir(), "cassette.json")
+			cfg := tt.cfg
+			cfg.Backend = tt.backend
+			cfg.Retry.MaxAttempts = 1
+
+			generate := func(client *http.Client) {
+				t.Helper()
+				model, err := InitializeModel(&cfg, WithHTTPClient(client))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, stream := range []bool{false, tt.stream} {
+					var streamed strings.Builder
+					var options []llms.CallOption
+					if stream {
+						options = append(options, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+							streamed.Write(chunk)
+							return nil
+						}))
+					}
+					resp, err := model.GenerateContent(context.Background(), []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "hi")}, options...)
+					if err != nil {
+						t.Fatalf("stream=%v: %v", stream, err)
+					}
+					want := "hello from " + tt.backend
+					if got := resp.Choices[0].Content; got != want {
+						t.Errorf("stream=%v: content = %q, want %q", stream, got, want)
+					}
+					if stream && streamed.String() != want {
+						t.Errorf("streamed = %q, want %q", streamed.String(), want)
+					}
+				}
+			}
+
+			redirect := newRedirectClient(t, srv)
+			generate(&http.Client{Transport: httprecord.NewRecorder(cassette, redirect.Transport)})
+			srv.Close()
+
+			c, err := httprecord.Load(cassette)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := json.Marshal(c)
+			if strings.Contains(string(b), "secret") {
+				t.Errorf("cassette contains a secret:\n%s", b)
+			}
+
+			replayer, err := httprecord.NewReplayer(cassette)
+			if err != nil {
+				t.Fatal(err)
+			}
+			generate(&http.Client{Transport: replayer})
+			if n := replayer.Unused(); n != 0 {
+				t.Errorf("%d recorded interactions were not replayed", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package cgpt
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// CompletionResult is the result of a completion made with Complete.
+type CompletionResult struct {
+	Content string
+	// FinishReason is why the model stopped, as reported by the backend,
+	// such as "end_turn" or "stop". It may be empty.
+	FinishReason string
+	// Usage is the token usage, priced where possible. HasUsage reports
+	// whether the backend reported any.
+	Usage    Usage
+	HasUsage bool
+	// Backend and Model served the completion. They differ from the
+	// configured ones after a fallback.
+	Backend string
+	Model   string
+	// Latency is the time the completion took, and TimeToFirstToken the
+	// time until its first token, thinking included. Without streaming the
+	// first token arrives with the whole response.
+	Latency          time.Duration
+	TimeToFirstToken time.Duration
+	// GenerationInfo is the generation info the backend returned.
+	GenerationInfo map[string]any
+}
+
+// CompleteOptions are the options of a single Complete call.
+type CompleteOptions struct {
+	// CallOptions are passed to the model after those derived from the
+	// configuration, so they take precedence, such as
+	// llms.WithTemperature. They are not adjusted to the model's
+	// capabilities.
+	CallOptions []llms.CallOption
+	// OnText, if set, streams the response text as it arrives, where the
+	// model supports streaming, and otherwise receives it whole.
+	OnText func(text string)
+	// OnThinking, if set, is called with the model's thinking as it
+	// arrives. Otherwise thinking is discarded.
+	OnThinking func(text string)
+}
+
+// Complete generates a completion of messages, which it does not modify.
+// Unlike PerformCompletion, it leaves the service's conversation and
+// history alone; usage still counts towards the session and its budgets,
+// and the configured timeouts apply. It is safe for concurrent use.
+func (s *CompletionService) Complete(ctx context.Context, messages []llms.MessageContent, opts CompleteOptions) (*CompletionResult, error) {
+	conversation := &ChatCompletionPayload{Model: s.cfg.Model, Messages: messages}
+	if err := s.checkContextWindow(conversation.Messages); err != nil {
+		return nil, err
+	}
+	if err := s.checkBudget(); err != nil {
+		return nil, err
+	}
+
+	streaming := opts.OnText != nil && s.capabilities.Streaming
+	ctx, timer, cancel := s.withTimeouts(ctx, streaming)
+	defer cancel()
+
+	start := time.Now()
+	var (
+		firstToken     time.Duration
+		firstTokenOnce sync.Once
+	)
+	// Thinking may arrive on another goroutine.
+	markFirstToken := func() {
+		firstTokenOnce.Do(func() { firstToken = time.Since(start) })
+	}
+	ctx, _ = s.startThinking(ctx, conversation, func(text string) {
+		markFirstToken()
+		timer.token()
+		if opts.OnThinking != nil {
+			opts.OnThinking(text)
+		}
+	})
+
+	options := append(s.callOptions(), opts.CallOptions...)
+	if streaming {
+		options = append(options, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+			markFirstToken()
+			timer.token()
+			opts.OnText(string(chunk))
+			return nil
+		}))
+	}
+	resp, err := s.model.GenerateContent(ctx, s.prepareMessages(messages), options...)
+	latency := time.Since(start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate content: %w", timeoutError(ctx, err))
+	}
+	timer.token()
+	firstTokenOnce.Do(func() { firstToken = latency })
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("no response from model")
+	}
+	choice := resp.Choices[0]
+	if opts.OnText != nil && !streaming {
+		opts.OnText(choice.Content)
+	}
+
+	r := &CompletionResult{
+		Content:          choice.Content,
+		FinishReason:     choice.StopReason,
+		Latency:          latency,
+		TimeToFirstToken: firstToken,
+		GenerationInfo:   choice.GenerationInfo,
+	}
+	r.Backend, r.Model = s.activeModel()
+	r.Usage, r.HasUsage = s.accountUsage(r.Backend, r.Model, resp, latency)
+	return r, nil
+}
+
+// activeModel returns the backend and model that served the last call.
+func (s *CompletionService) activeModel() (backend, model string) {
+	backend, model = s.cfg.Backend, s.cfg.Model
+	if r, ok := s.model.(activeModelReporter); ok {
+		if b, m := r.ActiveModel(); b != "" {
+			backend, model = b, m
+		}
+	}
+	return backend, model
+}
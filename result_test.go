@@ -0,0 +1,147 @@
+package cgpt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestComplete(t *testing.T) {
+	info := map[string]any{"InputTokens": 1000, "OutputTokens": 100}
+	tests := []struct {
+		name   string
+		model  func() llms.Model
+		stream bool
+		want   CompletionResult
+	}{
+		{
+			name:   "streaming",
+			model:  func() llms.Model { return &stubModel{chunks: []string{"Hello", " world"}, info: info} },
+			stream: true,
+			want: CompletionResult{
+				Content:        "Hello world",
+				Usage:          Usage{InputTokens: 1000, OutputTokens: 100, Cost: 0.002},
+				HasUsage:       true,
+				Backend:        "dummy",
+				Model:          "dummy",
+				GenerationInfo: info,
+			},
+		},
+		{
+			name: "fallback",
+			model: func() llms.Model {
+				return &FallbackModel{Entries: []FallbackEntry{
+					{Backend: "dummy", ModelID: "dummy", Model: &stubModel{err: errors.New("API returned unexpected status code: 503")}},
+					{Backend: "other", ModelID: "backup", Model: &stubModel{chunks: []string{"from backup"}}},
+				}}
+			},
+			want: CompletionResult{
+				Content: "from backup",
+				Backend: "other",
+				Model:   "backup",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Backend: "dummy", Model: "dummy", Prices: map[string]Price{"dummy:*": {Input: 1, Output: 10}}}
+			s, err := NewCompletionService(cfg, tt.model(), WithStderr(&bytes.Buffer{}), WithStdout(&bytes.Buffer{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			messages := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "hi")}
+			var streamed []string
+			opts := CompleteOptions{CallOptions: []llms.CallOption{llms.WithTemperature(0.7)}}
+			if tt.stream {
+				opts.OnText = func(text string) { streamed = append(streamed, text) }
+			}
+			got, err := s.Complete(context.Background(), messages, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(&tt.want, got, cmpopts.IgnoreFields(CompletionResult{}, "Latency", "TimeToFirstToken"), cmpopts.EquateApprox(0, 1e-9)); diff != "" {
+				t.Errorf("Complete mismatch (-want +got):\n%s", diff)
+			}
+			if got.Latency <= 0 || got.TimeToFirstToken <= 0 || got.TimeToFirstToken > got.Latency {
+				t.Errorf("latency %v, time to first token %v, want 0 < first token <= latency", got.Latency, got.TimeToFirstToken)
+			}
+			if tt.stream && strings.Join(streamed, "|") != "Hello| world" {
+				t.Errorf("streamed %q, want the chunks", streamed)
+			}
+			if len(messages) != 1 || len(s.payload.Messages) != 0 || len(s.payload.Usage) != 0 {
+				t.Errorf("Complete modified the conversation: %d messages passed in, %d in the service", len(messages), len(s.payload.Messages))
+			}
+			if got.HasUsage && s.sessionRequests != 1 {
+				t.Errorf("session counts %d requests, want 1", s.sessionRequests)
+			}
+		})
+	}
+}
+
+func TestCompleteOptions(t *testing.T) {
+	cfg := &Config{Backend: "dummy", Model: "dummy", Temperature: 0.1, CompletionTimeout: 50 * time.Millisecond}
+	model := &stubModel{chunks: []string{"ok"}}
+	s, err := NewCompletionService(cfg, model, WithStderr(&bytes.Buffer{}), WithStdout(&bytes.Buffer{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "hi")}
+	if _, err := s.Complete(context.Background(), messages, CompleteOptions{CallOptions: []llms.CallOption{llms.WithTemperature(0.9)}}); err != nil {
+		t.Fatal(err)
+	}
+	if model.options.Temperature != 0.9 {
+		t.Errorf("temperature = %v, want the per-call 0.9", model.options.Temperature)
+	}
+	if model.options.StreamingFunc != nil {
+		t.Error("streamed without OnText")
+	}
+
+	model.delay = time.Second
+	if _, err := s.Complete(context.Background(), messages, CompleteOptions{}); !errors.Is(err, ErrCompletionTimeout) {
+		t.Errorf("Complete error = %v, want %v", err, ErrCompletionTimeout)
+	}
+}
+
+// TestCompleteConcurrent calls Complete from several goroutines. Run it
+// with -race.
+func TestCompleteConcurrent(t *testing.T) {
+	ledger := filepath.Join(t.TempDir(), "usage.jsonl")
+	cfg := &Config{Backend: "dummy", Model: "dummy", Prices: map[string]Price{"dummy:*": {Input: 1, Output: 10}}}
+	s, err := NewCompletionService(cfg, &choicesModel{}, WithStderr(&bytes.Buffer{}), WithStdout(&bytes.Buffer{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.usageLedger = ledger
+	messages := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "hi")}
+	const n = 8
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Complete(context.Background(), messages, CompleteOptions{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if s.sessionRequests != n {
+		t.Errorf("session counts %d requests, want %d", s.sessionRequests, n)
+	}
+	entries, err := ReadLedger(ledger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != n {
+		t.Errorf("ledger has %d entries, want %d", len(entries), n)
+	}
+}
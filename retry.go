@@ -0,0 +1,287 @@
+package cgpt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// RetryPolicy controls how failed completions are retried.
+// Zero fields fall back to the defaults in defaultRetryPolicy, as does a nil
+// Jitter.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	// Set to 1 to disable retries.
+	MaxAttempts int `yaml:"maxAttempts"`
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	// MaxBackoff caps the delay between attempts. A server asking for a longer
+	// wait (via Retry-After or rate limit headers) ends the retries.
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+	// Multiplier is the factor the backoff grows by after each attempt.
+	Multiplier float64 `yaml:"multiplier"`
+	// Jitter is the fraction of the backoff that is randomized, between 0 and
+	// 1. Set it to 0 to disable jitter.
+	Jitter *float64 `yaml:"jitter"`
+}
+
+var defaultJitter = 0.2
+
+var defaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Second,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+	Jitter:         &defaultJitter,
+}
+
+// merge returns p with its unset fields filled in from base.
+func (p RetryPolicy) merge(base RetryPolicy) RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = base.MaxAttempts
+	}
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = base.InitialBackoff
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = base.MaxBackoff
+	}
+	if p.Multiplier == 0 {
+		p.Multiplier = base.Multiplier
+	}
+	if p.Jitter == nil {
+		p.Jitter = base.Jitter
+	}
+	return p
+}
+
+// retryPolicy returns the effective retry policy for the named backend:
+// backend settings override the top-level retry settings, which override the defaults.
+func (cfg *Config) retryPolicy(backend string) RetryPolicy {
+	p := cfg.Retry.merge(defaultRetryPolicy)
+	if bc, ok := cfg.Backends[backend]; ok {
+		p = bc.Retry.merge(p)
+	}
+	return p
+}
+
+// backoff returns the jittered delay before the given retry (1 for the first retry).
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for i := 1; i < retry; i++ {
+		d *= p.Multiplier
+	}
+	d = min(d, float64(p.MaxBackoff))
+	if p.Jitter != nil && *p.Jitter > 0 {
+		d += d * *p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// RetryModel is an llms.Model that retries calls failing with a retryable
+// error. A streaming call is only retried if it failed before producing its
+// first chunk, so output is never duplicated.
+type RetryModel struct {
+	Model  llms.Model
+	Policy RetryPolicy
+
+	// RetryAfter, if set, returns the delay the server asked for in its most
+	// recent failed response.
+	RetryAfter func() (time.Duration, bool)
+
+	// OnRetry, if set, is called before sleeping ahead of a retry.
+	OnRetry func(attempt int, delay time.Duration, err error)
+
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func (m *RetryModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+func (m *RetryModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	opts := llms.CallOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	emitted := false
+	if opts.StreamingFunc != nil {
+		options = append(slices.Clone(options), llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+			emitted = true
+			return opts.StreamingFunc(ctx, chunk)
+		}))
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := m.Model.GenerateContent(ctx, messages, options...)
+		if err == nil || emitted || attempt >= m.Policy.MaxAttempts || ctx.Err() != nil || !isRetryableError(err) {
+			return resp, err
+		}
+		delay := m.Policy.backoff(attempt)
+		var (
+			d  time.Duration
+			ok bool
+		)
+		if m.RetryAfter != nil {
+			d, ok = m.RetryAfter()
+		}
+		var rd retryDelayer
+		if !ok && errors.As(err, &rd) {
+			d, ok = rd.retryDelay(), true
+		}
+		if ok {
+			if d > m.Policy.MaxBackoff {
+				return resp, fmt.Errorf("%w (server asked to retry after %v)", err, d)
+			}
+			delay = d
+		}
+		if m.OnRetry != nil {
+			m.OnRetry(attempt, delay, err)
+		}
+		sleep := m.sleep
+		if sleep == nil {
+			sleep = sleepContext
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryDelayer is implemented by errors that carry the delay the server
+// asked for, when it is not known from response headers.
+type retryDelayer interface {
+	retryDelay() time.Duration
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryHintTransport is an http.RoundTripper that records the retry delay
+// advertised by failed responses, so RetryModel can honor it.
+type retryHintTransport struct {
+	base http.RoundTripper
+
+	mu   sync.Mutex
+	hint time.Duration
+	ok   bool
+}
+
+func (t *retryHintTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || !isRetryableStatus(resp.StatusCode) {
+		return resp, err
+	}
+	d, ok := retryAfter(resp.Header, time.Now())
+	t.mu.Lock()
+	t.hint, t.ok = d, ok
+	t.mu.Unlock()
+	return resp, err
+}
+
+// take returns and clears the most recently recorded hint.
+func (t *retryHintTransport) take() (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d, ok := t.hint, t.ok
+	t.hint, t.ok = 0, false
+	return d, ok
+}
+
+// retryAfter extracts the delay a server asked for from response headers.
+// It understands the standard Retry-After header, OpenAI and Azure's
+// retry-after-ms, and the rate limit reset headers sent by OpenAI
+// (x-ratelimit-reset-*, as durations) and Anthropic
+// (anthropic-ratelimit-*-reset, as RFC 3339 timestamps).
+func retryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	if v := h.Get("retry-after-ms"); v != "" {
+		if ms, err := strconv.ParseFloat(v, 64); err == nil && ms >= 0 {
+			return time.Duration(ms * float64(time.Millisecond)), true
+		}
+	}
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil && secs >= 0 {
+			return time.Duration(secs * float64(time.Second)), true
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return max(t.Sub(now), 0), true
+		}
+	}
+
+	var (
+		longest time.Duration
+		found   bool
+	)
+	for key, values := range h {
+		key = strings.ToLower(key)
+		if len(values) == 0 {
+			continue
+		}
+		var d time.Duration
+		switch {
+		case strings.HasPrefix(key, "x-ratelimit-reset-"):
+			if rateLimitRemaining(h, "x-ratelimit-remaining-"+strings.TrimPrefix(key, "x-ratelimit-reset-")) {
+				continue
+			}
+			var err error
+			if d, err = time.ParseDuration(values[0]); err != nil {
+				continue
+			}
+		case strings.HasPrefix(key, "anthropic-ratelimit-") && strings.HasSuffix(key, "-reset"):
+			if rateLimitRemaining(h, strings.TrimSuffix(key, "-reset")+"-remaining") {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, values[0])
+			if err != nil {
+				continue
+			}
+			d = max(t.Sub(now), 0)
+		default:
+			continue
+		}
+		longest, found = max(longest, d), true
+	}
+	return longest, found
+}
+
+// rateLimitRemaining reports whether the named header shows quota remaining.
+func rateLimitRemaining(h http.Header, key string) bool {
+	n, err := strconv.Atoi(h.Get(key))
+	return err == nil && n > 0
+}
+
+// withRetryHints returns provider options whose HTTP client records retry
+// hints in a new retryHintTransport.
+func withRetryHints(mo *InferenceProviderOptions) (*InferenceProviderOptions, *retryHintTransport) {
+	client := http.DefaultClient
+	if mo.HTTPClient != nil {
+		client = mo.HTTPClient
+	}
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	hints := &retryHintTransport{base: base}
+	c := *client
+	c.Transport = hints
+	out := *mo
+	out.HTTPClient = &c
+	return &out, hints
+}
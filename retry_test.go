@@ -0,0 +1,232 @@
+package cgpt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    time.Duration
+		wantOK  bool
+	}{
+		{name: "none", headers: nil},
+		{name: "retry-after seconds", headers: map[string]string{"Retry-After": "3"}, want: 3 * time.Second, wantOK: true},
+		{name: "retry-after date", headers: map[string]string{"Retry-After": now.Add(5 * time.Second).Format(http.TimeFormat)}, want: 5 * time.Second, wantOK: true},
+		{name: "retry-after-ms", headers: map[string]string{"retry-after-ms": "250", "Retry-After": "1"}, want: 250 * time.Millisecond, wantOK: true},
+		{
+			name: "openai reset headers",
+			headers: map[string]string{
+				"x-ratelimit-remaining-requests": "0",
+				"x-ratelimit-reset-requests":     "1.5s",
+				"x-ratelimit-remaining-tokens":   "100",
+				"x-ratelimit-reset-tokens":       "6m0s",
+			},
+			want: 1500 * time.Millisecond, wantOK: true,
+		},
+		{
+			name: "anthropic reset headers",
+			headers: map[string]string{
+				"anthropic-ratelimit-tokens-remaining": "0",
+				"anthropic-ratelimit-tokens-reset":     now.Add(7 * time.Second).Format(time.RFC3339),
+			},
+			want: 7 * time.Second, wantOK: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+			got, ok := retryAfter(h, now)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("retryAfter() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyConfig(t *testing.T) {
+	cfg := &Config{
+		Retry:    RetryPolicy{MaxAttempts: 5},
+		Backends: map[string]BackendConfig{"openai": {Retry: RetryPolicy{MaxAttempts: 2, MaxBackoff: time.Minute}}},
+	}
+	if got := cfg.retryPolicy("anthropic"); got.MaxAttempts != 5 || got.MaxBackoff != defaultRetryPolicy.MaxBackoff {
+		t.Errorf("anthropic policy = %+v", got)
+	}
+	if got := cfg.retryPolicy("openai"); got.MaxAttempts != 2 || got.MaxBackoff != time.Minute || got.InitialBackoff != defaultRetryPolicy.InitialBackoff {
+		t.Errorf("openai policy = %+v", got)
+	}
+}
+
+func TestRetryPolicyDisableJitter(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configPath, []byte(`
+backend: dummy
+retry:
+  jitter: 0
+backends:
+  openai:
+    retry:
+      jitter: 0.5
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.String("config", "", "")
+	fs.Set("config", configPath)
+	cfg, err := LoadConfig(configPath, &bytes.Buffer{}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := cfg.retryPolicy("anthropic")
+	if p.Jitter == nil || *p.Jitter != 0 {
+		t.Fatalf("anthropic jitter = %v, want 0", p.Jitter)
+	}
+	for range 10 {
+		if got := p.backoff(1); got != defaultRetryPolicy.InitialBackoff {
+			t.Fatalf("backoff(1) = %v without jitter, want %v", got, defaultRetryPolicy.InitialBackoff)
+		}
+	}
+	if p := cfg.retryPolicy("openai"); p.Jitter == nil || *p.Jitter != 0.5 {
+		t.Errorf("openai jitter = %v, want 0.5", p.Jitter)
+	}
+}
+
+// redirectTransport sends every request to the target server.
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newRedirectClient(t *testing.T, srv *httptest.Server) *http.Client {
+	t.Helper()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Transport: redirectTransport{target: u}}
+}
+
+// writeOpenAIResponse writes a chat completion, as an event stream if requested.
+func writeOpenAIResponse(w http.ResponseWriter, r *http.Request, chunks ...string) {
+	if !strings.Contains(readBody(r), `"stream":true`) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":%q},"finish_reason":"stop"}]}`, strings.Join(chunks, ""))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, c := range chunks {
+		fmt.Fprintf(w, "data: {\"id\":\"1\",\"object\":\"chat.completion.chunk\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", c)
+	}
+	fmt.Fprint(w, "data: {\"id\":\"1\",\"object\":\"chat.completion.chunk\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func readBody(r *http.Request) string {
+	b, _ := io.ReadAll(r.Body)
+	return string(b)
+}
+
+func TestRetryModelAgainstServer(t *testing.T) {
+	tests := []struct {
+		name         string
+		stream       bool
+		failures     int
+		status       int
+		wantAttempts int32
+		wantErr      bool
+	}{
+		{name: "recovers after 429s", failures: 2, status: http.StatusTooManyRequests, wantAttempts: 3},
+		{name: "streaming recovers after 503", stream: true, failures: 1, status: http.StatusServiceUnavailable, wantAttempts: 2},
+		{name: "gives up after max attempts", failures: 5, status: http.StatusTooManyRequests, wantAttempts: 3, wantErr: true},
+		{name: "does not retry 400", failures: 1, status: http.StatusBadRequest, wantAttempts: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if int(attempts.Add(1)) <= tt.failures {
+					w.Header().Set("retry-after-ms", "1")
+					w.WriteHeader(tt.status)
+					fmt.Fprint(w, `{"error":{"message":"try again"}}`)
+					return
+				}
+				writeOpenAIResponse(w, r, "hello ", "world")
+			}))
+			defer srv.Close()
+
+			cfg := &Config{
+				Backend:      "openai",
+				Model:        "gpt-4o",
+				OpenAIAPIKey: "test-key",
+				Retry:        RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			}
+			model, err := InitializeModel(cfg, WithHTTPClient(newRedirectClient(t, srv)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var retries int
+			model.(*RetryModel).OnRetry = func(attempt int, delay time.Duration, err error) {
+				retries++
+				if delay != time.Millisecond {
+					t.Errorf("retry delay = %v, want server hint of 1ms", delay)
+				}
+			}
+
+			var streamed strings.Builder
+			var options []llms.CallOption
+			if tt.stream {
+				options = append(options, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+					streamed.Write(chunk)
+					return nil
+				}))
+			}
+			resp, err := model.GenerateContent(context.Background(), []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "hi")}, options...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GenerateContent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := attempts.Load(); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+			if retries != int(tt.wantAttempts)-1 {
+				t.Errorf("OnRetry called %d times, want %d", retries, tt.wantAttempts-1)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := resp.Choices[0].Content; got != "hello world" {
+				t.Errorf("content = %q, want %q", got, "hello world")
+			}
+			if tt.stream && streamed.String() != "hello world" {
+				t.Errorf("streamed = %q, want %q", streamed.String(), "hello world")
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package cgpt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// validateSampling checks that the configured sampling parameters are in
+// the ranges providers accept.
+func (cfg *Config) validateSampling() error {
+	switch {
+	case cfg.TopP < 0 || cfg.TopP > 1:
+		return fmt.Errorf("invalid top-p %v: want a value between 0 and 1", cfg.TopP)
+	case cfg.TopK < 0:
+		return fmt.Errorf("invalid top-k %d: want a positive value", cfg.TopK)
+	case cfg.FrequencyPenalty < -2 || cfg.FrequencyPenalty > 2:
+		return fmt.Errorf("invalid frequency penalty %v: want a value between -2 and 2", cfg.FrequencyPenalty)
+	case cfg.PresencePenalty < -2 || cfg.PresencePenalty > 2:
+		return fmt.Errorf("invalid presence penalty %v: want a value between -2 and 2", cfg.PresencePenalty)
+	}
+	for token, bias := range cfg.LogitBias {
+		if bias < -100 || bias > 100 {
+			return fmt.Errorf("invalid logit bias %v for token %s: want a value between -100 and 100", bias, token)
+		}
+	}
+	return nil
+}
+
+// parseLogitBias parses the token IDs and biases given as id=bias with
+// --logit-bias.
+func parseLogitBias(pairs map[string]string) (map[string]float64, error) {
+	bias := make(map[string]float64, len(pairs))
+	for token, value := range pairs {
+		b, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid logit bias %q for token %s: want a number", value, token)
+		}
+		bias[token] = b
+	}
+	return bias, nil
+}
+
+// samplingOptions returns the call options for the configured sampling
+// parameters the model supports, and warns about the rest.
+func (s *CompletionService) samplingOptions() []llms.CallOption {
+	cfg, caps := s.cfg, s.capabilities
+	params := []struct {
+		name      string
+		set       bool
+		supported bool
+		option    llms.CallOption
+	}{
+		{"stop sequences", len(cfg.Stop) > 0, caps.Stop, llms.WithStopWords(cfg.Stop)},
+		{"top-p", cfg.TopP > 0, caps.TopP, llms.WithTopP(cfg.TopP)},
+		{"top-k", cfg.TopK > 0, caps.TopK, llms.WithTopK(cfg.TopK)},
+		{"seed", cfg.Seed != 0 || cfg.seedSet, caps.Seed, llms.WithSeed(cfg.Seed)},
+		{"frequency penalty", cfg.FrequencyPenalty != 0, caps.Penalties, llms.WithFrequencyPenalty(cfg.FrequencyPenalty)},
+		{"presence penalty", cfg.PresencePenalty != 0, caps.Penalties, llms.WithPresencePenalty(cfg.PresencePenalty)},
+		{"JSON mode", cfg.JSONMode, caps.JSONMode, llms.WithJSONMode()},
+		// Logit bias has no call option; openAIRequestFields adds it to
+		// requests.
+		{"logit bias", len(cfg.LogitBias) > 0, caps.LogitBias, nil},
+	}
+	var options []llms.CallOption
+	for _, p := range params {
+		switch {
+		case !p.set:
+		case !p.supported:
+			s.noticef("%s does not support %s, ignoring it", cfg.Model, p.name)
+		case p.option != nil:
+			options = append(options, p.option)
+		}
+	}
+	return options
+}
+
+// openAIRequestFields returns the request fields OpenAI-compatible backends
+// need that the openai package does not send: top_p, logit_bias,
+// reasoning_effort, and a seed of 0, which it omits.
+func (cfg *Config) openAIRequestFields() map[string]any {
+	caps := cfg.modelCapabilities(cfg.Backend, cfg.Model)
+	fields := map[string]any{}
+	if cfg.TopP > 0 && caps.TopP {
+		fields["top_p"] = cfg.TopP
+	}
+	if cfg.seedSet && cfg.Seed == 0 && caps.Seed {
+		fields["seed"] = 0
+	}
+	if len(cfg.LogitBias) > 0 && caps.LogitBias {
+		fields["logit_bias"] = cfg.LogitBias
+	}
+	if effort := cfg.reasoningEffort(); effort != "" && caps.ReasoningEffort {
+		fields["reasoning_effort"] = effort
+	}
+	return fields
+}
+
+// withRequestFields returns a copy of client that adds fields to the JSON
+// body of chat completion requests. It returns client unchanged if there
+// are no fields.
+func withRequestFields(client *http.Client, fields map[string]any) *http.Client {
+	if len(fields) == 0 {
+		return client
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	c := *client
+	c.Transport = &requestFieldsTransport{fields: fields, base: client.Transport}
+	return &c
+}
+
+// requestFieldsTransport adds fields to the JSON body of chat completion
+// requests, unless they are already set.
+type requestFieldsTransport struct {
+	fields map[string]any
+	base   http.RoundTripper
+}
+
+func (t *requestFieldsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.Body == nil || !strings.HasSuffix(req.URL.Path, "/chat/completions") {
+		return base.RoundTrip(req)
+	}
+	b, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	var body map[string]any
+	if err := json.Unmarshal(b, &body); err == nil {
+		for k, v := range t.fields {
+			if _, ok := body[k]; !ok {
+				body[k] = v
+			}
+		}
+		if nb, err := json.Marshal(body); err == nil {
+			b = nb
+		}
+	}
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(b))
+	req.ContentLength = int64(len(b))
+	req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(b)), nil }
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,194 @@
+package cgpt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/pflag"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestSamplingOptions(t *testing.T) {
+	sampling := Config{
+		Stop:             []string{"END"},
+		TopP:             0.9,
+		TopK:             40,
+		Seed:             7,
+		FrequencyPenalty: 0.5,
+		PresencePenalty:  -0.5,
+		JSONMode:         true,
+		LogitBias:        map[string]float64{"50256": -100},
+	}
+	tests := []struct {
+		backend, model string
+		want           llms.CallOptions
+		wantNotices    []string
+	}{
+		{"ollama", "llama3.2", llms.CallOptions{StopWords: []string{"END"}, TopP: 0.9, TopK: 40, Seed: 7, FrequencyPenalty: 0.5, PresencePenalty: -0.5, JSONMode: true},
+			[]string{"logit bias"}},
+		{"anthropic", "claude-3-5-haiku-latest", llms.CallOptions{StopWords: []string{"END"}, TopP: 0.9},
+			[]string{"top-k", "seed", "frequency penalty", "presence penalty", "JSON mode", "logit bias"}},
+		{"openai", "gpt-4o", llms.CallOptions{StopWords: []string{"END"}, TopP: 0.9, Seed: 7, FrequencyPenalty: 0.5, PresencePenalty: -0.5, JSONMode: true},
+			[]string{"top-k"}},
+		{"openai", "o3-mini", llms.CallOptions{Seed: 7, JSONMode: true},
+			[]string{"stop sequences", "top-p", "top-k", "frequency penalty", "presence penalty", "logit bias"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.backend+":"+tt.model, func(t *testing.T) {
+			cfg := sampling
+			cfg.Backend, cfg.Model = tt.backend, tt.model
+			model := &stubModel{chunks: []string{"{}"}}
+			var stderr bytes.Buffer
+			s, err := NewCompletionService(&cfg, model, WithStderr(&stderr), WithStdout(&bytes.Buffer{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.payload.addUserMessage("hello")
+			if _, err := s.PerformCompletion(context.Background(), s.payload, PerformCompletionConfig{}); err != nil {
+				t.Fatal(err)
+			}
+			got := llms.CallOptions{
+				StopWords:        model.options.StopWords,
+				TopP:             model.options.TopP,
+				TopK:             model.options.TopK,
+				Seed:             model.options.Seed,
+				FrequencyPenalty: model.options.FrequencyPenalty,
+				PresencePenalty:  model.options.PresencePenalty,
+				JSONMode:         model.options.JSONMode,
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("call options mismatch (-want +got):\n%s", diff)
+			}
+			notices := stderr.String()
+			for _, want := range tt.wantNotices {
+				if !strings.Contains(notices, "does not support "+want) {
+					t.Errorf("notices %q do not mention %q", notices, want)
+				}
+			}
+			if n := strings.Count(notices, "does not support"); n != len(tt.wantNotices) {
+				t.Errorf("got %d notices, want %d: %q", n, len(tt.wantNotices), notices)
+			}
+		})
+	}
+}
+
+func TestValidateSampling(t *testing.T) {
+	for _, cfg := range []Config{
+		{TopP: 1.5},
+		{TopK: -1},
+		{FrequencyPenalty: 3},
+		{PresencePenalty: -2.5},
+		{LogitBias: map[string]float64{"1": 101}},
+	} {
+		if _, err := NewCompletionService(&cfg, &stubModel{}); err == nil {
+			t.Errorf("NewCompletionService(%+v) succeeded, want an error", cfg)
+		}
+	}
+}
+
+func TestOpenAIRequestFields(t *testing.T) {
+	var body map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = nil
+		json.Unmarshal(b, &body)
+		writeOpenAIResponse(w, r, "ok")
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		model string
+		want  map[string]any
+	}{
+		{"gpt-4o", map[string]any{"top_p": 0.5, "logit_bias": map[string]any{"50256": -100.0}}},
+		{"o1", map[string]any{}},
+	}
+	for _, tt := range tests {
+		cfg := &Config{
+			Backend:   "gateway",
+			Model:     tt.model,
+			Providers: map[string]ProviderConfig{"gateway": {BaseURL: srv.URL + "/v1"}},
+			TopP:      0.5,
+			LogitBias: map[string]float64{"50256": -100},
+		}
+		if tt.model == "o1" {
+			f := false
+			cfg.ModelCapabilities = map[string]ModelCapabilitiesOverride{"gateway:o1": {TopP: &f, LogitBias: &f}}
+		}
+		model, err := InitializeModel(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := llms.GenerateFromSinglePrompt(context.Background(), model, "hello"); err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]any{}
+		for _, k := range []string{"top_p", "logit_bias"} {
+			if v, ok := body[k]; ok {
+				got[k] = v
+			}
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%s: request fields mismatch (-want +got):\n%s", tt.model, diff)
+		}
+	}
+}
+
+func TestSeedZero(t *testing.T) {
+	var body map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = nil
+		json.Unmarshal(b, &body)
+		writeOpenAIResponse(w, r, "ok")
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		flags    []string
+		wantSeed bool
+	}{
+		{name: "unset"},
+		{name: "zero", flags: []string{"--seed=0"}, wantSeed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			fs.String("config", "", "")
+			fs.Int("seed", 0, "")
+			if err := fs.Parse(append([]string{"--config", configPath}, tt.flags...)); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := LoadConfig(configPath, &bytes.Buffer{}, fs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Backend, cfg.Model = "gateway", "gpt-4o"
+			cfg.Providers = map[string]ProviderConfig{"gateway": {BaseURL: srv.URL + "/v1"}}
+			model, err := InitializeModel(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := llms.GenerateFromSinglePrompt(context.Background(), model, "hello"); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := body["seed"]; ok != tt.wantSeed {
+				t.Errorf("request has a seed = %v, want %v (body %v)", ok, tt.wantSeed, body)
+			}
+		})
+	}
+}
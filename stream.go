@@ -0,0 +1,110 @@
+package cgpt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// StreamEventType is the type of a StreamEvent.
+type StreamEventType string
+
+const (
+	// StreamEventText is a delta of the response text.
+	StreamEventText StreamEventType = "text"
+	// StreamEventThinking is a delta of the model's thinking.
+	StreamEventThinking StreamEventType = "thinking"
+	// StreamEventToolCall is a delta of a tool call. Backends report tool
+	// calls once the response is complete, so each arrives whole.
+	StreamEventToolCall StreamEventType = "tool_call"
+	// StreamEventUsage is the token usage of the completion.
+	StreamEventUsage StreamEventType = "usage"
+	// StreamEventFinish ends a successful completion.
+	StreamEventFinish StreamEventType = "finish"
+	// StreamEventError ends a failed completion.
+	StreamEventError StreamEventType = "error"
+)
+
+// StreamEvent is an event of a streamed completion. Type says which of the
+// other fields is set.
+type StreamEvent struct {
+	Type StreamEventType
+	// Text is the text of a text or thinking delta.
+	Text     string
+	ToolCall *ToolCallDelta
+	Usage    *Usage
+	// FinishReason is why the model stopped, as reported by the backend,
+	// such as "end_turn" or "stop". It may be empty.
+	FinishReason string
+	Err          error
+}
+
+// ToolCallDelta is part of a tool call. Deltas with the same Index belong
+// to the same call, and their Arguments are concatenated.
+type ToolCallDelta struct {
+	Index     int
+	ID        string
+	Name      string
+	Arguments string
+}
+
+// PerformCompletionStreaming streams the text of a completion of payload.
+// It adapts PerformCompletionEvents: thinking is written to the thinking
+// output, and errors are logged, so callers never see them. New code should
+// use PerformCompletionEvents.
+func (s *CompletionService) PerformCompletionStreaming(ctx context.Context, payload *ChatCompletionPayload, cfg PerformCompletionConfig) (<-chan string, error) {
+	events, err := s.PerformCompletionEvents(ctx, payload, cfg)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		for ev := range events {
+			switch ev.Type {
+			case StreamEventText:
+				select {
+				case ch <- ev.Text:
+				case <-ctx.Done():
+				}
+			case StreamEventThinking:
+				s.writeThinking(ev.Text)
+			case StreamEventError:
+				if !errors.Is(ev.Err, context.Canceled) {
+					log.Printf("failed to generate content: %v", ev.Err)
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// streamCompletion streams a completion of the conversation to stdout,
+// with thinking going to the thinking output, and returns the error the
+// stream failed with, if any. Cancellation is not a failure.
+func (s *CompletionService) streamCompletion(ctx context.Context, runCfg RunOptions) error {
+	s.payload.Stream = true
+	events, err := s.PerformCompletionEvents(ctx, s.payload, PerformCompletionConfig{
+		ShowSpinner: runCfg.ShowSpinner,
+		EchoPrefill: runCfg.EchoPrefill,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to perform completion streaming: %w", err)
+	}
+	var streamErr error
+	for ev := range events {
+		switch ev.Type {
+		case StreamEventText:
+			runCfg.Stdout.Write([]byte(ev.Text))
+		case StreamEventThinking:
+			s.writeThinking(ev.Text)
+		case StreamEventError:
+			streamErr = ev.Err
+		}
+	}
+	if streamErr != nil && !errors.Is(streamErr, context.Canceled) {
+		return fmt.Errorf("failed to generate content: %w", streamErr)
+	}
+	return nil
+}
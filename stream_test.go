@@ -0,0 +1,145 @@
+package cgpt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testStreamScript = `responses:
+  - match: "hello"
+    chunks: ["Hi ", "there."]
+    usage: {inputTokens: 12, outputTokens: 4}
+    stopReason: end_turn
+  - match: "weather"
+    toolCalls:
+      - id: call_1
+        name: get_weather
+        arguments: '{"city": "Paris"}'
+    stopReason: tool_use
+  - match: "fail"
+    chunks: ["partial"]
+    error: "API returned unexpected status code: 400"
+`
+
+func TestPerformCompletionEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.yaml")
+	if err := os.WriteFile(path, []byte(testStreamScript), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		input       string
+		want        []StreamEvent
+		wantErr     string
+		wantMessage string
+	}{
+		{
+			input: "hello",
+			want: []StreamEvent{
+				{Type: StreamEventText, Text: "Hi "},
+				{Type: StreamEventText, Text: "there."},
+				{Type: StreamEventUsage, Usage: &Usage{InputTokens: 12, OutputTokens: 4}},
+				{Type: StreamEventFinish, FinishReason: "end_turn"},
+			},
+			wantMessage: "Hi there.",
+		},
+		{
+			input: "weather",
+			want: []StreamEvent{
+				{Type: StreamEventToolCall, ToolCall: &ToolCallDelta{ID: "call_1", Name: "get_weather", Arguments: `{"city": "Paris"}`}},
+				{Type: StreamEventFinish, FinishReason: "tool_use"},
+			},
+		},
+		{
+			input: "fail",
+			want: []StreamEvent{
+				{Type: StreamEventText, Text: "partial"},
+				{Type: StreamEventError},
+			},
+			wantErr:     "status code: 400",
+			wantMessage: "partial",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			db, err := NewScriptedDummyBackend(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := &Config{Backend: "dummy", Model: "dummy"}
+			s, err := NewCompletionService(cfg, db, WithStderr(&bytes.Buffer{}), WithStdout(&bytes.Buffer{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.payload.addUserMessage(tt.input)
+			events, err := s.PerformCompletionEvents(context.Background(), s.payload, PerformCompletionConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []StreamEvent
+			var gotErr error
+			for ev := range events {
+				if ev.Type == StreamEventError {
+					gotErr, ev.Err = ev.Err, nil
+				}
+				got = append(got, ev)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("events mismatch (-want +got):\n%s", diff)
+			}
+			if tt.wantErr == "" && gotErr != nil || tt.wantErr != "" && (gotErr == nil || !strings.Contains(gotErr.Error(), tt.wantErr)) {
+				t.Errorf("stream error = %v, want %q", gotErr, tt.wantErr)
+			}
+			if tt.wantMessage != "" {
+				if got := s.getLastUserMessage(); got != tt.wantMessage {
+					t.Errorf("last message = %q, want %q", got, tt.wantMessage)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamFailure(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	boom := errors.New("API returned unexpected status code: 400: boom")
+	for _, continuous := range []bool{false, true} {
+		dir := t.TempDir()
+		historyOut := filepath.Join(dir, "history.yaml")
+		cfg := &Config{Backend: "dummy", Model: "dummy"}
+		s, err := NewCompletionService(cfg, &stubModel{chunks: []string{"partial"}, err: boom}, WithStderr(&bytes.Buffer{}), WithStdout(&bytes.Buffer{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stdout bytes.Buffer
+		runCfg := RunOptions{Config: cfg, InputStrings: []string{"hi"}, HistoryOut: historyOut, StreamOutput: true, Stdout: &stdout}
+		if continuous {
+			// The continuous runner generates each response this way.
+			if err := s.configure(runCfg); err != nil {
+				t.Fatal(err)
+			}
+			s.payload.addUserMessage("hi")
+			err = s.generateResponse(context.Background(), runCfg)
+		} else {
+			err = s.Run(context.Background(), runCfg)
+		}
+		if !errors.Is(err, boom) {
+			t.Errorf("continuous=%v: error = %v, want %v", continuous, err, boom)
+		}
+		if !strings.HasPrefix(stdout.String(), "partial") {
+			t.Errorf("continuous=%v: stdout = %q, want the partial response", continuous, stdout.String())
+		}
+		b, err := os.ReadFile(historyOut)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), "text: partial") {
+			t.Errorf("continuous=%v: history does not keep the partial response:\n%s", continuous, b)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package cgpt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// ThinkingBlock is a block of a model's reasoning, kept with the assistant
+// message it preceded. Anthropic requires thinking blocks to be sent back
+// unchanged, with their signature, in some conversations.
+type ThinkingBlock struct {
+	// Type is "thinking", or "redacted_thinking" for reasoning the
+	// provider encrypted.
+	Type      string `json:"type"`
+	Thinking  string `json:"thinking,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Data      string `json:"data,omitempty"`
+}
+
+// MessageThinking is the thinking that preceded an assistant message.
+type MessageThinking struct {
+	// Message is the index of the assistant message.
+	Message int             `json:"message"`
+	Blocks  []ThinkingBlock `json:"blocks"`
+}
+
+// minThinkingBudget is the smallest thinking budget Anthropic accepts.
+const minThinkingBudget = 1024
+
+// reasoningEffortBudgets maps reasoning efforts to thinking budgets, for
+// backends that take a budget.
+var reasoningEffortBudgets = map[string]int{"low": minThinkingBudget, "medium": 4096, "high": 16384}
+
+// validateReasoning checks the thinking budget and reasoning effort.
+func (cfg *Config) validateReasoning() error {
+	if _, ok := reasoningEffortBudgets[cfg.ReasoningEffort]; cfg.ReasoningEffort != "" && !ok {
+		return fmt.Errorf("invalid reasoning effort %q: want low, medium or high", cfg.ReasoningEffort)
+	}
+	switch b := cfg.ThinkingBudget; {
+	case b < 0 || (b > 0 && b < minThinkingBudget):
+		return fmt.Errorf("invalid thinking budget %d: want at least %d tokens", b, minThinkingBudget)
+	case b > 0 && cfg.MaxTokens > 0 && b >= cfg.MaxTokens:
+		return fmt.Errorf("thinking budget %d must be less than max tokens %d", b, cfg.MaxTokens)
+	}
+	return nil
+}
+
+// thinkingBudget returns the thinking budget in tokens: the configured
+// budget, or one matching the reasoning effort.
+func (cfg *Config) thinkingBudget() int {
+	if cfg.ThinkingBudget > 0 {
+		return cfg.ThinkingBudget
+	}
+	return reasoningEffortBudgets[cfg.ReasoningEffort]
+}
+
+// reasoningEffort returns the reasoning effort: the configured effort, or
+// one matching the thinking budget.
+func (cfg *Config) reasoningEffort() string {
+	switch b := cfg.ThinkingBudget; {
+	case cfg.ReasoningEffort != "":
+		return cfg.ReasoningEffort
+	case b <= 0:
+		return ""
+	case b < reasoningEffortBudgets["medium"]:
+		return "low"
+	case b < reasoningEffortBudgets["high"]:
+		return "medium"
+	}
+	return "high"
+}
+
+// reasoningOptions warns if reasoning is configured for a model that does
+// not support it. Reasoning is requested by the backend's HTTP transport.
+func (s *CompletionService) reasoningOptions() {
+	caps := s.capabilities
+	if (s.cfg.ThinkingBudget > 0 || s.cfg.ReasoningEffort != "") && !caps.Thinking && !caps.ReasoningEffort {
+		s.noticef("%s does not support thinking budgets or reasoning effort, ignoring them", s.cfg.Model)
+	}
+}
+
+// thinkingCall carries thinking between a completion and the HTTP
+// transport of its backend, through the request context.
+type thinkingCall struct {
+	// previous holds the thinking of earlier turns, by the position of the
+	// assistant message among the assistant messages of the request.
+	previous map[int][]ThinkingBlock
+	// onThinking is called with thinking text as it arrives.
+	onThinking func(text string)
+
+	mu     sync.Mutex
+	blocks []ThinkingBlock
+}
+
+type thinkingCallKey struct{}
+
+func withThinkingCall(ctx context.Context, c *thinkingCall) context.Context {
+	return context.WithValue(ctx, thinkingCallKey{}, c)
+}
+
+func thinkingCallFrom(ctx context.Context) *thinkingCall {
+	c, _ := ctx.Value(thinkingCallKey{}).(*thinkingCall)
+	return c
+}
+
+// reset discards the thinking of a failed attempt.
+func (c *thinkingCall) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blocks = nil
+}
+
+func (c *thinkingCall) add(b ThinkingBlock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blocks = append(c.blocks, b)
+}
+
+// update applies f to the last block.
+func (c *thinkingCall) update(f func(*ThinkingBlock)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.blocks) > 0 {
+		f(&c.blocks[len(c.blocks)-1])
+	}
+}
+
+func (c *thinkingCall) result() []ThinkingBlock {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.blocks
+}
+
+func (c *thinkingCall) think(text string) {
+	if c.onThinking != nil && text != "" {
+		c.onThinking(text)
+	}
+}
+
+// startThinking returns a context that collects the thinking of a
+// completion of payload, passing it to onThinking as it arrives, and a
+// function that records the collected thinking with the assistant message
+// at the end of payload.
+func (s *CompletionService) startThinking(ctx context.Context, payload *ChatCompletionPayload, onThinking func(text string)) (context.Context, func()) {
+	previous := map[int][]ThinkingBlock{}
+	thinking := map[int][]ThinkingBlock{}
+	for _, t := range payload.Thinking {
+		thinking[t.Message] = t.Blocks
+	}
+	n := 0
+	for i, m := range payload.Messages {
+		if m.Role != llms.ChatMessageTypeAI {
+			continue
+		}
+		if blocks, ok := thinking[i]; ok {
+			previous[n] = blocks
+		}
+		n++
+	}
+	c := &thinkingCall{previous: previous, onThinking: onThinking}
+	return withThinkingCall(ctx, c), func() {
+		if blocks := c.result(); len(blocks) > 0 && len(payload.Messages) > 0 {
+			payload.Thinking = append(payload.Thinking, MessageThinking{Message: len(payload.Messages) - 1, Blocks: blocks})
+		}
+	}
+}
+
+// writeThinking writes thinking text to the thinking output, or dimmed to
+// stderr.
+func (s *CompletionService) writeThinking(text string) {
+	s.stderrMu.Lock()
+	defer s.stderrMu.Unlock()
+	if s.thinkingOutput != nil {
+		io.WriteString(s.thinkingOutput, text)
+		return
+	}
+	fmt.Fprintf(s.Stderr, "\033[38;5;240m%s\033[0m", text)
+}
+
+// WithThinkingOutput sets where model thinking is written. By default it is
+// written dimmed to stderr.
+func WithThinkingOutput(w io.Writer) CompletionServiceOption {
+	return func(s *CompletionService) {
+		s.thinkingOutput = w
+	}
+}
+
+// withAnthropicThinking returns a copy of client that enables extended
+// thinking with the given budget on Anthropic message requests. It returns
+// client unchanged if the budget is zero.
+func withAnthropicThinking(client *http.Client, budget int) *http.Client {
+	if budget <= 0 {
+		return client
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	c := *client
+	c.Transport = &anthropicThinkingTransport{budget: budget, base: client.Transport}
+	return &c
+}
+
+// anthropicThinkingTransport enables extended thinking, which the anthropic
+// package does not support. It adds the thinking of earlier turns to
+// requests, and takes thinking blocks out of responses, passing them to the
+// thinkingCall of the request context.
+type anthropicThinkingTransport struct {
+	budget int
+	base   http.RoundTripper
+}
+
+func (t *anthropicThinkingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.Body == nil || !strings.HasSuffix(req.URL.Path, "/messages") {
+		return base.RoundTrip(req)
+	}
+	call := thinkingCallFrom(req.Context())
+	if call == nil {
+		call = &thinkingCall{}
+	}
+	call.reset()
+
+	b, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if nb, err := t.rewriteRequest(b, call.previous); err == nil {
+		b = nb
+	}
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(b))
+	req.ContentLength = int64(len(b))
+	req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(b)), nil }
+
+	resp, err := base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		resp.Body = &thinkingStreamFilter{r: bufio.NewReader(resp.Body), body: resp.Body, call: call, dropped: map[int]bool{}}
+		return resp, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	body = stripThinking(body, call)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	return resp, nil
+}
+
+// rewriteRequest enables thinking in a messages request, and adds the
+// thinking of earlier turns to their assistant messages. Thinking does not
+// allow temperature or top-k to be set.
+func (t *anthropicThinkingTransport) rewriteRequest(b []byte, previous map[int][]ThinkingBlock) ([]byte, error) {
+	var body map[string]any
+	if err := json.Unmarshal(b, &body); err != nil {
+		return nil, err
+	}
+	body["thinking"] = map[string]any{"type": "enabled", "budget_tokens": t.budget}
+	delete(body, "temperature")
+	delete(body, "top_k")
+	messages, _ := body["messages"].([]any)
+	n := 0
+	for _, m := range messages {
+		msg, ok := m.(map[string]any)
+		if !ok || msg["role"] != "assistant" {
+			continue
+		}
+		if blocks := previous[n]; len(blocks) > 0 {
+			content := make([]any, 0, len(blocks)+1)
+			for _, b := range blocks {
+				content = append(content, b)
+			}
+			switch c := msg["content"].(type) {
+			case string:
+				content = append(content, map[string]any{"type": "text", "text": c})
+			case []any:
+				content = append(content, c...)
+			}
+			msg["content"] = content
+		}
+		n++
+	}
+	return json.Marshal(body)
+}
+
+// stripThinking removes thinking blocks from a messages response, passing
+// them to call.
+func stripThinking(body []byte, call *thinkingCall) []byte {
+	var resp map[string]any
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return body
+	}
+	content, _ := resp["content"].([]any)
+	kept := make([]any, 0, len(content))
+	for _, c := range content {
+		block, _ := c.(map[string]any)
+		if b, ok := thinkingBlock(block); ok {
+			call.add(b)
+			call.think(b.Thinking + "\n")
+			continue
+		}
+		kept = append(kept, c)
+	}
+	if len(kept) == len(content) {
+		return body
+	}
+	resp["content"] = kept
+	nb, err := json.Marshal(resp)
+	if err != nil {
+		return body
+	}
+	return nb
+}
+
+// thinkingBlock returns the thinking block in a content block, if it is one.
+func thinkingBlock(block map[string]any) (ThinkingBlock, bool) {
+	typ, _ := block["type"].(string)
+	if typ != "thinking" && typ != "redacted_thinking" {
+		return ThinkingBlock{}, false
+	}
+	b := ThinkingBlock{Type: typ}
+	b.Thinking, _ = block["thinking"].(string)
+	b.Signature, _ = block["signature"].(string)
+	b.Data, _ = block["data"].(string)
+	return b, true
+}
+
+// thinkingStreamFilter takes thinking blocks out of a messages event
+// stream, passing them to call, and renumbers the remaining content blocks
+// to close the gaps. It works on whole events, so that a dropped event takes
+// its event line with it.
+type thinkingStreamFilter struct {
+	r    *bufio.Reader
+	body io.Closer
+	call *thinkingCall
+	// dropped holds the indexes of the thinking blocks taken out.
+	dropped map[int]bool
+	// event holds the lines of the event being read.
+	event []byte
+	buf   []byte
+}
+
+func (f *thinkingStreamFilter) Read(p []byte) (int, error) {
+	for len(f.buf) == 0 {
+		line, err := f.r.ReadBytes('\n')
+		f.event = append(f.event, line...)
+		// A blank line ends an event.
+		if len(bytes.TrimRight(line, "\r\n")) == 0 || err != nil {
+			if len(f.event) > 0 {
+				f.buf = f.filter(f.event)
+			}
+			f.event = nil
+		}
+		if err != nil {
+			if len(f.buf) > 0 {
+				break
+			}
+			return 0, err
+		}
+	}
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	return n, nil
+}
+
+func (f *thinkingStreamFilter) Close() error {
+	return f.body.Close()
+}
+
+// filter returns event with thinking taken out: nil if the event belongs to a
+// thinking block, or the event with its data rewritten.
+func (f *thinkingStreamFilter) filter(event []byte) []byte {
+	lines := bytes.SplitAfter(event, []byte("\n"))
+	for i, line := range lines {
+		data, ok := bytes.CutPrefix(line, []byte("data: "))
+		if !ok {
+			continue
+		}
+		nd, drop := f.filterData(data)
+		if drop {
+			return nil
+		}
+		if nd != nil {
+			lines[i] = append(append([]byte("data: "), nd...), '\n')
+		}
+	}
+	return bytes.Join(lines, nil)
+}
+
+// filterData handles the data of an event. It reports whether the event
+// belongs to a thinking block, and otherwise returns the renumbered data, or
+// nil if it is unchanged.
+func (f *thinkingStreamFilter) filterData(data []byte) ([]byte, bool) {
+	var event map[string]any
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, false
+	}
+	index, ok := event["index"].(float64)
+	if !ok {
+		return nil, false
+	}
+	i := int(index)
+	switch event["type"] {
+	case "content_block_start":
+		block, _ := event["content_block"].(map[string]any)
+		if b, ok := thinkingBlock(block); ok {
+			f.dropped[i] = true
+			f.call.add(b)
+			f.call.think(b.Thinking)
+			return nil, true
+		}
+	case "content_block_delta":
+		if f.dropped[i] {
+			delta, _ := event["delta"].(map[string]any)
+			text, _ := delta["thinking"].(string)
+			signature, _ := delta["signature"].(string)
+			f.call.update(func(b *ThinkingBlock) {
+				b.Thinking += text
+				b.Signature += signature
+			})
+			f.call.think(text)
+			return nil, true
+		}
+	case "content_block_stop":
+		if f.dropped[i] {
+			f.call.think("\n")
+			return nil, true
+		}
+	}
+	shift := 0
+	for d := range f.dropped {
+		if d < i {
+			shift++
+		}
+	}
+	if shift == 0 {
+		return nil, false
+	}
+	event["index"] = i - shift
+	nb, err := json.Marshal(event)
+	if err != nil {
+		return nil, false
+	}
+	return nb, false
+}
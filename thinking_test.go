@@ -0,0 +1,197 @@
+package cgpt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// writeAnthropicThinkingResponse writes a messages API response with a
+// thinking block before the text, as an event stream if requested.
+func writeAnthropicThinkingResponse(w http.ResponseWriter, r *http.Request, thinking, text string) {
+	if !strings.Contains(readBody(r), `"stream":true`) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude","content":[{"type":"thinking","thinking":%q,"signature":"sig"},{"type":"text","text":%q}],"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":3}}`, thinking, text)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	event := func(name, data string) { fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data) }
+	event("message_start", `{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[],"model":"claude","usage":{"input_tokens":3,"output_tokens":0}}}`)
+	event("content_block_start", `{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`)
+	event("content_block_delta", fmt.Sprintf(`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":%q}}`, thinking))
+	event("content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig"}}`)
+	event("content_block_stop", `{"type":"content_block_stop","index":0}`)
+	event("content_block_start", `{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`)
+	event("content_block_delta", fmt.Sprintf(`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":%q}}`, text))
+	event("content_block_stop", `{"type":"content_block_stop","index":1}`)
+	event("message_delta", `{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":3}}`)
+	event("message_stop", `{"type":"message_stop"}`)
+}
+
+func TestAnthropicThinking(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	for _, stream := range []bool{true, false} {
+		t.Run(fmt.Sprintf("stream=%v", stream), func(t *testing.T) {
+			var requests []map[string]any
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				var body map[string]any
+				json.Unmarshal(b, &body)
+				requests = append(requests, body)
+				r.Body = io.NopCloser(bytes.NewReader(b))
+				writeAnthropicThinkingResponse(w, r, "Let me think.", "Hello")
+			}))
+			defer srv.Close()
+
+			cfg := &Config{Backend: "anthropic", Model: "claude-3-7-sonnet-20250219", AnthropicAPIKey: "key",
+				ReasoningEffort: "medium", Temperature: 0.5, Stream: stream}
+			cfg.Retry.MaxAttempts = 1
+			model, err := InitializeModel(cfg, WithHTTPClient(newRedirectClient(t, srv)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stdout, thinking bytes.Buffer
+			s, err := NewCompletionService(cfg, model, WithStdout(&stdout), WithStderr(&bytes.Buffer{}), WithThinkingOutput(&thinking))
+			if err != nil {
+				t.Fatal(err)
+			}
+			historyOut := filepath.Join(t.TempDir(), "history.yaml")
+			opts := RunOptions{Config: cfg, InputStrings: []string{"hi"}, HistoryOut: historyOut, StreamOutput: stream, Stdout: &stdout}
+			if err := s.Run(context.Background(), opts); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(stdout.String(), "Hello") || strings.Contains(stdout.String(), "think") {
+				t.Errorf("stdout = %q, want the answer without thinking", stdout.String())
+			}
+			if got := thinking.String(); got != "Let me think.\n" {
+				t.Errorf("thinking output = %q, want %q", got, "Let me think.\n")
+			}
+			req := requests[0]
+			if diff := cmp.Diff(map[string]any{"type": "enabled", "budget_tokens": 4096.0}, req["thinking"]); diff != "" {
+				t.Errorf("thinking request mismatch (-want +got):\n%s", diff)
+			}
+			if _, ok := req["temperature"]; ok {
+				t.Errorf("request sets temperature with thinking enabled")
+			}
+			wantThinking := []MessageThinking{{Message: 1, Blocks: []ThinkingBlock{{Type: "thinking", Thinking: "Let me think.", Signature: "sig"}}}}
+			if diff := cmp.Diff(wantThinking, s.payload.Thinking); diff != "" {
+				t.Errorf("payload thinking mismatch (-want +got):\n%s", diff)
+			}
+
+			// A reloaded conversation sends the thinking back with its turn.
+			stdout.Reset()
+			s2, err := NewCompletionService(cfg, model, WithStdout(&stdout), WithStderr(&bytes.Buffer{}), WithThinkingOutput(io.Discard))
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts.HistoryIn, opts.InputStrings = historyOut, []string{"and again"}
+			if err := s2.Run(context.Background(), opts); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(wantThinking, s2.payload.Thinking[:1]); diff != "" {
+				t.Errorf("reloaded thinking mismatch (-want +got):\n%s", diff)
+			}
+			messages := requests[1]["messages"].([]any)
+			content := messages[1].(map[string]any)["content"].([]any)
+			want := map[string]any{"type": "thinking", "thinking": "Let me think.", "signature": "sig"}
+			if diff := cmp.Diff(want, content[0]); diff != "" {
+				t.Errorf("resent thinking mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestThinkingStreamFilter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(`{"stream":true}`))
+	writeAnthropicThinkingResponse(rec, req, "Let me think.", "Hello")
+
+	call := &thinkingCall{}
+	body := io.NopCloser(rec.Body)
+	f := &thinkingStreamFilter{r: bufio.NewReader(body), body: body, call: call, dropped: map[int]bool{}}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(b)
+	if strings.Contains(got, "think") {
+		t.Errorf("filtered stream contains thinking:\n%s", got)
+	}
+	// Every remaining event keeps its event line together with its data.
+	events := strings.Split(strings.TrimSuffix(got, "\n\n"), "\n\n")
+	if len(events) != 6 {
+		t.Errorf("got %d events, want 6:\n%s", len(events), got)
+	}
+	for _, e := range events {
+		lines := strings.Split(e, "\n")
+		if len(lines) != 2 || !strings.HasPrefix(lines[0], "event: ") || !strings.HasPrefix(lines[1], "data: ") {
+			t.Errorf("malformed event %q", e)
+		}
+	}
+	if !strings.Contains(got, `"index":0,"type":"content_block_start"`) {
+		t.Errorf("text block not renumbered to index 0:\n%s", got)
+	}
+	want := []ThinkingBlock{{Type: "thinking", Thinking: "Let me think.", Signature: "sig"}}
+	if diff := cmp.Diff(want, call.blocks); diff != "" {
+		t.Errorf("thinking blocks mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReasoningEffort(t *testing.T) {
+	tests := []struct {
+		budget     int
+		effort     string
+		wantBudget int
+		wantEffort string
+	}{
+		{0, "", 0, ""},
+		{2000, "", 2000, "low"},
+		{8000, "", 8000, "medium"},
+		{20000, "", 20000, "high"},
+		{0, "high", 16384, "high"},
+		{2000, "high", 2000, "high"},
+	}
+	for _, tt := range tests {
+		cfg := &Config{Backend: "openai", Model: "o3-mini", ThinkingBudget: tt.budget, ReasoningEffort: tt.effort}
+		if got := cfg.thinkingBudget(); got != tt.wantBudget {
+			t.Errorf("thinkingBudget(%d, %q) = %d, want %d", tt.budget, tt.effort, got, tt.wantBudget)
+		}
+		if got := cfg.reasoningEffort(); got != tt.wantEffort {
+			t.Errorf("reasoningEffort(%d, %q) = %q, want %q", tt.budget, tt.effort, got, tt.wantEffort)
+		}
+		if got, _ := cfg.openAIRequestFields()["reasoning_effort"].(string); got != tt.wantEffort {
+			t.Errorf("reasoning_effort(%d, %q) = %q, want %q", tt.budget, tt.effort, got, tt.wantEffort)
+		}
+	}
+
+	for _, cfg := range []Config{
+		{ReasoningEffort: "maximum"},
+		{ThinkingBudget: 100},
+		{ThinkingBudget: 4096, MaxTokens: 4096},
+	} {
+		if err := cfg.validateReasoning(); err == nil {
+			t.Errorf("validateReasoning(%+v) succeeded, want an error", cfg)
+		}
+	}
+
+	var stderr bytes.Buffer
+	cfg := &Config{Backend: "openai", Model: "gpt-4o", ReasoningEffort: "low"}
+	s, err := NewCompletionService(cfg, &stubModel{chunks: []string{"ok"}}, WithStderr(&stderr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.callOptions()
+	if !strings.Contains(stderr.String(), "does not support thinking budgets or reasoning effort") {
+		t.Errorf("notices = %q, want a warning about reasoning effort", stderr.String())
+	}
+}
@@ -0,0 +1,117 @@
+package cgpt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrCompletionTimeout is returned when a completion does not finish
+	// within the completion timeout.
+	ErrCompletionTimeout = errors.New("completion timed out")
+	// ErrIdleTimeout is returned when a streaming completion produces no
+	// token for longer than the idle timeout.
+	ErrIdleTimeout = errors.New("timed out waiting for the next token")
+)
+
+// tokenTimer cancels a completion when its first token, or the next token
+// of a stream, does not arrive in time.
+type tokenTimer struct {
+	firstToken time.Duration
+	idle       time.Duration
+	cancel     context.CancelCauseFunc
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	started bool
+	stopped bool
+}
+
+// withTimeouts returns a context for a completion that is cancelled when the
+// completion timeout passes, or when the timer returned fails to see a token
+// in time. Without streaming, the whole response counts as the first token
+// and there is no idle timeout. With fallbacks, the fallback model applies
+// the first-token timeout to each of its models instead, so that a slow
+// model is moved on from rather than the completion cancelled. The returned
+// function releases the context's resources.
+func (s *CompletionService) withTimeouts(ctx context.Context, streaming bool) (context.Context, *tokenTimer, func()) {
+	var cancelTimeout context.CancelFunc = func() {}
+	if d := s.completionTimeout; d > 0 {
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, d, fmt.Errorf("%w after %v", ErrCompletionTimeout, d))
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	t := &tokenTimer{cancel: cancel}
+	if len(s.cfg.Fallbacks) == 0 {
+		t.firstToken = s.cfg.FirstTokenTimeout
+	}
+	if streaming {
+		t.idle = s.cfg.IdleTimeout
+	}
+	if t.firstToken > 0 {
+		t.timer = time.AfterFunc(t.firstToken, t.fire)
+	}
+	return ctx, t, func() {
+		t.stop()
+		cancel(nil)
+		cancelTimeout()
+	}
+}
+
+// token records the arrival of a token, restarting the idle timeout.
+func (t *tokenTimer) token() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return
+	}
+	t.started = true
+	switch {
+	case t.idle <= 0:
+		if t.timer != nil {
+			t.timer.Stop()
+		}
+	case t.timer == nil:
+		t.timer = time.AfterFunc(t.idle, t.fire)
+	default:
+		t.timer.Reset(t.idle)
+	}
+}
+
+func (t *tokenTimer) fire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return
+	}
+	if t.started {
+		t.cancel(fmt.Errorf("%w after %v", ErrIdleTimeout, t.idle))
+	} else {
+		t.cancel(fmt.Errorf("%w after %v", ErrFirstTokenTimeout, t.firstToken))
+	}
+}
+
+// stop stops the timer once the completion has finished.
+func (t *tokenTimer) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+}
+
+// timeoutError returns the timeout that cancelled ctx, if any, in place of
+// err, which is typically a less helpful context or network error.
+func timeoutError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	cause := context.Cause(ctx)
+	if errors.Is(cause, ErrCompletionTimeout) || errors.Is(cause, ErrFirstTokenTimeout) || errors.Is(cause, ErrIdleTimeout) {
+		return cause
+	}
+	return err
+}
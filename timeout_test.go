@@ -0,0 +1,162 @@
+package cgpt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// stallModel is a test model that streams its chunks, each after a delay,
+// then stalls until the call is cancelled.
+type stallModel struct {
+	chunks []string
+	delay  time.Duration
+}
+
+func (m *stallModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+func (m *stallModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	opts := llms.CallOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	for _, c := range m.chunks {
+		select {
+		case <-time.After(m.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if opts.StreamingFunc != nil {
+			if err := opts.StreamingFunc(ctx, []byte(c)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestCompletionTimeouts(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         Config
+		chunks      []string
+		delay       time.Duration
+		wantErr     error
+		wantPartial string
+	}{
+		{
+			name:        "completion",
+			cfg:         Config{CompletionTimeout: 100 * time.Millisecond},
+			chunks:      []string{"partial"},
+			wantErr:     ErrCompletionTimeout,
+			wantPartial: "partial",
+		},
+		{
+			name:    "first token",
+			cfg:     Config{FirstTokenTimeout: 50 * time.Millisecond, CompletionTimeout: time.Minute},
+			chunks:  []string{"late"},
+			delay:   time.Second,
+			wantErr: ErrFirstTokenTimeout,
+		},
+		{
+			name:        "idle",
+			cfg:         Config{FirstTokenTimeout: time.Second, IdleTimeout: 50 * time.Millisecond},
+			chunks:      []string{"one ", "two"},
+			delay:       10 * time.Millisecond,
+			wantErr:     ErrIdleTimeout,
+			wantPartial: "one two",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(log.Writer())
+
+			cfg := tt.cfg
+			cfg.Backend, cfg.Model = "dummy", "dummy"
+			s, err := NewCompletionService(&cfg, &stallModel{chunks: tt.chunks, delay: tt.delay}, WithStderr(&bytes.Buffer{}), WithStdout(&bytes.Buffer{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.payload.addUserMessage("hi")
+			ch, err := s.PerformCompletionStreaming(context.Background(), s.payload, PerformCompletionConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got strings.Builder
+			for chunk := range ch {
+				got.WriteString(chunk)
+			}
+			if got.String() != tt.wantPartial {
+				t.Errorf("streamed %q, want %q", got.String(), tt.wantPartial)
+			}
+			if !strings.Contains(logs.String(), tt.wantErr.Error()) {
+				t.Errorf("logged %q, want an error containing %q", logs.String(), tt.wantErr)
+			}
+			msgs := s.payload.Messages
+			if tt.wantPartial == "" {
+				if len(msgs) != 1 {
+					t.Errorf("got %d messages, want only the user message", len(msgs))
+				}
+				return
+			}
+			if len(msgs) != 2 || msgs[1].Parts[0].(llms.TextContent).Text != tt.wantPartial {
+				t.Errorf("messages = %v, want the partial response %q kept", msgs, tt.wantPartial)
+			}
+		})
+	}
+}
+
+func TestFirstTokenTimeoutFallback(t *testing.T) {
+	cfg := &Config{Backend: "anthropic", Model: "claude", FirstTokenTimeout: 50 * time.Millisecond, Fallbacks: []FallbackTarget{{Backend: "dummy"}}}
+	fm := &FallbackModel{
+		Entries: []FallbackEntry{
+			{Backend: "anthropic", ModelID: "claude", Model: &stallModel{chunks: []string{"late"}, delay: time.Second}},
+			{Backend: "dummy", ModelID: "dummy", Model: &stubModel{chunks: []string{"fallback"}, delay: 20 * time.Millisecond}},
+		},
+		FirstTokenTimeout: cfg.FirstTokenTimeout,
+	}
+	s, err := NewCompletionService(cfg, fm, WithStderr(&bytes.Buffer{}), WithStdout(&bytes.Buffer{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.payload.addUserMessage("hi")
+	ch, err := s.PerformCompletionStreaming(context.Background(), s.payload, PerformCompletionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got strings.Builder
+	for chunk := range ch {
+		got.WriteString(chunk)
+	}
+	if got.String() != "fallback" {
+		t.Errorf("streamed %q, want the fallback's response", got.String())
+	}
+}
+
+func TestCompletionTimeoutNonStreaming(t *testing.T) {
+	cfg := &Config{Backend: "dummy", Model: "dummy", CompletionTimeout: 50 * time.Millisecond}
+	s, err := NewCompletionService(cfg, &stallModel{}, WithStderr(&bytes.Buffer{}), WithStdout(&bytes.Buffer{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.payload.addUserMessage("hi")
+	start := time.Now()
+	_, err = s.PerformCompletion(context.Background(), s.payload, PerformCompletionConfig{})
+	if !errors.Is(err, ErrCompletionTimeout) {
+		t.Errorf("PerformCompletion error = %v, want %v", err, ErrCompletionTimeout)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("PerformCompletion took %v, want it cancelled after the timeout", d)
+	}
+}
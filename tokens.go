@@ -0,0 +1,291 @@
+package cgpt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// TokenCounter counts the tokens in text for a model. Counts are used to
+// check prompts against context windows before they are sent, and may be
+// estimates.
+type TokenCounter func(model, text string) int
+
+const (
+	// messageTokenOverhead is the number of tokens each message costs
+	// beyond its content, for its role and delimiters.
+	messageTokenOverhead = 4
+	// replyTokenOverhead is the number of tokens that prime the reply.
+	replyTokenOverhead = 3
+	// imageTokenEstimate is the number of tokens assumed for an image.
+	imageTokenEstimate = 1000
+)
+
+// tiktokenLoadTimeout bounds the wait for the tiktoken encoding, which is
+// downloaded on first use and cached in TIKTOKEN_CACHE_DIR or the temporary
+// directory.
+const tiktokenLoadTimeout = 5 * time.Second
+
+var (
+	tiktokenOnce sync.Once
+	tiktokenEnc  atomic.Pointer[tiktoken.Tiktoken]
+	// getTiktokenEncoding is replaced in tests.
+	getTiktokenEncoding = tiktoken.GetEncoding
+)
+
+// loadTiktoken loads the cl100k_base encoding, waiting for it at most
+// tiktokenLoadTimeout. As the encoding may have to be downloaded, it is
+// only loaded where token counts are shown: with --verbose and by
+// CountPromptTokens. The pre-flight context window check otherwise uses
+// estimates, and never waits on the network.
+func loadTiktoken() {
+	tiktokenOnce.Do(func() {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if enc, err := getTiktokenEncoding(tiktoken.MODEL_CL100K_BASE); err == nil {
+				tiktokenEnc.Store(enc)
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(tiktokenLoadTimeout):
+		}
+	})
+}
+
+// countTiktokenTokens counts tokens with tiktoken's cl100k_base encoding,
+// as used by OpenAI models, once loadTiktoken has loaded it, and falls back
+// to estimateTextTokens until then.
+func countTiktokenTokens(model, text string) int {
+	enc := tiktokenEnc.Load()
+	if enc == nil {
+		return estimateTextTokens(text)
+	}
+	return len(enc.EncodeOrdinary(text))
+}
+
+// countAnthropicTokens estimates Claude tokens. Claude's tokenizer is not
+// public; it produces roughly 15% more tokens than cl100k_base.
+func countAnthropicTokens(model, text string) int {
+	return int(math.Ceil(float64(countTiktokenTokens(model, text)) * 1.15))
+}
+
+// countEstimatedTokens estimates tokens from the length of text.
+func countEstimatedTokens(model, text string) int {
+	return estimateTextTokens(text)
+}
+
+// tokenCounter returns the token counter for the named backend.
+func (cfg *Config) tokenCounter(backend string) TokenCounter {
+	if b, ok := cfg.lookupBackend(backend); ok && b.CountTokens != nil {
+		return b.CountTokens
+	}
+	return countTiktokenTokens
+}
+
+// TokenCount is the counted size of a prompt.
+type TokenCount struct {
+	// Messages holds the tokens of each message, including its overhead.
+	Messages []MessageTokens `json:"messages"`
+	// Total is the tokens of the whole prompt.
+	Total int `json:"total"`
+	// ContextWindow is the model's context window, or zero if unknown.
+	ContextWindow int `json:"contextWindow"`
+	// MaxTokens is the room reserved for the response.
+	MaxTokens int `json:"maxTokens"`
+}
+
+// MessageTokens is the token count of a single message.
+type MessageTokens struct {
+	Role   llms.ChatMessageType `json:"role"`
+	Tokens int                  `json:"tokens"`
+}
+
+// Available returns the tokens available to the prompt: the context window
+// minus the room reserved for the response.
+func (c TokenCount) Available() int {
+	return c.ContextWindow - c.MaxTokens
+}
+
+// Exceeded reports whether the prompt does not fit in the context window.
+func (c TokenCount) Exceeded() bool {
+	return c.ContextWindow > 0 && c.Total > c.Available()
+}
+
+// ErrContextWindowExceeded is returned when a prompt does not fit in the
+// model's context window and the configuration asks for it to be refused.
+var ErrContextWindowExceeded = errors.New("prompt exceeds the model's context window")
+
+// CountTokens counts the tokens messages would use when sent to the
+// configured model.
+func (s *CompletionService) CountTokens(messages []llms.MessageContent) TokenCount {
+	count := s.cfg.tokenCounter(s.cfg.Backend)
+	c := TokenCount{
+		ContextWindow: s.capabilities.ContextWindow,
+		MaxTokens:     s.maxTokens(),
+		Total:         replyTokenOverhead,
+	}
+	for _, m := range messages {
+		n := messageTokenOverhead
+		for _, p := range m.Parts {
+			switch p := p.(type) {
+			case llms.TextContent:
+				n += count(s.cfg.Model, p.Text)
+			case llms.ImageURLContent, llms.BinaryContent:
+				n += imageTokenEstimate
+			default:
+				n += count(s.cfg.Model, fmt.Sprint(p))
+			}
+		}
+		c.Messages = append(c.Messages, MessageTokens{Role: m.Role, Tokens: n})
+		c.Total += n
+	}
+	return c
+}
+
+// InputTokens is the token count of one input source.
+type InputTokens struct {
+	Type   InputSourceType `json:"type"`
+	Name   string          `json:"name,omitempty"`
+	Tokens int             `json:"tokens"`
+}
+
+// PromptTokens is the token count of the prompt a run would send.
+type PromptTokens struct {
+	Backend string `json:"backend"`
+	Model   string `json:"model"`
+	TokenCount
+	// Inputs holds the tokens of each input source. Together they form the
+	// last user message.
+	Inputs []InputTokens `json:"inputs,omitempty"`
+}
+
+// CountPromptTokens builds the prompt a run with opts would send (loaded
+// history, system prompt, inputs and prefill) and counts its tokens. It
+// does not contact the backend.
+func CountPromptTokens(ctx context.Context, opts RunOptions) (*PromptTokens, error) {
+	loadTiktoken()
+	cfg := opts.Config
+	s := &CompletionService{
+		cfg:          cfg,
+		payload:      newCompletionPayload(cfg),
+		capabilities: cfg.modelCapabilities(cfg.Backend, cfg.Model),
+		Stdout:       io.Discard,
+		Stderr:       io.Discard,
+	}
+	if opts.HistoryIn != "" {
+		f, err := os.Open(opts.HistoryIn)
+		if err != nil {
+			return nil, fmt.Errorf("issue reading input history file: %w", err)
+		}
+		defer f.Close()
+		s.historyIn = f
+		if err := s.loadHistory(); err != nil {
+			return nil, fmt.Errorf("failed to load history: %w", err)
+		}
+	}
+	if err := s.setupSystemPrompt(); err != nil {
+		return nil, err
+	}
+
+	sources, err := opts.inputHandler().Sources(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get inputs: %w", err)
+	}
+	count := cfg.tokenCounter(cfg.Backend)
+	var input strings.Builder
+	var inputs []InputTokens
+	for _, src := range sources {
+		b, err := io.ReadAll(src.Reader)
+		if c, ok := src.Reader.(io.Closer); ok {
+			c.Close()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read inputs: %w", err)
+		}
+		input.Write(b)
+		inputs = append(inputs, InputTokens{Type: src.Type, Name: src.Name, Tokens: count(cfg.Model, string(b))})
+	}
+	if input.Len() > 0 {
+		s.payload.addUserMessage(input.String())
+	}
+	if opts.Prefill != "" {
+		s.SetNextCompletionPrefill(opts.Prefill)
+	} else if cfg.Prefill != "" {
+		s.SetNextCompletionPrefill(cfg.Prefill)
+	}
+
+	return &PromptTokens{
+		Backend:    cfg.Backend,
+		Model:      cfg.Model,
+		TokenCount: s.CountTokens(s.pendingMessages(s.payload)),
+		Inputs:     inputs,
+	}, nil
+}
+
+// pendingMessages returns the messages the next completion of payload
+// will send, including any prefill.
+func (s *CompletionService) pendingMessages(payload *ChatCompletionPayload) []llms.MessageContent {
+	if s.nextCompletionPrefill == "" {
+		return payload.Messages
+	}
+	return append(slices.Clone(payload.Messages), llms.TextParts(llms.ChatMessageTypeAI, s.nextCompletionPrefill))
+}
+
+// checkContextWindow counts the tokens in messages before they are sent,
+// and warns when they do not fit in the model's context window, or fails if
+// contextOverflow is "error". With --verbose, the count is shown per message.
+func (s *CompletionService) checkContextWindow(messages []llms.MessageContent) error {
+	if s.cfg.ContextOverflow == "ignore" && !s.verbose {
+		return nil
+	}
+	c := s.CountTokens(messages)
+	if s.verbose {
+		s.printTokenCount(c)
+	}
+	if !c.Exceeded() {
+		return nil
+	}
+	msg := fmt.Sprintf("prompt is about %d tokens, but %s has room for %d (%d context window minus %d max tokens)",
+		c.Total, s.cfg.Model, c.Available(), c.ContextWindow, c.MaxTokens)
+	switch s.cfg.ContextOverflow {
+	case "error":
+		return fmt.Errorf("%w: %s", ErrContextWindowExceeded, msg)
+	case "ignore":
+		return nil
+	}
+	s.noticef("%s", msg)
+	return nil
+}
+
+// printTokenCount shows a token count on stderr, broken down per message.
+func (s *CompletionService) printTokenCount(c TokenCount) {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', tabwriter.AlignRight)
+	for i, m := range c.Messages {
+		fmt.Fprintf(w, "%d\t%s\t%d\t\n", i+1, m.Role, m.Tokens)
+	}
+	fmt.Fprintf(w, "\ttotal\t%d\t\n", c.Total)
+	w.Flush()
+	fmt.Fprintf(s.Stderr, "\033[38;5;240mcgpt: prompt tokens for %s (estimated):\033[0m\n", s.cfg.Model)
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		fmt.Fprintf(s.Stderr, "\033[38;5;240mcgpt: %s\033[0m\n", line)
+	}
+	if c.ContextWindow > 0 {
+		fmt.Fprintf(s.Stderr, "\033[38;5;240mcgpt: %d of %d available (%d context window minus %d max tokens)\033[0m\n", c.Total, c.Available(), c.ContextWindow, c.MaxTokens)
+	}
+}
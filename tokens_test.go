@@ -0,0 +1,215 @@
+package cgpt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/tmc/langchaingo/llms"
+	"sigs.k8s.io/yaml"
+)
+
+func TestCountTokens(t *testing.T) {
+	cfg := &Config{Backend: "dummy", Model: "dummy", MaxTokens: 1000}
+	s, err := NewCompletionService(cfg, &stubModel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := s.CountTokens([]llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeSystem, "be brief"),
+		llms.TextParts(llms.ChatMessageTypeHuman, strings.Repeat("x", 400)),
+		{Role: llms.ChatMessageTypeHuman, Parts: []llms.ContentPart{llms.ImageURLContent{URL: "https://example.com/cat.png"}}},
+	})
+	wantMessages := []int{4 + 2, 4 + 100, 4 + imageTokenEstimate}
+	if len(c.Messages) != len(wantMessages) {
+		t.Fatalf("got %d messages, want %d", len(c.Messages), len(wantMessages))
+	}
+	want := replyTokenOverhead
+	for i, m := range c.Messages {
+		if m.Tokens != wantMessages[i] {
+			t.Errorf("message %d: %d tokens, want %d", i+1, m.Tokens, wantMessages[i])
+		}
+		want += wantMessages[i]
+	}
+	if c.Total != want {
+		t.Errorf("Total = %d, want %d", c.Total, want)
+	}
+	if c.ContextWindow != 1000000 || c.MaxTokens != 1000 {
+		t.Errorf("ContextWindow, MaxTokens = %d, %d, want 1000000, 1000", c.ContextWindow, c.MaxTokens)
+	}
+}
+
+func TestCheckContextWindow(t *testing.T) {
+	tests := []struct {
+		name        string
+		overflow    string
+		verbose     bool
+		prompt      string
+		wantErr     bool
+		wantCalled  bool
+		wantStderr  []string
+		avoidStderr []string
+	}{
+		{
+			name:        "fits",
+			prompt:      "short",
+			wantCalled:  true,
+			avoidStderr: []string{"prompt is about"},
+		},
+		{
+			name:       "warn by default",
+			prompt:     strings.Repeat("x", 400),
+			wantCalled: true,
+			wantStderr: []string{"prompt is about 107 tokens, but dummy has room for 50 (100 context window minus 50 max tokens)"},
+		},
+		{
+			name:     "error",
+			overflow: "error",
+			prompt:   strings.Repeat("x", 400),
+			wantErr:  true,
+		},
+		{
+			name:        "ignore",
+			overflow:    "ignore",
+			prompt:      strings.Repeat("x", 400),
+			wantCalled:  true,
+			avoidStderr: []string{"prompt is about"},
+		},
+		{
+			name:       "verbose breakdown",
+			verbose:    true,
+			prompt:     "short",
+			wantCalled: true,
+			wantStderr: []string{"prompt tokens for dummy", "1  human  6", "total  9", "9 of 50 available"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Backend:         "dummy",
+				Model:           "dummy",
+				MaxTokens:       50,
+				ContextOverflow: tt.overflow,
+				ModelCapabilities: map[string]ModelCapabilitiesOverride{
+					"dummy:*": {ContextWindow: 100},
+				},
+			}
+			model := &stubModel{chunks: []string{"ok"}}
+			var stderr bytes.Buffer
+			s, err := NewCompletionService(cfg, model, WithStderr(&stderr), WithStdout(&bytes.Buffer{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.verbose = tt.verbose
+			s.payload.addUserMessage(tt.prompt)
+
+			_, err = s.PerformCompletion(context.Background(), s.payload, PerformCompletionConfig{})
+			if tt.wantErr != errors.Is(err, ErrContextWindowExceeded) {
+				t.Errorf("error = %v, want ErrContextWindowExceeded: %v", err, tt.wantErr)
+			}
+			if called := model.calls > 0; called != tt.wantCalled {
+				t.Errorf("model called = %v, want %v", called, tt.wantCalled)
+			}
+			for _, want := range tt.wantStderr {
+				if !strings.Contains(stderr.String(), want) {
+					t.Errorf("stderr missing %q:\n%s", want, stderr.String())
+				}
+			}
+			for _, avoid := range tt.avoidStderr {
+				if strings.Contains(stderr.String(), avoid) {
+					t.Errorf("stderr contains %q:\n%s", avoid, stderr.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCountPromptTokens(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "input.txt")
+	if err := os.WriteFile(file, []byte(strings.Repeat("x", 40)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := yaml.Marshal(history{Messages: []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeSystem, "be brief"),
+		llms.TextParts(llms.ChatMessageTypeHuman, "earlier question"),
+		llms.TextParts(llms.ChatMessageTypeAI, "earlier answer"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	historyPath := filepath.Join(dir, "history.yaml")
+	if err := os.WriteFile(historyPath, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Backend: "dummy", Model: "dummy", MaxTokens: 100}
+	got, err := CountPromptTokens(context.Background(), RunOptions{
+		Config:         cfg,
+		InputFiles:     []string{file},
+		InputStrings:   []string{strings.Repeat("y", 20)},
+		PositionalArgs: []string{"why"},
+		HistoryIn:      historyPath,
+		Prefill:        "Because",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantInputs := []InputTokens{
+		{Type: InputSourceFile, Name: file, Tokens: 10},
+		{Type: InputSourceString, Tokens: 5},
+		{Type: InputSourceArg, Tokens: 1},
+	}
+	if diff := cmp.Diff(wantInputs, got.Inputs); diff != "" {
+		t.Errorf("inputs mismatch (-want +got):\n%s", diff)
+	}
+	var roles []llms.ChatMessageType
+	for _, m := range got.Messages {
+		roles = append(roles, m.Role)
+	}
+	wantRoles := []llms.ChatMessageType{llms.ChatMessageTypeSystem, llms.ChatMessageTypeHuman, llms.ChatMessageTypeAI, llms.ChatMessageTypeHuman, llms.ChatMessageTypeAI}
+	if diff := cmp.Diff(wantRoles, roles); diff != "" {
+		t.Errorf("roles mismatch (-want +got):\n%s", diff)
+	}
+	if got.Backend != "dummy" || got.ContextWindow == 0 || got.MaxTokens != 100 {
+		t.Errorf("got %s, context window %d, max tokens %d", got.Backend, got.ContextWindow, got.MaxTokens)
+	}
+}
+
+func TestCheckContextWindowOffline(t *testing.T) {
+	// Forget any loaded encoding, and fail loading it, as when offline.
+	enc, get := tiktokenEnc.Load(), getTiktokenEncoding
+	t.Cleanup(func() {
+		tiktokenEnc.Store(enc)
+		getTiktokenEncoding = get
+	})
+	tiktokenOnce = sync.Once{}
+	tiktokenEnc.Store(nil)
+	loads := 0
+	getTiktokenEncoding = func(string) (*tiktoken.Tiktoken, error) {
+		loads++
+		return nil, errors.New("offline")
+	}
+
+	cfg := &Config{Backend: "openai", Model: "gpt-4o"}
+	s, err := NewCompletionService(cfg, &stubModel{chunks: []string{"ok"}}, WithStderr(&bytes.Buffer{}), WithStdout(&bytes.Buffer{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.payload.addUserMessage(strings.Repeat("x", 400))
+	if _, err := s.PerformCompletion(context.Background(), s.payload, PerformCompletionConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if loads != 0 {
+		t.Errorf("the context window check loaded the tiktoken encoding %d times, want none", loads)
+	}
+	if c := s.CountTokens(s.payload.Messages[:1]); c.Total != replyTokenOverhead+messageTokenOverhead+100 {
+		t.Errorf("Total = %d, want an estimate of %d", c.Total, replyTokenOverhead+messageTokenOverhead+100)
+	}
+}
@@ -0,0 +1,246 @@
+package cgpt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// Usage is the token usage of a completion, and its cost.
+type Usage struct {
+	InputTokens  int `json:"inputTokens,omitempty"`
+	OutputTokens int `json:"outputTokens,omitempty"`
+	// CacheReadTokens and CacheWriteTokens are input tokens read from and
+	// written to the provider's prompt cache, where reported separately.
+	CacheReadTokens  int `json:"cacheReadTokens,omitempty"`
+	CacheWriteTokens int `json:"cacheWriteTokens,omitempty"`
+	// ReasoningTokens are the output tokens spent on reasoning. They are
+	// included in OutputTokens.
+	ReasoningTokens int `json:"reasoningTokens,omitempty"`
+	// Cost is the price of the completion in US dollars, if known.
+	Cost float64 `json:"cost,omitempty"`
+}
+
+// Add adds o to u.
+func (u *Usage) Add(o Usage) {
+	u.InputTokens += o.InputTokens
+	u.OutputTokens += o.OutputTokens
+	u.CacheReadTokens += o.CacheReadTokens
+	u.CacheWriteTokens += o.CacheWriteTokens
+	u.ReasoningTokens += o.ReasoningTokens
+	u.Cost += o.Cost
+}
+
+func (u Usage) empty() bool {
+	return u.InputTokens == 0 && u.OutputTokens == 0 && u.CacheReadTokens == 0 && u.CacheWriteTokens == 0
+}
+
+// usageKeys maps the GenerationInfo keys used by the backends to the Usage
+// field they report.
+var usageKeys = []struct {
+	keys  []string
+	field func(*Usage) *int
+}{
+	{[]string{"InputTokens", "PromptTokens", "input_tokens"}, func(u *Usage) *int { return &u.InputTokens }},
+	{[]string{"OutputTokens", "CompletionTokens", "output_tokens"}, func(u *Usage) *int { return &u.OutputTokens }},
+	{[]string{"CacheReadInputTokens", "CachedTokens", "PromptCachedTokens"}, func(u *Usage) *int { return &u.CacheReadTokens }},
+	{[]string{"CacheCreationInputTokens"}, func(u *Usage) *int { return &u.CacheWriteTokens }},
+	{[]string{"ReasoningTokens"}, func(u *Usage) *int { return &u.ReasoningTokens }},
+}
+
+// UsageFromResponse returns the usage reported in a response's generation
+// info, and whether any was reported.
+func UsageFromResponse(resp *llms.ContentResponse) (Usage, bool) {
+	var u Usage
+	if resp == nil || len(resp.Choices) == 0 || resp.Choices[0] == nil {
+		return u, false
+	}
+	info := resp.Choices[0].GenerationInfo
+	for _, k := range usageKeys {
+		for _, key := range k.keys {
+			if n, ok := intValue(info[key]); ok {
+				*k.field(&u) = n
+				break
+			}
+		}
+	}
+	return u, !u.empty()
+}
+
+func intValue(v any) (int, bool) {
+	switch v := v.(type) {
+	case int:
+		return v, true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// Price is the price of a model's tokens, in US dollars per million tokens.
+// Cached input tokens are priced separately from other input tokens.
+type Price struct {
+	Input      float64 `yaml:"input"`
+	Output     float64 `yaml:"output"`
+	CacheRead  float64 `yaml:"cacheRead"`
+	CacheWrite float64 `yaml:"cacheWrite"`
+}
+
+// Cost returns the price of u.
+func (p Price) Cost(u Usage) float64 {
+	return (float64(u.InputTokens)*p.Input +
+		float64(u.OutputTokens)*p.Output +
+		float64(u.CacheReadTokens)*p.CacheRead +
+		float64(u.CacheWriteTokens)*p.CacheWrite) / 1e6
+}
+
+// priceEntry associates a "backend:model" glob pattern with a price.
+type priceEntry struct {
+	pattern string
+	price   Price
+}
+
+// builtinModelPrices is the built-in price table. The first matching
+// pattern wins, so more specific patterns come first. Prices change; the
+// configuration's prices take precedence.
+var builtinModelPrices = []priceEntry{
+	{"anthropic:claude-3-7-sonnet*", Price{Input: 3, Output: 15, CacheRead: 0.30, CacheWrite: 3.75}},
+	{"anthropic:claude-3-5-sonnet*", Price{Input: 3, Output: 15, CacheRead: 0.30, CacheWrite: 3.75}},
+	{"anthropic:claude-3-5-haiku*", Price{Input: 0.80, Output: 4, CacheRead: 0.08, CacheWrite: 1}},
+	{"anthropic:claude-3-opus*", Price{Input: 15, Output: 75, CacheRead: 1.50, CacheWrite: 18.75}},
+	{"anthropic:claude-3-haiku*", Price{Input: 0.25, Output: 1.25, CacheRead: 0.03, CacheWrite: 0.30}},
+
+	{"openai:gpt-4o-mini*", Price{Input: 0.15, Output: 0.60, CacheRead: 0.075}},
+	{"openai:gpt-4o*", Price{Input: 2.50, Output: 10, CacheRead: 1.25}},
+	{"openai:o1-mini*", Price{Input: 1.10, Output: 4.40, CacheRead: 0.55}},
+	{"openai:o3-mini*", Price{Input: 1.10, Output: 4.40, CacheRead: 0.55}},
+	{"openai:o1*", Price{Input: 15, Output: 60, CacheRead: 7.50}},
+	{"openai:gpt-4-turbo*", Price{Input: 10, Output: 30}},
+	{"openai:gpt-3.5-turbo*", Price{Input: 0.50, Output: 1.50}},
+
+	{"googleai:gemini-1.5-pro*", Price{Input: 1.25, Output: 5}},
+	{"googleai:gemini-1.5-flash*", Price{Input: 0.075, Output: 0.30}},
+	{"googleai:gemini-2.0-flash*", Price{Input: 0.10, Output: 0.40}},
+
+	{"ollama:*", Price{}},
+	{"dummy:*", Price{}},
+}
+
+// modelPrice returns the price of a model, and whether it is known. Prices
+// in the configuration are keyed by "backend:model" glob patterns; the most
+// specific (longest) matching pattern wins over the built-in table.
+func (cfg *Config) modelPrice(backend, model string) (Price, bool) {
+	key := strings.ToLower(backend + ":" + model)
+	patterns := make([]string, 0, len(cfg.Prices))
+	for pattern := range cfg.Prices {
+		if matchModelPattern(strings.ToLower(pattern), key) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	if len(patterns) > 0 {
+		sort.Slice(patterns, func(i, j int) bool { return len(patterns[i]) > len(patterns[j]) })
+		return cfg.Prices[patterns[0]], true
+	}
+	for _, e := range builtinModelPrices {
+		if matchModelPattern(e.pattern, key) {
+			return e.price, true
+		}
+	}
+	return Price{}, false
+}
+
+// MessageUsage is the usage of the completion that produced a message.
+type MessageUsage struct {
+	// Message is the index of the assistant message in the conversation.
+	Message int    `json:"message"`
+	Backend string `json:"backend"`
+	Model   string `json:"model"`
+	Usage
+}
+
+// recordUsage records the usage reported in resp against the last message
+// of payload, which holds the response, and accounts for it with
+// accountUsage. It returns the usage, priced where possible, and whether
+// any was reported.
+func (s *CompletionService) recordUsage(payload *ChatCompletionPayload, resp *llms.ContentResponse, latency time.Duration) (Usage, bool) {
+	backend, model := s.activeModel()
+	u, ok := s.accountUsage(backend, model, resp, latency)
+	if ok {
+		payload.Usage = append(payload.Usage, MessageUsage{
+			Message: len(payload.Messages) - 1,
+			Backend: backend,
+			Model:   model,
+			Usage:   u,
+		})
+	}
+	return u, ok
+}
+
+// accountUsage prices the usage reported in resp and adds it to the
+// session's usage and the usage ledger. Completions that report no usage,
+// such as cache hits, are not recorded. With --verbose, the usage is shown
+// on stderr.
+func (s *CompletionService) accountUsage(backend, model string, resp *llms.ContentResponse, latency time.Duration) (Usage, bool) {
+	u, ok := UsageFromResponse(resp)
+	if ok {
+		price, priced := s.cfg.modelPrice(backend, model)
+		if priced {
+			u.Cost = price.Cost(u)
+		} else {
+			s.warnUnpriced(backend, model)
+		}
+		if s.verbose {
+			s.statusf("usage: %s", formatUsage(u, priced))
+		}
+		s.usageMu.Lock()
+		defer s.usageMu.Unlock()
+		s.sessionUnpriced = s.sessionUnpriced || !priced
+		s.sessionUsage.Add(u)
+		s.sessionRequests++
+		s.appendLedger(LedgerEntry{Backend: backend, Model: model, Usage: u, LatencyMs: latency.Milliseconds()})
+	}
+	return u, ok
+}
+
+// printSessionUsage shows the usage of all completions in the session on
+// stderr.
+func (s *CompletionService) printSessionUsage() {
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+	if s.sessionRequests == 0 {
+		return
+	}
+	requests := "requests"
+	if s.sessionRequests == 1 {
+		requests = "request"
+	}
+	fmt.Fprintf(s.Stderr, "\033[38;5;240mcgpt: session usage: %d %s, %s\033[0m\n", s.sessionRequests, requests, formatUsage(s.sessionUsage, !s.sessionUnpriced))
+}
+
+// formatUsage formats usage for display, such as
+// "1200 input, 300 output tokens, $0.0081".
+func formatUsage(u Usage, priced bool) string {
+	parts := []string{fmt.Sprintf("%d input", u.InputTokens)}
+	if u.CacheReadTokens > 0 {
+		parts = append(parts, fmt.Sprintf("%d cache read", u.CacheReadTokens))
+	}
+	if u.CacheWriteTokens > 0 {
+		parts = append(parts, fmt.Sprintf("%d cache write", u.CacheWriteTokens))
+	}
+	parts = append(parts, fmt.Sprintf("%d output", u.OutputTokens))
+	s := strings.Join(parts, ", ") + " tokens"
+	if u.ReasoningTokens > 0 {
+		s += fmt.Sprintf(" (%d reasoning)", u.ReasoningTokens)
+	}
+	if priced {
+		return s + fmt.Sprintf(", $%.4f", u.Cost)
+	}
+	return s + ", cost unknown"
+}
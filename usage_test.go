@@ -0,0 +1,163 @@
+package cgpt
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tmc/langchaingo/llms"
+	"sigs.k8s.io/yaml"
+)
+
+func TestUsageFromResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		info   map[string]any
+		want   Usage
+		wantOK bool
+	}{
+		{
+			name:   "anthropic",
+			info:   map[string]any{"InputTokens": 10, "OutputTokens": 20, "CacheReadInputTokens": 5, "CacheCreationInputTokens": 7},
+			want:   Usage{InputTokens: 10, OutputTokens: 20, CacheReadTokens: 5, CacheWriteTokens: 7},
+			wantOK: true,
+		},
+		{
+			name:   "openai",
+			info:   map[string]any{"PromptTokens": 10, "CompletionTokens": 20, "TotalTokens": 30, "ReasoningTokens": 12},
+			want:   Usage{InputTokens: 10, OutputTokens: 20, ReasoningTokens: 12},
+			wantOK: true,
+		},
+		{
+			name:   "googleai",
+			info:   map[string]any{"input_tokens": int32(10), "output_tokens": int32(20), "total_tokens": int32(30)},
+			want:   Usage{InputTokens: 10, OutputTokens: 20},
+			wantOK: true,
+		},
+		{
+			name:   "decoded json",
+			info:   map[string]any{"InputTokens": float64(10)},
+			want:   Usage{InputTokens: 10},
+			wantOK: true,
+		},
+		{name: "none", info: map[string]any{"InputTokens": 0}},
+		{name: "no info"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &llms.ContentResponse{Choices: []*llms.ContentChoice{{GenerationInfo: tt.info}}}
+			got, ok := UsageFromResponse(resp)
+			if ok != tt.wantOK {
+				t.Errorf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if got != tt.want {
+				t.Errorf("usage = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModelPrice(t *testing.T) {
+	cfg := &Config{Prices: map[string]Price{
+		"openai:*":       {Input: 1, Output: 1},
+		"openai:gpt-4o*": {Input: 2, Output: 8},
+	}}
+	tests := []struct {
+		backend, model string
+		want           Price
+		wantOK         bool
+	}{
+		{"anthropic", "claude-3-7-sonnet-20250219", Price{Input: 3, Output: 15, CacheRead: 0.30, CacheWrite: 3.75}, true},
+		{"openai", "gpt-4o-mini", Price{Input: 2, Output: 8}, true},
+		{"openai", "o3-mini", Price{Input: 1, Output: 1}, true},
+		{"ollama", "llama3.2", Price{}, true},
+		{"googleai", "gemini-exp", Price{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := cfg.modelPrice(tt.backend, tt.model)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("modelPrice(%q, %q) = %+v, %v, want %+v, %v", tt.backend, tt.model, got, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	cost := Price{Input: 3, Output: 15, CacheRead: 0.3}.Cost(Usage{InputTokens: 1200, OutputTokens: 300, CacheReadTokens: 50})
+	if math.Abs(cost-0.008115) > 1e-9 {
+		t.Errorf("Cost = %v, want 0.008115", cost)
+	}
+}
+
+func TestRecordUsage(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		name := "non-streaming"
+		if stream {
+			name = "streaming"
+		}
+		t.Run(name, func(t *testing.T) {
+			historyOut := filepath.Join(t.TempDir(), "history.yaml")
+			cfg := &Config{
+				Backend: "dummy",
+				Model:   "dummy",
+				Prices:  map[string]Price{"dummy:*": {Input: 1, Output: 2}},
+			}
+			model := &stubModel{chunks: []string{"hello"}, info: map[string]any{"InputTokens": 100000, "OutputTokens": 50000}}
+			var stderr bytes.Buffer
+			s, err := NewCompletionService(cfg, model, WithStderr(&stderr), WithStdout(&bytes.Buffer{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.verbose = true
+			s.historyOutFile = historyOut
+
+			for _, input := range []string{"hi", "again"} {
+				s.payload.addUserMessage(input)
+				if stream {
+					ch, err := s.PerformCompletionStreaming(context.Background(), s.payload, PerformCompletionConfig{})
+					if err != nil {
+						t.Fatal(err)
+					}
+					for range ch {
+					}
+				} else if _, err := s.PerformCompletion(context.Background(), s.payload, PerformCompletionConfig{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if want := "cgpt: usage: 100000 input, 50000 output tokens, $0.2000"; !strings.Contains(stderr.String(), want) {
+				t.Errorf("stderr missing %q:\n%s", want, stderr.String())
+			}
+			s.printSessionUsage()
+			if want := "cgpt: session usage: 2 requests, 200000 input, 100000 output tokens, $0.4000"; !strings.Contains(stderr.String(), want) {
+				t.Errorf("stderr missing %q:\n%s", want, stderr.String())
+			}
+
+			if err := s.saveHistory(); err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(historyOut)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var h history
+			if err := yaml.Unmarshal(b, &h); err != nil {
+				t.Fatal(err)
+			}
+			u := Usage{InputTokens: 100000, OutputTokens: 50000, Cost: 0.2}
+			want := []MessageUsage{
+				{Message: 1, Backend: "dummy", Model: "dummy", Usage: u},
+				{Message: 3, Backend: "dummy", Model: "dummy", Usage: u},
+			}
+			if diff := cmp.Diff(want, h.Usage); diff != "" {
+				t.Errorf("history usage mismatch (-want +got):\n%s", diff)
+			}
+			for _, mu := range h.Usage {
+				if role := h.Messages[mu.Message].Role; role != llms.ChatMessageTypeAI {
+					t.Errorf("usage recorded against a %s message", role)
+				}
+			}
+		})
+	}
+}